@@ -0,0 +1,82 @@
+package merkle
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ValidationRequest holds the arguments of a ValidatePartialTree call, for ValidateBatch.
+type ValidationRequest struct {
+	LeafIndices  []uint64
+	Leaves       [][]byte
+	Proof        [][]byte
+	ExpectedRoot []byte
+	// Hash is assumed to be non-reentrant, and may be shared with other requests, so ValidateBatch validates the
+	// requests that only set Hash one at a time. It's ignored if NewHash is set.
+	Hash HashFunc
+	// NewHash, if set, returns a hash function used only for this request, so the request can be validated in
+	// parallel with others, e.g. func() HashFunc { return HashFuncFromStdHash(sha3.New256) }.
+	NewHash func() HashFunc
+	Options []ValidationOption
+}
+
+// ValidationResult holds the results of a ValidatePartialTree call, for ValidateBatch.
+type ValidationResult struct {
+	Valid bool
+	Err   error
+}
+
+// ValidateBatch validates every request with ValidatePartialTree, using up to workers goroutines. Requests that set
+// NewHash are validated in parallel, while requests that only set Hash are validated one after the other by the same
+// goroutine. The results are in the order of the requests. A request that fails validation doesn't stop the others;
+// its error is reported in its result. An error is only returned for invalid arguments.
+func ValidateBatch(reqs []ValidationRequest, workers int) ([]ValidationResult, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("number of workers must be positive, got %d", workers)
+	}
+	if workers > len(reqs) {
+		workers = len(reqs)
+	}
+	var serial []int
+	for i, req := range reqs {
+		if req.NewHash == nil {
+			serial = append(serial, i)
+		}
+	}
+	results := make([]ValidationResult, len(reqs))
+	validate := func(i int) {
+		req := reqs[i]
+		hash := req.Hash
+		if req.NewHash != nil {
+			hash = req.NewHash()
+		}
+		valid, err := ValidatePartialTree(req.LeafIndices, req.Leaves, req.Proof, req.ExpectedRoot, hash,
+			req.Options...)
+		results[i] = ValidationResult{Valid: valid, Err: err}
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			if w == 0 {
+				// The requests that share hash functions are all validated by the first worker.
+				for _, i := range serial {
+					validate(i)
+				}
+			}
+			for i := range next {
+				validate(i)
+			}
+		}(w)
+	}
+	for i, req := range reqs {
+		if req.NewHash != nil {
+			next <- i
+		}
+	}
+	close(next)
+	wg.Wait()
+	return results, nil
+}
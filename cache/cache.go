@@ -3,7 +3,12 @@ package cache
 import (
 	"errors"
 	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"sync"
 
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
 	"github.com/spacemeshos/merkle-tree/shared"
 )
 
@@ -39,6 +44,16 @@ func NewWriter(shouldCacheLayer CachingPolicy, generateLayer LayerFactory) *Writ
 	}
 }
 
+// NewWriterWithWidthPolicy returns a writer that caches every layer while the tree is built. When GetReader is called
+// and the width of the base layer is known, the layers that policy rejects are closed and dropped; the base layer is
+// always kept. This allows policies like TopLayersPolicy, which depend on the final height of the tree, at the cost of
+// caching every layer until GetReader is called. The tree must be complete when GetReader is called.
+func NewWriterWithWidthPolicy(policy WidthCachingPolicy, generateLayer LayerFactory) *Writer {
+	w := NewWriter(MinHeightPolicy(0), generateLayer)
+	w.widthPolicy = policy
+	return w
+}
+
 func (c *Writer) SetLayer(layerHeight uint, rw LayerReadWriter) {
 	c.layers[layerHeight] = rw
 }
@@ -60,6 +75,34 @@ func (c *Writer) SetHash(hashFunc HashFunc) {
 	c.hash = hashFunc
 }
 
+// GetHashFunc returns the hash function previously set on the cache, or nil if none was set.
+func (c *Writer) GetHashFunc() HashFunc {
+	return c.hash
+}
+
+// HashName returns the name of the hash function of the tree that writes to the cache, or an empty string if it's
+// unknown. See merkle.TreeBuilder.WithHashName.
+func (c *Writer) HashName() string {
+	return c.hashName
+}
+
+// SetHashName records the name of the hash function of the tree that writes to the cache.
+func (c *Writer) SetHashName(name string) {
+	c.hashName = name
+}
+
+// SetPadToPowerOfTwo records whether the tree that writes to the cache pads to a power of two. See
+// merkle.TreeBuilder.WithPadToPowerOfTwo.
+func (c *Writer) SetPadToPowerOfTwo(padToPowerOfTwo bool) {
+	c.padToPowerOfTwo = padToPowerOfTwo
+}
+
+// SetPaddingValue records the node that the tree that writes to the cache pads with, if it's not a zero node. See
+// merkle.TreeBuilder.WithPaddingValue.
+func (c *Writer) SetPaddingValue(padding []byte) {
+	c.paddingValue = padding
+}
+
 func (c *Writer) Close() {
 	for _, layer := range c.layers {
 		layer.Close()
@@ -73,16 +116,76 @@ func (c *Writer) GetReader() (CacheReader, error) {
 	if err := c.flush(); err != nil {
 		return nil, err
 	}
+	if err := c.applyWidthPolicy(); err != nil {
+		return nil, err
+	}
 	if err := c.validateStructure(); err != nil {
 		return nil, err
 	}
 	return &Reader{c.cache}, nil
 }
 
+// StaleLayers returns the heights of the cached layers, in ascending order, whose width doesn't match the width implied
+// by the base layer. This happens when leaves are appended to the base layer after the upper layers were written, and
+// those layers need to be rebuilt before the cache can be read. The layers are flushed first.
+func (c *Writer) StaleLayers() ([]uint, error) {
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	base, found := c.layers[0]
+	if !found {
+		return nil, errors.New("reader for base layer must be included")
+	}
+	width, err := base.Width()
+	if err != nil {
+		return nil, fmt.Errorf("while getting base layer width: %w", err)
+	}
+	var stale []uint
+	for height, layer := range c.layers {
+		layerWidth, err := layer.Width()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get width for layer %d: %w", height, err)
+		}
+		if layerWidth != width>>height {
+			stale = append(stale, height)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i] < stale[j] })
+	return stale, nil
+}
+
+// applyWidthPolicy closes and drops the layers rejected by the width policy, if the writer has one.
+func (c *Writer) applyWidthPolicy() error {
+	if c.widthPolicy == nil {
+		return nil
+	}
+	base, found := c.layers[0]
+	if !found {
+		return errors.New("reader for base layer must be included")
+	}
+	width, err := base.Width()
+	if err != nil {
+		return fmt.Errorf("while getting base layer width: %w", err)
+	}
+	for height, layer := range c.layers {
+		if height != 0 && !c.widthPolicy(height, width) {
+			if err := layer.Close(); err != nil {
+				return fmt.Errorf("while closing layer %d: %w", height, err)
+			}
+			delete(c.layers, height)
+		}
+	}
+	return nil
+}
+
 func (c *Writer) flush() error {
 	var lastErr error
 	for _, layer := range c.layers {
 		lastErr = layer.Flush()
+		// Read-only layers, like those of a reopened disk cache, have nothing to flush.
+		if errors.Is(lastErr, readwriters.ErrReadOnlyLayer) {
+			lastErr = nil
+		}
 	}
 	return lastErr
 }
@@ -114,11 +217,156 @@ func (c *Reader) GetCachingPolicy() CachingPolicy {
 	return c.shouldCacheLayer
 }
 
+// ValidatedWidth returns the width of the layer at the given height when GetReader validated the cache. Layers only
+// grow, so a layer that's now shorter than that was truncated.
+func (c *Reader) ValidatedWidth(layerHeight uint) (width uint64, found bool) {
+	width, found = c.widths[layerHeight]
+	return width, found
+}
+
+// IsFullyCached returns true iff every layer from the base layer up to the root height is cached with the expected
+// width. Layers that would hold no complete nodes (e.g. the root layer of an unbalanced tree) aren't required.
+func (c *Reader) IsFullyCached() (bool, error) {
+	width, err := c.layers[0].Width()
+	if err != nil {
+		return false, fmt.Errorf("while getting base layer width: %w", err)
+	}
+	rootHeight := RootHeightFromWidth(width)
+	for height := uint(0); height <= rootHeight; height++ {
+		expectedWidth := width >> height
+		if expectedWidth == 0 {
+			continue
+		}
+		layer, found := c.layers[height]
+		if !found {
+			return false, nil
+		}
+		layerWidth, err := layer.Width()
+		if err != nil {
+			return false, fmt.Errorf("failed to get width for layer %d: %w", height, err)
+		}
+		if layerWidth != expectedWidth {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SubtreeRoot returns the root of the subtree whose leaves are [lo, hi). The range must describe a complete subtree:
+// its size must be a power of 2, lo must be a multiple of that size and hi must not exceed the base layer width. The
+// root is read from the cache if its layer is cached, otherwise it's calculated from the highest cached layer below it,
+// by streaming the layer's nodes.
+func (c *Reader) SubtreeRoot(lo, hi uint64) ([]byte, error) {
+	size := hi - lo
+	if hi <= lo || size&(size-1) != 0 || lo%size != 0 {
+		return nil, fmt.Errorf("range [%d, %d) is not an aligned subtree", lo, hi)
+	}
+	width, err := c.layers[0].Width()
+	if err != nil {
+		return nil, fmt.Errorf("while getting base layer width: %w", err)
+	}
+	if hi > width {
+		return nil, fmt.Errorf("range [%d, %d) exceeds base layer width %d", lo, hi, width)
+	}
+	height := uint(bits.TrailingZeros64(size))
+	layerHeight := height
+	for c.layers[layerHeight] == nil {
+		layerHeight--
+	}
+	layer := c.layers[layerHeight]
+	if err := layer.Seek(lo >> layerHeight); err != nil {
+		return nil, fmt.Errorf("while seeking to index %d in layer %d: %w", lo>>layerHeight, layerHeight, err)
+	}
+	if layerHeight < height && c.hash == nil {
+		return nil, errors.New("hash function must be set to calculate subtree root")
+	}
+	// The nodes are streamed: parked[h] holds a left child at height layerHeight+h waiting for its sibling, like the
+	// parked nodes of a tree, so only O(log(size)) nodes are kept in memory.
+	parked := make([][]byte, height-layerHeight+1)
+	for i := uint64(0); i < size>>layerHeight; i++ {
+		n, err := layer.ReadNext()
+		if err != nil {
+			return nil, fmt.Errorf("while reading from layer %d: %w", layerHeight, err)
+		}
+		h := 0
+		for ; parked[h] != nil; h++ {
+			n = c.hash(nil, parked[h], n)
+			parked[h] = nil
+		}
+		parked[h] = n
+	}
+	return parked[height-layerHeight], nil
+}
+
+// ComputeParent returns the parent of the nodes at leftIndex and leftIndex+1 in the cached layer at the given height,
+// using the cache's hash function. If the right child lies entirely beyond the end of the tree it's replaced with
+// padding: the recorded PaddingValue, or a zero node.
+func (c *Reader) ComputeParent(height uint, leftIndex uint64) ([]byte, error) {
+	if leftIndex%2 != 0 {
+		return nil, fmt.Errorf("index %d is not a left child", leftIndex)
+	}
+	if c.hash == nil {
+		return nil, errors.New("hash function must be set to compute parent")
+	}
+	layer, found := c.layers[height]
+	if !found {
+		return nil, fmt.Errorf("layer %d is not cached", height)
+	}
+	if err := layer.Seek(leftIndex); err != nil {
+		return nil, fmt.Errorf("while seeking to index %d in layer %d: %w", leftIndex, height, err)
+	}
+	lChild, err := layer.ReadNext()
+	if err != nil {
+		return nil, fmt.Errorf("while reading from layer %d: %w", height, err)
+	}
+	rChild, err := layer.ReadNext()
+	if err == io.EOF {
+		baseWidth, err := c.layers[0].Width()
+		if err != nil {
+			return nil, fmt.Errorf("while getting base layer width: %w", err)
+		}
+		if (leftIndex+1)<<height < baseWidth {
+			return nil, fmt.Errorf("node at index %d in layer %d is not cached", leftIndex+1, height)
+		}
+		if c.padToPowerOfTwo {
+			return nil, fmt.Errorf("node at index %d in layer %d is padding, which isn't supported for trees padded to "+
+				"a power of two", leftIndex+1, height)
+		}
+		rChild = c.paddingValue
+		if rChild == nil {
+			rChild = make([]byte, len(lChild)) // Padding.
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("while reading from layer %d: %w", height, err)
+	}
+	return c.hash(nil, lChild, rChild), nil
+}
+
 type cache struct {
 	layers           map[uint]LayerReadWriter
 	hash             HashFunc
+	hashName         string // The name of hash, if known.
 	shouldCacheLayer CachingPolicy
+	widthPolicy      WidthCachingPolicy // Applied by Writer.GetReader, if set.
 	generateLayer    LayerFactory
+	widths           map[uint]uint64 // The width of every layer when the structure was last validated.
+	padToPowerOfTwo  bool            // Whether the tree that writes to the cache pads to a power of two.
+	paddingValue     []byte          // The node the tree that writes to the cache pads with, or nil for zero nodes.
+
+	sharedMu sync.Mutex
+	shared   map[uint]*sharedLayer // The layers shared by clones of a Reader, by height.
+}
+
+// PadToPowerOfTwo reports whether the tree that writes to the cache pads to a power of two, as recorded by
+// Writer.SetPadToPowerOfTwo.
+func (c *cache) PadToPowerOfTwo() bool {
+	return c.padToPowerOfTwo
+}
+
+// PaddingValue returns the node that the tree that writes to the cache pads with, as recorded by
+// Writer.SetPaddingValue, or nil if it pads with zero nodes.
+func (c *cache) PaddingValue() []byte {
+	return c.paddingValue
 }
 
 func (c *cache) validateStructure() error {
@@ -126,27 +374,33 @@ func (c *cache) validateStructure() error {
 	if _, found := c.layers[0]; !found {
 		return errors.New("reader for base layer must be included")
 	}
-	width, err := c.layers[0].Width()
-	if err != nil {
-		return fmt.Errorf("while getting base layer width: %v", err)
+	widths := make(map[uint]uint64, len(c.layers))
+	for i, layer := range c.layers {
+		iWidth, err := layer.Width()
+		if err != nil {
+			return fmt.Errorf("failed to get width for layer %d: %v", i, err)
+		}
+		widths[i] = iWidth
 	}
+	width := widths[0]
 	if width == 0 {
 		return errors.New("base layer cannot be empty")
 	}
 	height := RootHeightFromWidth(width)
+	// Layers above the root can't belong to the tree. Empty ones are tolerated, since layer writers may be requested
+	// before the final height of the tree is known.
+	for i, iWidth := range widths {
+		if i > height && iWidth > 0 {
+			return fmt.Errorf("layer %d is above the root of a tree of width %d, at height %d", i, width, height)
+		}
+	}
 	for i := uint(0); i < height; i++ {
-		layer, found := c.layers[i]
-		if found {
-			iWidth, err := layer.Width()
-			if err != nil {
-				return fmt.Errorf("failed to get width for layer %d: %v", i, err)
-			}
-			if iWidth != width {
-				return fmt.Errorf("reader at layer %d has width %d instead of %d", i, iWidth, width)
-			}
+		if iWidth, found := widths[i]; found && iWidth != width {
+			return fmt.Errorf("reader at layer %d has width %d instead of %d", i, iWidth, width)
 		}
 		width >>= 1
 	}
+	c.widths = widths
 	return nil
 }
 
@@ -1,10 +1,15 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
 )
 
 var someError = errors.New("some error")
@@ -79,3 +84,249 @@ func TestCache_ValidateStructureFail2(t *testing.T) {
 
 	r.Error(err,"reader at layer 1 has width 1 instead of 2")
 }
+
+func TestCache_ValidateStructureTooTall(t *testing.T) {
+	r := require.New(t)
+	readers := make(map[uint]LayerReadWriter)
+
+	readers[0] = widthReader{width: 4}
+	readers[7] = widthReader{width: 1}
+	treeCache := &cache{layers: readers}
+	err := treeCache.validateStructure()
+
+	r.EqualError(err, "layer 7 is above the root of a tree of width 4, at height 2")
+}
+
+func TestCache_ValidateStructureSparse(t *testing.T) {
+	r := require.New(t)
+	readers := make(map[uint]LayerReadWriter)
+
+	// Missing intermediate layers and empty layers above the root are allowed.
+	readers[0] = widthReader{width: 10}
+	readers[2] = widthReader{width: 2}
+	readers[4] = widthReader{width: 0}
+	readers[6] = widthReader{width: 0}
+	treeCache := &cache{layers: readers}
+	err := treeCache.validateStructure()
+
+	r.NoError(err)
+}
+
+func sha256Parent(buf, lChild, rChild []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write(lChild)
+	hasher.Write(rChild)
+	return hasher.Sum(buf)
+}
+
+// newTestCache returns a cache of a balanced tree with the given width, where only layersToCache are set.
+func newTestCache(r *require.Assertions, width uint64, layersToCache ...uint) *Writer {
+	writer := NewWriter(SpecificLayersPolicy(map[uint]bool{}), MakeSliceReadWriterFactory())
+	writer.SetHash(sha256Parent)
+	layer := make([][]byte, width)
+	for i := range layer {
+		layer[i] = make([]byte, NodeSize)
+		binary.LittleEndian.PutUint64(layer[i], uint64(i))
+	}
+	for height := uint(0); len(layer) > 0; height++ {
+		for _, h := range layersToCache {
+			if h == height {
+				rw := &readwriters.SliceReadWriter{}
+				for _, n := range layer {
+					_, err := rw.Append(n)
+					r.NoError(err)
+				}
+				writer.SetLayer(height, rw)
+			}
+		}
+		parents := make([][]byte, len(layer)/2)
+		for i := range parents {
+			parents[i] = sha256Parent(nil, layer[2*i], layer[2*i+1])
+		}
+		layer = parents
+	}
+	return writer
+}
+
+func TestReader_SubtreeRoot(t *testing.T) {
+	r := require.New(t)
+
+	full, err := newTestCache(r, 8, 0, 1, 2, 3).GetReader()
+	r.NoError(err)
+	sparse, err := newTestCache(r, 8, 0).GetReader()
+	r.NoError(err)
+
+	layer := full.GetLayerReader(2)
+	for i, lo := range []uint64{0, 4} {
+		r.NoError(layer.Seek(uint64(i)))
+		expected, err := layer.ReadNext()
+		r.NoError(err)
+
+		root, err := full.(*Reader).SubtreeRoot(lo, lo+4)
+		r.NoError(err)
+		r.Equal(expected, root)
+
+		root, err = sparse.(*Reader).SubtreeRoot(lo, lo+4)
+		r.NoError(err)
+		r.Equal(expected, root)
+	}
+
+	// The root of the whole tree and single leaves are streamed from the base layer too.
+	rootLayer := full.GetLayerReader(3)
+	r.NoError(rootLayer.Seek(0))
+	expected, err := rootLayer.ReadNext()
+	r.NoError(err)
+	root, err := sparse.(*Reader).SubtreeRoot(0, 8)
+	r.NoError(err)
+	r.Equal(expected, root)
+	baseLayer := full.GetLayerReader(0)
+	r.NoError(baseLayer.Seek(5))
+	expected, err = baseLayer.ReadNext()
+	r.NoError(err)
+	root, err = sparse.(*Reader).SubtreeRoot(5, 6)
+	r.NoError(err)
+	r.Equal(expected, root)
+
+	_, err = sparse.(*Reader).SubtreeRoot(2, 6)
+	r.EqualError(err, "range [2, 6) is not an aligned subtree")
+	_, err = sparse.(*Reader).SubtreeRoot(0, 3)
+	r.EqualError(err, "range [0, 3) is not an aligned subtree")
+	_, err = sparse.(*Reader).SubtreeRoot(8, 16)
+	r.EqualError(err, "range [8, 16) exceeds base layer width 8")
+}
+
+func TestReader_ComputeParent(t *testing.T) {
+	r := require.New(t)
+
+	reader, err := newTestCache(r, 8, 0, 1).GetReader()
+	r.NoError(err)
+
+	layer := reader.GetLayerReader(1)
+	for i := uint64(0); i < 4; i++ {
+		r.NoError(layer.Seek(i))
+		expected, err := layer.ReadNext()
+		r.NoError(err)
+
+		parent, err := reader.(*Reader).ComputeParent(0, 2*i)
+		r.NoError(err)
+		r.Equal(expected, parent)
+	}
+
+	_, err = reader.(*Reader).ComputeParent(0, 3)
+	r.EqualError(err, "index 3 is not a left child")
+	_, err = reader.(*Reader).ComputeParent(2, 0)
+	r.EqualError(err, "layer 2 is not cached")
+	_, err = reader.(*Reader).ComputeParent(0, 8)
+	r.ErrorIs(err, io.EOF)
+}
+
+func TestReader_ComputeParentPadding(t *testing.T) {
+	r := require.New(t)
+
+	writer := NewWriter(SpecificLayersPolicy(map[uint]bool{}), MakeSliceReadWriterFactory())
+	writer.SetHash(sha256Parent)
+	base := &readwriters.SliceReadWriter{}
+	var leaves [][]byte
+	for i := 0; i < 5; i++ {
+		leaf := make([]byte, NodeSize)
+		binary.LittleEndian.PutUint64(leaf, uint64(i))
+		leaves = append(leaves, leaf)
+		_, err := base.Append(leaf)
+		r.NoError(err)
+	}
+	writer.SetLayer(0, base)
+	reader, err := writer.GetReader()
+	r.NoError(err)
+
+	parent, err := reader.(*Reader).ComputeParent(0, 4)
+	r.NoError(err)
+	r.Equal(sha256Parent(nil, leaves[4], make([]byte, NodeSize)), parent)
+
+	sentinel := make([]byte, NodeSize)
+	sentinel[0] = 0xff
+	writer.SetPaddingValue(sentinel)
+	parent, err = reader.(*Reader).ComputeParent(0, 4)
+	r.NoError(err)
+	r.Equal(sha256Parent(nil, leaves[4], sentinel), parent)
+}
+
+func TestReader_IsFullyCached(t *testing.T) {
+	r := require.New(t)
+
+	reader, err := newTestCache(r, 8, 0, 1, 2, 3).GetReader()
+	r.NoError(err)
+	fullyCached, err := reader.(*Reader).IsFullyCached()
+	r.NoError(err)
+	r.True(fullyCached)
+
+	reader, err = newTestCache(r, 8, 0, 1, 3).GetReader()
+	r.NoError(err)
+	fullyCached, err = reader.(*Reader).IsFullyCached()
+	r.NoError(err)
+	r.False(fullyCached)
+}
+
+func TestWriter_StaleLayers(t *testing.T) {
+	r := require.New(t)
+
+	writer := newTestCache(r, 8, 0, 1, 2, 3)
+	_, err := writer.GetReader()
+	r.NoError(err)
+	stale, err := writer.StaleLayers()
+	r.NoError(err)
+	r.Empty(stale)
+
+	base, err := writer.GetLayerWriter(0)
+	r.NoError(err)
+	for i := 0; i < 4; i++ {
+		_, err = base.Append(make([]byte, NodeSize))
+		r.NoError(err)
+	}
+	stale, err = writer.StaleLayers()
+	r.NoError(err)
+	r.Equal([]uint{1, 2}, stale)
+
+	_, err = writer.GetReader()
+	r.EqualError(err, "reader at layer 1 has width 4 instead of 6")
+}
+
+// seekingLayer hides the positional reads of a layer, so clones fall back to seeking it.
+type seekingLayer struct{ LayerReadWriter }
+
+func TestReader_Clone(t *testing.T) {
+	r := require.New(t)
+
+	writer := newTestCache(r, 8, 0, 1)
+	writer.SetLayer(1, seekingLayer{writer.layers[1]})
+	writer.SetHashName("sha256")
+	reader, err := writer.GetReader()
+	r.NoError(err)
+	clone := reader.(*Reader).Clone()
+	r.Equal("sha256", clone.hashName)
+	r.NotNil(clone.layers[0].(*cursorLayer).at)
+	r.Nil(clone.layers[1].(*cursorLayer).at)
+
+	for height, width := range map[uint]uint64{0: 8, 1: 4} {
+		original := reader.GetLayerReader(height)
+		r.NoError(original.Seek(0))
+		first, err := original.ReadNext()
+		r.NoError(err)
+
+		layer := clone.GetLayerReader(height)
+		r.NoError(layer.Seek(width - 1))
+		_, err = layer.ReadNext()
+		r.NoError(err)
+		_, err = layer.ReadNext()
+		r.ErrorIs(err, io.EOF)
+
+		// Reading the clone with positional reads doesn't move the original layer, seeking moves it.
+		next, err := original.ReadNext()
+		if height == 0 {
+			r.NoError(err)
+			r.NotEqual(first, next)
+			r.Equal(uint64(1), binary.LittleEndian.Uint64(next))
+		} else {
+			r.ErrorIs(err, io.EOF)
+		}
+	}
+}
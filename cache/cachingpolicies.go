@@ -1,5 +1,9 @@
 package cache
 
+// WidthCachingPolicy decides whether to keep a cached layer once the width of the base layer is known. Use it with
+// NewWriterWithWidthPolicy.
+type WidthCachingPolicy func(layerHeight uint, baseWidth uint64) (shouldCacheLayer bool)
+
 func MinHeightPolicy(minHeight uint) CachingPolicy {
 	return func(layerHeight uint) (shouldCacheLayer bool) {
 		return layerHeight >= minHeight
@@ -17,3 +21,53 @@ func Combine(first, second CachingPolicy) CachingPolicy {
 		return first(layerHeight) || second(layerHeight)
 	}
 }
+
+// And caches the layers that both policies cache.
+func And(first, second CachingPolicy) CachingPolicy {
+	return func(layerHeight uint) (shouldCacheLayer bool) {
+		return first(layerHeight) && second(layerHeight)
+	}
+}
+
+// Not caches the layers that p doesn't cache. Proofs can't be generated from a cache without a base layer, so Not is
+// usually combined with a policy that includes layer 0.
+func Not(p CachingPolicy) CachingPolicy {
+	return func(layerHeight uint) (shouldCacheLayer bool) {
+		return !p(layerHeight)
+	}
+}
+
+// EveryNthLayerPolicy caches layers 0, n, 2n, etc. Missing layers are recalculated from the cached layer below them
+// when needed, so larger values of n trade proof generation time for memory. An n of 0 only caches the base layer.
+func EveryNthLayerPolicy(n uint) CachingPolicy {
+	return func(layerHeight uint) (shouldCacheLayer bool) {
+		return layerHeight == 0 || (n > 0 && layerHeight%n == 0)
+	}
+}
+
+// TopLayersPolicy keeps the top k layers of the tree, from the root down, which are the smallest layers and are used by
+// every proof. Since the height of the tree isn't known while it's being built, it's a WidthCachingPolicy: the writer
+// caches every layer and drops the others once the width of the base layer is known.
+func TopLayersPolicy(k uint) WidthCachingPolicy {
+	return func(layerHeight uint, baseWidth uint64) (shouldCacheLayer bool) {
+		return layerHeight+k > RootHeightFromWidth(baseWidth)
+	}
+}
+
+// MemoryBudgetPolicy caches as many layers of a tree with the given base layer width as fit in budget bytes, assuming
+// NodeSize bytes per node. The base layer is always cached, since proofs can't be generated without it, and counts
+// against the budget. The rest of the budget is spent on layers from the top of the tree down, since they are the
+// smallest and are used by every proof, until the next layer doesn't fit.
+func MemoryBudgetPolicy(budget uint64, width uint64) CachingPolicy {
+	layersToCache := map[uint]bool{0: true}
+	used := width * NodeSize
+	for height := RootHeightFromWidth(width); height > 0; height-- {
+		size := (width >> height) * NodeSize
+		if used+size > budget {
+			break
+		}
+		used += size
+		layersToCache[height] = true
+	}
+	return SpecificLayersPolicy(layersToCache)
+}
@@ -112,3 +112,82 @@ func TestMakeSpecificLayerFactory(t *testing.T) {
 	reader = cacheReader.GetLayerReader(2)
 	r.Nil(reader)
 }
+
+func TestEveryNthLayerPolicy(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := NewWriter(EveryNthLayerPolicy(2), MakeSliceReadWriterFactory())
+	for height := uint(0); height <= 4; height++ {
+		writer, err := cacheWriter.GetLayerWriter(height)
+		r.NoError(err)
+		r.Equal(height%2 == 0, writer != nil, "layer %d", height)
+	}
+
+	combined := Combine(EveryNthLayerPolicy(2), SpecificLayersPolicy(map[uint]bool{5: true}))
+	for height := uint(0); height <= 6; height++ {
+		r.Equal(height == 0 || height == 3 || height == 6, EveryNthLayerPolicy(3)(height), "layer %d", height)
+		r.Equal(height == 0, EveryNthLayerPolicy(0)(height), "layer %d", height)
+		r.Equal(height%2 == 0 || height == 5, combined(height), "layer %d", height)
+	}
+}
+
+func TestAndNot(t *testing.T) {
+	r := require.New(t)
+
+	minHeight3 := MinHeightPolicy(3)
+	layer5 := SpecificLayersPolicy(map[uint]bool{5: true})
+	exceptLayer5 := And(minHeight3, Not(layer5))
+	for height, expected := range []bool{false, false, false, true, true, false, true, true, true} {
+		r.Equal(expected, exceptLayer5(uint(height)), "layer %d", height)
+	}
+	for height := uint(0); height <= 8; height++ {
+		r.Equal(height != 5, Not(layer5)(height), "layer %d", height)
+		r.Equal(height == 5, And(minHeight3, layer5)(height), "layer %d", height)
+		r.Equal(height >= 3, Combine(minHeight3, layer5)(height), "layer %d", height)
+	}
+}
+
+func TestTopLayersPolicy(t *testing.T) {
+	r := require.New(t)
+
+	// A tree of 32 leaves has its root at height 5.
+	top3 := TopLayersPolicy(3)
+	for height := uint(0); height <= 5; height++ {
+		r.Equal(height >= 3, top3(height, 32), "layer %d", height)
+	}
+	// A tree of 33 leaves has its root at height 6.
+	r.False(top3(3, 33))
+	r.True(top3(4, 33))
+	r.False(TopLayersPolicy(0)(5, 32))
+}
+
+func TestMemoryBudgetPolicy(t *testing.T) {
+	r := require.New(t)
+
+	for _, tc := range []struct {
+		budget, width uint64
+		layers        []uint
+	}{
+		// A 16-leaf tree has layers of 512, 256, 128, 64 and 32 bytes.
+		{0, 16, []uint{0}},
+		{512, 16, []uint{0}},
+		{544, 16, []uint{0, 4}},
+		{600, 16, []uint{0, 4}},
+		{608, 16, []uint{0, 3, 4}},
+		{1 << 20, 16, []uint{0, 1, 2, 3, 4}},
+		// A 10-leaf tree has layers of 320, 160, 64, 32 and 0 bytes.
+		{0, 10, []uint{0}},
+		{320, 10, []uint{0, 4}},
+		{352, 10, []uint{0, 3, 4}},
+		{416, 10, []uint{0, 2, 3, 4}},
+	} {
+		policy := MemoryBudgetPolicy(tc.budget, tc.width)
+		var layers []uint
+		for height := uint(0); height <= 8; height++ {
+			if policy(height) {
+				layers = append(layers, height)
+			}
+		}
+		r.Equal(tc.layers, layers, "budget %d, width %d", tc.budget, tc.width)
+	}
+}
@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Clone returns a reader over the same layers that keeps its own read position in every layer, so each clone can be used
+// by a different goroutine, e.g. to generate proofs concurrently. Layers that support positional reads with a
+// ReadNodeAt method, like the read-writers in the readwriters package, are read by all clones in parallel. Reads from
+// other layers are serialized per layer, seeking the layer to the clone's position before every read. Only the clones
+// are safe for concurrent use: the original reader must not be read while clones are in use. Clones are read-only;
+// appending to their layers fails.
+func (c *Reader) Clone() *Reader {
+	c.sharedMu.Lock()
+	defer c.sharedMu.Unlock()
+	if c.shared == nil {
+		c.shared = make(map[uint]*sharedLayer)
+	}
+	clone := &cache{
+		layers:           make(map[uint]LayerReadWriter, len(c.layers)),
+		hash:             c.hash,
+		hashName:         c.hashName,
+		shouldCacheLayer: c.shouldCacheLayer,
+		generateLayer:    c.generateLayer,
+		widths:           c.widths,
+		padToPowerOfTwo:  c.padToPowerOfTwo,
+		paddingValue:     c.paddingValue,
+	}
+	for height, layer := range c.layers {
+		if cursor, ok := layer.(*cursorLayer); ok {
+			clone.layers[height] = &cursorLayer{shared: cursor.shared, at: cursor.at}
+			continue
+		}
+		shared, found := c.shared[height]
+		if !found || shared.layer != layer {
+			shared = &sharedLayer{layer: layer}
+			c.shared[height] = shared
+		}
+		at, _ := layer.(nodeReaderAt)
+		clone.layers[height] = &cursorLayer{shared: shared, at: at}
+	}
+	return &Reader{clone}
+}
+
+// sharedLayer guards a layer that's read by several cursorLayers.
+type sharedLayer struct {
+	mu    sync.Mutex
+	layer LayerReadWriter
+}
+
+// nodeReaderAt is implemented by layers that can read a node without moving their read position, and are safe for
+// concurrent use while nothing is appended to them.
+type nodeReaderAt interface {
+	ReadNodeAt(index uint64) ([]byte, error)
+}
+
+// cursorLayer is a read-only view of a shared layer with its own read position.
+type cursorLayer struct {
+	shared   *sharedLayer
+	at       nodeReaderAt // The shared layer, if it supports positional reads. Reads then don't lock the layer.
+	position uint64
+}
+
+// A compile time check to ensure that cursorLayer fully implements LayerReadWriter.
+var _ LayerReadWriter = (*cursorLayer)(nil)
+
+var errReadOnlyLayer = errors.New("cloned cache layers are read-only")
+
+func (l *cursorLayer) Seek(index uint64) error {
+	width, err := l.Width()
+	if err != nil {
+		return err
+	}
+	if index >= width {
+		return io.EOF
+	}
+	l.position = index
+	return nil
+}
+
+// ReadNext reads the node at the cursor's position with a positional read, if the shared layer supports it. Otherwise,
+// it seeks the shared layer to the cursor's position before every read, since other cursors (or the original reader)
+// may have moved it.
+func (l *cursorLayer) ReadNext() ([]byte, error) {
+	if l.at != nil {
+		value, err := l.at.ReadNodeAt(l.position)
+		if err != nil {
+			return nil, err
+		}
+		l.position++
+		return value, nil
+	}
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	if err := l.shared.layer.Seek(l.position); err != nil {
+		return nil, err
+	}
+	value, err := l.shared.layer.ReadNext()
+	if err != nil {
+		return nil, err
+	}
+	l.position++
+	return value, nil
+}
+
+func (l *cursorLayer) Width() (uint64, error) {
+	if l.at != nil {
+		return l.shared.layer.Width()
+	}
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	return l.shared.layer.Width()
+}
+
+// NodeSize returns the node size of the shared layer, if it reports one, and NodeSize otherwise.
+func (l *cursorLayer) NodeSize() int {
+	if sizer, ok := l.shared.layer.(interface{ NodeSize() int }); ok {
+		return sizer.NodeSize()
+	}
+	return NodeSize
+}
+
+func (l *cursorLayer) Append(p []byte) (n int, err error) {
+	return 0, errReadOnlyLayer
+}
+
+func (l *cursorLayer) Flush() error {
+	return nil
+}
+
+// Close does nothing, as the shared layer is owned by the original cache.
+func (l *cursorLayer) Close() error {
+	return nil
+}
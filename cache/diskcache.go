@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
+)
+
+// diskCacheBufferSize is the write buffer size of every layer of a disk cache.
+const diskCacheBufferSize = 1 << 16
+
+// diskCacheLayerFile returns the name of the file that holds the layer at the given height in a disk cache.
+func diskCacheLayerFile(dir string, layerHeight uint) string {
+	return filepath.Join(dir, fmt.Sprintf("layer-%d.bin", layerHeight))
+}
+
+// NewDiskCache returns a writer that stores every layer selected by policy in its own file, named layer-<height>.bin,
+// under dir. The directory is created if it doesn't exist. Writer.Close flushes and closes all files, which can then be
+// reopened with OpenDiskCache.
+func NewDiskCache(dir string, policy CachingPolicy) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("while creating disk cache directory: %w", err)
+	}
+	return NewWriter(policy, func(layerHeight uint) (LayerReadWriter, error) {
+		return readwriters.NewFileReadWriter(diskCacheLayerFile(dir, layerHeight), diskCacheBufferSize)
+	}), nil
+}
+
+// OpenDiskCache reopens a cache written with NewDiskCache and returns a reader for the layers found in dir. Heights are
+// taken from the file names. The hash function isn't stored in the cache, so it must be provided to recalculate the
+// layers that aren't cached. The files are opened read-only and stay open until all layers returned by the reader's
+// Layers method are closed.
+func OpenDiskCache(dir string, hash HashFunc) (CacheReader, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("while reading disk cache directory: %w", err)
+	}
+	layersToCache := make(map[uint]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "layer-") || !strings.HasSuffix(name, ".bin") {
+			continue
+		}
+		height, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "layer-"), ".bin"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid layer file name %q: %w", name, err)
+		}
+		layersToCache[uint(height)] = true
+	}
+	c := NewWriter(SpecificLayersPolicy(layersToCache), func(layerHeight uint) (LayerReadWriter, error) {
+		return readwriters.OpenFileReader(diskCacheLayerFile(dir, layerHeight))
+	})
+	c.SetHash(hash)
+	for height := range layersToCache {
+		if _, err := c.GetLayerWriter(height); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("while opening layer %d: %w", height, err)
+		}
+	}
+	reader, err := c.GetReader()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return reader, nil
+}
@@ -0,0 +1,88 @@
+package cache_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestDiskCache(t *testing.T) {
+	r := require.New(t)
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	cacheWriter, err := cache.NewDiskCache(dir, cache.SpecificLayersPolicy(map[uint]bool{0: true, 2: true, 3: true}))
+	r.NoError(err)
+	tree, err := merkle.NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 11; i++ {
+		leaf := make([]byte, merkle.NodeSize)
+		binary.LittleEndian.PutUint64(leaf, i)
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	_, expectedLeaves, expectedProof, err := merkle.GenerateProof(merkle.SetOf(1, 9), cacheReader)
+	r.NoError(err)
+	cacheWriter.Close()
+
+	entries, err := os.ReadDir(dir)
+	r.NoError(err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	r.ElementsMatch([]string{"layer-0.bin", "layer-2.bin", "layer-3.bin"}, names)
+
+	cacheReader, err = cache.OpenDiskCache(dir, merkle.GetSha256Parent)
+	r.NoError(err)
+	defer func() {
+		for _, layer := range cacheReader.Layers() {
+			r.NoError(layer.Close())
+		}
+	}()
+	r.Len(cacheReader.Layers(), 3)
+	width, err := cacheReader.GetLayerReader(0).Width()
+	r.NoError(err)
+	r.Equal(uint64(11), width)
+
+	_, leaves, proof, err := merkle.GenerateProof(merkle.SetOf(1, 9), cacheReader)
+	r.NoError(err)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+
+	valid, err := merkle.ValidatePartialTree([]uint64{1, 9}, leaves, proof, root, merkle.GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestOpenDiskCacheErrors(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+
+	_, err := cache.OpenDiskCache(filepath.Join(dir, "missing"), merkle.GetSha256Parent)
+	r.Error(err)
+
+	_, err = cache.OpenDiskCache(dir, merkle.GetSha256Parent)
+	r.EqualError(err, "reader for base layer must be included")
+
+	r.NoError(os.WriteFile(filepath.Join(dir, "layer-x.bin"), nil, 0o600))
+	_, err = cache.OpenDiskCache(dir, merkle.GetSha256Parent)
+	r.ErrorContains(err, `invalid layer file name "layer-x.bin"`)
+	r.NoError(os.Remove(filepath.Join(dir, "layer-x.bin")))
+
+	// A layer file that's listed but can't be opened isn't recreated as an empty layer.
+	r.NoError(os.WriteFile(filepath.Join(dir, "layer-0.bin"), make([]byte, merkle.NodeSize), 0o600))
+	r.NoError(os.Symlink(filepath.Join(dir, "missing.bin"), filepath.Join(dir, "layer-1.bin")))
+	_, err = cache.OpenDiskCache(dir, merkle.GetSha256Parent)
+	r.ErrorIs(err, os.ErrNotExist)
+	r.ErrorContains(err, "while opening layer 1")
+	_, err = os.Stat(filepath.Join(dir, "missing.bin"))
+	r.ErrorIs(err, os.ErrNotExist, "the missing layer file must not be created")
+}
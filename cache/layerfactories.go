@@ -8,6 +8,13 @@ func MakeSliceReadWriterFactory() LayerFactory {
 	}
 }
 
+// MakeSliceReadWriterFactoryWithNodeSize works like MakeSliceReadWriterFactory, but for nodes of the given size.
+func MakeSliceReadWriterFactoryWithNodeSize(nodeSize int) LayerFactory {
+	return func(layerHeight uint) (LayerReadWriter, error) {
+		return readwriters.NewSliceReadWriterWithNodeSize(nodeSize), nil
+	}
+}
+
 func MakeSpecificLayersFactory(readWriters map[uint]LayerReadWriter) LayerFactory {
 	return func(layerHeight uint) (LayerReadWriter, error) {
 		return readWriters[layerHeight], nil
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LayerNodes returns a function that iterates over all nodes of r, from the first one, calling yield with the index
+// and value of every node until it returns false or the layer ends. Iteration also stops at the first read error other
+// than io.EOF, which is then returned by err. Iterating moves the read position of r.
+//
+// LayerNodes deliberately doesn't return iter.Seq2[uint64, []byte]: the iter package requires Go 1.23, and this module
+// supports Go 1.20. The returned function has the same underlying type as iter.Seq2[uint64, []byte], so callers built
+// with Go 1.23 or later can range over it or convert it to iter.Seq2.
+func LayerNodes(r LayerReader) (nodes func(yield func(index uint64, node []byte) bool), err func() error) {
+	var iterErr error
+	nodes = func(yield func(index uint64, node []byte) bool) {
+		iterErr = nil
+		if err := r.Seek(0); err != nil {
+			if !errors.Is(err, io.EOF) {
+				iterErr = fmt.Errorf("while seeking to start of layer: %w", err)
+			}
+			return
+		}
+		for index := uint64(0); ; index++ {
+			node, err := r.ReadNext()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				iterErr = fmt.Errorf("while reading node %d: %w", index, err)
+				return
+			}
+			if !yield(index, node) {
+				return
+			}
+		}
+	}
+	return nodes, func() error { return iterErr }
+}
+
+// CollectLayer reads all nodes of r into a slice, using LayerNodes.
+func CollectLayer(r LayerReader) ([][]byte, error) {
+	var collected [][]byte
+	nodes, iterErr := LayerNodes(r)
+	nodes(func(_ uint64, node []byte) bool {
+		collected = append(collected, node)
+		return true
+	})
+	if err := iterErr(); err != nil {
+		return nil, err
+	}
+	return collected, nil
+}
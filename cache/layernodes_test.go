@@ -0,0 +1,90 @@
+package cache_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
+)
+
+func TestLayerNodes(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := merkle.NewCachingTree(cacheWriter)
+	r.NoError(err)
+	var leaves [][]byte
+	for i := uint64(0); i < 8; i++ {
+		leaf := make([]byte, merkle.NodeSize)
+		binary.LittleEndian.PutUint64(leaf, i)
+		leaves = append(leaves, leaf)
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	baseLayer := cacheReader.GetLayerReader(0)
+
+	// Start from a moved read position, to check that iteration starts from the first node.
+	r.NoError(baseLayer.Seek(5))
+	nodes, iterErr := cache.LayerNodes(baseLayer)
+	var indices []uint64
+	nodes(func(index uint64, node []byte) bool {
+		indices = append(indices, index)
+		r.Equal(leaves[index], node)
+		return true
+	})
+	r.NoError(iterErr())
+	r.Equal([]uint64{0, 1, 2, 3, 4, 5, 6, 7}, indices)
+
+	// Iteration stops when yield returns false.
+	indices = nil
+	nodes(func(index uint64, node []byte) bool {
+		indices = append(indices, index)
+		return index < 2
+	})
+	r.Equal([]uint64{0, 1, 2}, indices)
+
+	collected, err := cache.CollectLayer(baseLayer)
+	r.NoError(err)
+	r.Equal(leaves, collected)
+
+	collected, err = cache.CollectLayer(readwriters.NewSliceReadWriter(nil))
+	r.NoError(err)
+	r.Empty(collected)
+
+	failing := &failingLayer{LayerReadWriter: readwriters.NewSliceReadWriter(flatten(leaves)), failAt: 3}
+	_, err = cache.CollectLayer(failing)
+	r.EqualError(err, "while reading node 3: read failed")
+}
+
+// failingLayer fails to read the node at failAt.
+type failingLayer struct {
+	cache.LayerReadWriter
+	position, failAt uint64
+}
+
+func (l *failingLayer) Seek(index uint64) error {
+	l.position = index
+	return l.LayerReadWriter.Seek(index)
+}
+
+func (l *failingLayer) ReadNext() ([]byte, error) {
+	if l.position == l.failAt {
+		return nil, errors.New("read failed")
+	}
+	l.position++
+	return l.LayerReadWriter.ReadNext()
+}
+
+func flatten(nodes [][]byte) []byte {
+	var flat []byte
+	for _, n := range nodes {
+		flat = append(flat, n...)
+	}
+	return flat
+}
@@ -0,0 +1,73 @@
+//go:build unix
+
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
+)
+
+// MakeMmapArenaFactory returns a LayerFactory that places all layers in a single memory-mapped file at path, which is
+// allocated with totalBytes when it doesn't exist yet. The file is unmapped when all layers are closed (e.g. by
+// Writer.Close) and can be reopened with LoadMmapArena.
+func MakeMmapArenaFactory(path string, totalBytes int) LayerFactory {
+	var (
+		mu    sync.Mutex
+		arena *readwriters.MmapArena
+	)
+	return func(layerHeight uint) (LayerReadWriter, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if arena != nil {
+			if layer, err := arena.Layer(layerHeight); err == nil {
+				return layer, nil
+			}
+		}
+		// Either no layer was created yet, or all previous layers were closed and the arena was unmapped.
+		var err error
+		arena, err = readwriters.OpenMmapArena(path, totalBytes)
+		if err != nil {
+			return nil, err
+		}
+		// The layers keep the arena mapped from here on.
+		defer arena.Close()
+		layer, err := arena.Layer(layerHeight)
+		if err != nil {
+			return nil, err
+		}
+		return layer, nil
+	}
+}
+
+// LoadMmapArena reopens an arena written through MakeMmapArenaFactory and returns a reader for the layers it holds.
+// The arena stays mapped until all layers returned by the reader's Layers method are closed.
+func LoadMmapArena(path string, hash HashFunc) (CacheReader, error) {
+	arena, err := readwriters.OpenMmapArena(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer arena.Close()
+	heights := arena.NonEmptyLayers()
+	layersToCache := make(map[uint]bool, len(heights))
+	for _, height := range heights {
+		layersToCache[height] = true
+	}
+	c := NewWriter(SpecificLayersPolicy(layersToCache), MakeMmapArenaFactory(path, 0))
+	c.SetHash(hash)
+	for _, height := range heights {
+		layer, err := arena.Layer(height)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("while loading layer %d: %w", height, err)
+		}
+		c.SetLayer(height, layer)
+	}
+	reader, err := c.GetReader()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return reader, nil
+}
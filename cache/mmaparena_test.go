@@ -0,0 +1,71 @@
+//go:build unix
+
+package cache_test
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestMmapArena(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "arena")
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeMmapArenaFactory(path, 1<<16))
+	tree, err := merkle.NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(newNodeFromUint64(i)))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	_, expectedLeaves, expectedProof, err := merkle.GenerateProof(merkle.SetOf(1, 4), cacheReader)
+	r.NoError(err)
+	cacheWriter.Close()
+
+	cacheReader, err = cache.LoadMmapArena(path, merkle.GetSha256Parent)
+	r.NoError(err)
+	defer func() {
+		for _, layer := range cacheReader.Layers() {
+			r.NoError(layer.Close())
+		}
+	}()
+	r.Len(cacheReader.Layers(), 4)
+
+	_, leaves, proof, err := merkle.GenerateProof(merkle.SetOf(1, 4), cacheReader)
+	r.NoError(err)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+
+	valid, err := merkle.ValidatePartialTree([]uint64{1, 4}, leaves, proof, root, merkle.GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestMmapArenaFull(t *testing.T) {
+	r := require.New(t)
+	path := filepath.Join(t.TempDir(), "arena")
+
+	// Room for the header and a base layer of 8 nodes only.
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeMmapArenaFactory(path, 520+(2*8+64)*32))
+	defer cacheWriter.Close()
+	tree, err := merkle.NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(newNodeFromUint64(i)))
+	}
+	r.ErrorContains(tree.AddLeaf(newNodeFromUint64(8)), "mmap arena layer 0 is full")
+}
+
+func newNodeFromUint64(i uint64) []byte {
+	b := make([]byte, merkle.NodeSize)
+	binary.LittleEndian.PutUint64(b, i)
+	return b
+}
@@ -0,0 +1,89 @@
+package readwriters
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+// checksumSize is the size of the CRC32C checksum stored after every node by CheckedFileReadWriter.
+const checksumSize = crc32.Size
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewCheckedFileReadWriter creates a file-based read-writer that stores a CRC32C checksum after every node and verifies
+// it when the node is read, so corruption of the file is reported instead of silently producing a wrong root. Like in
+// NewFileReadWriter, `bufferSize` controls the in-memory buffer of the underlying bufio.Writer.
+func NewCheckedFileReadWriter(filename string, bufferSize int) (*CheckedFileReadWriter, error) {
+	rw, err := NewFileReadWriterWithNodeSize(filename, bufferSize, NodeSize+checksumSize)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckedFileReadWriter{rw: rw, record: make([]byte, NodeSize+checksumSize)}, nil
+}
+
+// CheckedFileReadWriter is a FileReadWriter whose records hold a node followed by its CRC32C checksum.
+type CheckedFileReadWriter struct {
+	rw     *FileReadWriter
+	record []byte // Reused buffer for the records written by Append.
+}
+
+// A compile time check to ensure that CheckedFileReadWriter fully implements LayerReadWriter.
+var _ shared.LayerReadWriter = (*CheckedFileReadWriter)(nil)
+
+// NodeSize returns the size of the nodes in the layer, without their checksums.
+func (rw *CheckedFileReadWriter) NodeSize() int {
+	return rw.rw.NodeSize() - checksumSize
+}
+
+func (rw *CheckedFileReadWriter) Seek(index uint64) error {
+	return rw.rw.Seek(index)
+}
+
+// ReadNext reads the next node and verifies its checksum. An error that includes the index of the node is returned if
+// the checksum doesn't match.
+func (rw *CheckedFileReadWriter) ReadNext() ([]byte, error) {
+	index := rw.rw.position
+	record, err := rw.rw.ReadNext()
+	if err != nil {
+		return nil, err
+	}
+	nodeSize := rw.NodeSize()
+	if binary.LittleEndian.Uint32(record[nodeSize:]) != crc32.Checksum(record[:nodeSize], castagnoliTable) {
+		return nil, fmt.Errorf("checksum mismatch in node %d: the file is corrupt", index)
+	}
+	return record[:nodeSize], nil
+}
+
+// Width returns the number of nodes in the layer, including appended nodes that weren't flushed yet.
+func (rw *CheckedFileReadWriter) Width() (uint64, error) {
+	return rw.rw.Width()
+}
+
+// Append appends one or more nodes, each followed by its checksum. The length of p must be a multiple of the node
+// size. The returned length doesn't include the checksums.
+func (rw *CheckedFileReadWriter) Append(p []byte) (n int, err error) {
+	nodeSize := rw.NodeSize()
+	if len(p)%nodeSize != 0 {
+		return 0, fmt.Errorf("can't append %d bytes to a layer of %d byte nodes", len(p), nodeSize)
+	}
+	for ; n < len(p); n += nodeSize {
+		node := p[n : n+nodeSize]
+		copy(rw.record, node)
+		binary.LittleEndian.PutUint32(rw.record[nodeSize:], crc32.Checksum(node, castagnoliTable))
+		if _, err := rw.rw.Append(rw.record); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (rw *CheckedFileReadWriter) Flush() error {
+	return rw.rw.Flush()
+}
+
+func (rw *CheckedFileReadWriter) Close() error {
+	return rw.rw.Close()
+}
@@ -0,0 +1,66 @@
+package readwriters
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckedFileReadWriter(t *testing.T) {
+	r := require.New(t)
+
+	filename := filepath.Join(t.TempDir(), "layer.bin")
+	readWriter, err := NewCheckedFileReadWriter(filename, 4096)
+	r.NoError(err)
+
+	labels := [][]byte{makeLabel("zero"), makeLabel("one"), makeLabel("two")}
+	for _, label := range labels {
+		n, err := readWriter.Append(label)
+		r.NoError(err)
+		r.Equal(NodeSize, n)
+	}
+	_, err = readWriter.Append(make([]byte, NodeSize+1))
+	r.EqualError(err, "can't append 33 bytes to a layer of 32 byte nodes")
+
+	width, err := readWriter.Width()
+	r.NoError(err)
+	r.Equal(uint64(3), width)
+	r.NoError(readWriter.Flush())
+
+	info, err := os.Stat(filename)
+	r.NoError(err)
+	r.Equal(int64(3*(NodeSize+checksumSize)), info.Size())
+
+	for _, label := range labels {
+		next, err := readWriter.ReadNext()
+		r.NoError(err)
+		r.Equal(label, next)
+	}
+	_, err = readWriter.ReadNext()
+	r.ErrorIs(err, io.EOF)
+	r.NoError(readWriter.Close())
+
+	// Flip a byte of the second node.
+	data, err := os.ReadFile(filename)
+	r.NoError(err)
+	data[NodeSize+checksumSize+5] ^= 1
+	r.NoError(os.WriteFile(filename, data, OwnerReadWrite))
+
+	readWriter, err = NewCheckedFileReadWriter(filename, 4096)
+	r.NoError(err)
+	defer readWriter.Close()
+	r.NoError(readWriter.Seek(0))
+	next, err := readWriter.ReadNext()
+	r.NoError(err)
+	r.Equal(labels[0], next)
+	_, err = readWriter.ReadNext()
+	r.EqualError(err, "checksum mismatch in node 1: the file is corrupt")
+
+	r.NoError(readWriter.Seek(2))
+	next, err = readWriter.ReadNext()
+	r.NoError(err)
+	r.Equal(labels[2], next)
+}
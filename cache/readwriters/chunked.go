@@ -0,0 +1,50 @@
+package readwriters
+
+import (
+	"io"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+// ChunkedSliceReader is a LayerReader over a slice of separately allocated nodes. Unlike SliceReadWriter it doesn't
+// require the layer to be copied into a single contiguous buffer.
+type ChunkedSliceReader struct {
+	nodes [][]byte
+	// position in nodes
+	position uint64
+}
+
+// A compile time check to ensure that ChunkedSliceReader fully implements LayerReader.
+var _ shared.LayerReader = (*ChunkedSliceReader)(nil)
+
+// NewChunkedSliceReader returns a reader over nodes. The nodes are not copied, so they must not be modified while the
+// reader is in use.
+func NewChunkedSliceReader(nodes [][]byte) *ChunkedSliceReader {
+	return &ChunkedSliceReader{nodes: nodes}
+}
+
+func (s *ChunkedSliceReader) Width() (uint64, error) {
+	return uint64(len(s.nodes)), nil
+}
+
+func (s *ChunkedSliceReader) Seek(index uint64) error {
+	if index >= uint64(len(s.nodes)) {
+		return io.EOF
+	}
+	s.position = index
+	return nil
+}
+
+// ReadNext returns the next node. The returned slice is the caller-provided node itself, not a copy.
+func (s *ChunkedSliceReader) ReadNext() ([]byte, error) {
+	if s.position >= uint64(len(s.nodes)) {
+		return nil, io.EOF
+	}
+	value := s.nodes[s.position]
+	s.position++
+	return value, nil
+}
+
+func (s *ChunkedSliceReader) Close() error {
+	return nil
+}
@@ -0,0 +1,56 @@
+package readwriters
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedSliceReader(t *testing.T) {
+	r := require.New(t)
+
+	var nodes [][]byte
+	for i := 0; i < 9; i++ {
+		nodes = append(nodes, makeLabel(fmt.Sprintf("node %d", i)))
+	}
+	reader := NewChunkedSliceReader(nodes)
+
+	width, err := reader.Width()
+	r.NoError(err)
+	r.Equal(uint64(9), width)
+
+	for i := 0; i < 9; i++ {
+		next, err := reader.ReadNext()
+		r.NoError(err)
+		r.Equal(string(makeLabel(fmt.Sprintf("node %d", i))), string(next))
+	}
+	next, err := reader.ReadNext()
+	r.ErrorIs(err, io.EOF)
+	r.Nil(next)
+
+	r.NoError(reader.Close())
+}
+
+func TestChunkedSliceReaderSeek(t *testing.T) {
+	r := require.New(t)
+
+	var nodes [][]byte
+	for i := 0; i < 9; i++ {
+		nodes = append(nodes, makeLabel(fmt.Sprintf("node %d", i)))
+	}
+	reader := NewChunkedSliceReader(nodes)
+
+	r.NoError(reader.Seek(5))
+	next, err := reader.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("node 5")), string(next))
+
+	r.NoError(reader.Seek(8))
+	next, err = reader.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("node 8")), string(next))
+
+	r.ErrorIs(reader.Seek(9), io.EOF)
+}
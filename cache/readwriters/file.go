@@ -15,24 +15,72 @@ const OwnerReadWrite = 0o600
 // The `bufferSize` controls the in-memory buffer of the underlying
 // bufio.Writer.
 func NewFileReadWriter(filename string, bufferSize int) (*FileReadWriter, error) {
+	return NewFileReadWriterWithNodeSize(filename, bufferSize, NodeSize)
+}
+
+// NewFileReadWriterWithNodeSize works like NewFileReadWriter, but for nodes of the given size.
+func NewFileReadWriterWithNodeSize(filename string, bufferSize, nodeSize int) (*FileReadWriter, error) {
+	return newFileReadWriter(filename, bufferSize, nodeSize, false)
+}
+
+// NewFileReadWriterWithOptions works like NewFileReadWriter, but when fsyncOnFlush is set, Flush also syncs the file to
+// stable storage, so the flushed nodes survive a crash.
+func NewFileReadWriterWithOptions(filename string, bufferSize int, fsyncOnFlush bool) (*FileReadWriter, error) {
+	return newFileReadWriter(filename, bufferSize, NodeSize, fsyncOnFlush)
+}
+
+func newFileReadWriter(filename string, bufferSize, nodeSize int, fsyncOnFlush bool) (*FileReadWriter, error) {
 	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, OwnerReadWrite)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file for disk read-writer: %v", err)
 	}
 	return &FileReadWriter{
-		f: f,
-		b: bufio.NewReadWriter(bufio.NewReader(f), bufio.NewWriterSize(f, bufferSize)),
+		f:        f,
+		b:        bufio.NewReadWriter(bufio.NewReader(f), bufio.NewWriterSize(f, bufferSize)),
+		nodeSize: nodeSizeOrDefault(nodeSize),
+		fsync:    fsyncOnFlush,
+	}, nil
+}
+
+// OpenFileReader opens an existing file for reading only. Unlike NewFileReadWriter, it returns an error if the file
+// doesn't exist instead of creating an empty layer. Append and Flush return ErrReadOnlyLayer.
+func OpenFileReader(filename string) (*FileReadWriter, error) {
+	return OpenFileReaderWithNodeSize(filename, NodeSize)
+}
+
+// OpenFileReaderWithNodeSize works like OpenFileReader, but for nodes of the given size.
+func OpenFileReaderWithNodeSize(filename string, nodeSize int) (*FileReadWriter, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for disk reader: %w", err)
+	}
+	return &FileReadWriter{
+		f:        f,
+		b:        bufio.NewReadWriter(bufio.NewReader(f), bufio.NewWriter(f)),
+		nodeSize: nodeSizeOrDefault(nodeSize),
+		readOnly: true,
 	}, nil
 }
 
 type FileReadWriter struct {
 	f *os.File
 	b *bufio.ReadWriter
+	// position of the next node to read, in nodes.
+	position uint64
+	nodeSize int
+	readOnly bool
+	// fsync makes Flush sync the file to stable storage.
+	fsync bool
 }
 
 // A compile time check to ensure that FileReadWriter fully implements LayerReadWriter.
 var _ shared.LayerReadWriter = (*FileReadWriter)(nil)
 
+// NodeSize returns the size of the nodes in the layer.
+func (rw *FileReadWriter) NodeSize() int {
+	return rw.nodeSize
+}
+
 func (rw *FileReadWriter) Seek(index uint64) error {
 	width, err := rw.Width()
 	if err != nil {
@@ -41,41 +89,83 @@ func (rw *FileReadWriter) Seek(index uint64) error {
 	if index >= width {
 		return io.EOF
 	}
-	_, err = rw.f.Seek(int64(index*NodeSize), io.SeekStart)
+	// Nodes that are still buffered must reach the file before they can be read.
+	if rw.b.Writer.Buffered() > 0 {
+		if err := rw.b.Flush(); err != nil {
+			return fmt.Errorf("failed to flush disk writer: %v", err)
+		}
+	}
+	_, err = rw.f.Seek(int64(index)*int64(rw.nodeSize), io.SeekStart)
 	if err != nil {
 		return fmt.Errorf("failed to seek in disk reader: %v", err)
 	}
 	rw.b.Reader.Reset(rw.f)
+	rw.position = index
 	return err
 }
 
 func (rw *FileReadWriter) ReadNext() ([]byte, error) {
-	ret := make([]byte, NodeSize)
-	_, err := rw.b.Read(ret)
+	ret := make([]byte, rw.nodeSize)
+	_, err := io.ReadFull(rw.b, ret)
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("truncated node at byte offset %d: %w", rw.position*uint64(rw.nodeSize), err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	rw.position++
 	return ret, nil
 }
 
+// ReadNodeAt reads the node at the given index from the file with a positional read, without moving the read position,
+// so it's safe for concurrent use as long as nothing is appended meanwhile. Nodes that weren't flushed yet can't be
+// read this way: io.EOF is returned for them, like for nodes beyond the end of the layer.
+func (rw *FileReadWriter) ReadNodeAt(index uint64) ([]byte, error) {
+	ret := make([]byte, rw.nodeSize)
+	n, err := rw.f.ReadAt(ret, int64(index)*int64(rw.nodeSize))
+	if n == rw.nodeSize {
+		// ReadAt may return io.EOF along with the last node.
+		return ret, nil
+	}
+	if err == io.EOF && n > 0 {
+		return nil, fmt.Errorf("truncated node at byte offset %d: %w", index*uint64(rw.nodeSize), io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+// Width returns the number of nodes in the layer, including appended nodes that weren't flushed yet.
 func (rw *FileReadWriter) Width() (uint64, error) {
 	info, err := rw.f.Stat()
 	if err != nil {
 		return 0, fmt.Errorf("failed to get stats for disk reader: %v", err)
 	}
-	return uint64(info.Size()) / NodeSize, nil
+	return (uint64(info.Size()) + uint64(rw.b.Writer.Buffered())) / uint64(rw.nodeSize), nil
 }
 
 func (rw *FileReadWriter) Append(p []byte) (n int, err error) {
+	if rw.readOnly {
+		return 0, ErrReadOnlyLayer
+	}
 	n, err = rw.b.Write(p)
 	return
 }
 
 func (rw *FileReadWriter) Flush() error {
+	if rw.readOnly {
+		return ErrReadOnlyLayer
+	}
 	err := rw.b.Flush()
 	if err != nil {
 		return fmt.Errorf("failed to flush disk writer: %v", err)
 	}
+	if rw.fsync {
+		if err := rw.f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync disk writer: %v", err)
+		}
+	}
 	err = rw.Seek(0)
 	if err != nil {
 		return fmt.Errorf("failed to seek disk reader to start of file: %v", err)
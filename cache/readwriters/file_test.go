@@ -1,12 +1,14 @@
 package readwriters
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -70,3 +72,159 @@ func TestConsistentEOF(t *testing.T) {
 	require.True(t, errors.Is(slice.Seek(1), io.EOF))
 	require.True(t, errors.Is(file.Seek(1), io.EOF))
 }
+
+func TestFileReadWriterShortReads(t *testing.T) {
+	r := require.New(t)
+
+	readWriter, err := NewFileReadWriter(filepath.Join(t.TempDir(), "test"), 4096)
+	r.NoError(err)
+	t.Cleanup(func() { readWriter.Close() })
+
+	const numNodes = 1000
+	for i := 0; i < numNodes; i++ {
+		_, err := readWriter.Append(makeLabel(fmt.Sprint(i)))
+		r.NoError(err)
+	}
+	r.NoError(readWriter.Flush())
+
+	// Make every read of the underlying file return a single byte.
+	readWriter.b.Reader = bufio.NewReader(iotest.OneByteReader(readWriter.f))
+	for i := 0; i < numNodes; i++ {
+		next, err := readWriter.ReadNext()
+		r.NoError(err)
+		r.Equal(string(makeLabel(fmt.Sprint(i))), string(next))
+	}
+	_, err = readWriter.ReadNext()
+	r.ErrorIs(err, io.EOF)
+
+	// A partially written node is reported with its offset.
+	_, err = readWriter.f.Write([]byte("partial"))
+	r.NoError(err)
+	r.NoError(readWriter.Seek(numNodes - 1))
+	_, err = readWriter.ReadNext()
+	r.NoError(err)
+	_, err = readWriter.ReadNext()
+	r.ErrorIs(err, io.ErrUnexpectedEOF)
+	r.EqualError(err, "truncated node at byte offset 32000: unexpected EOF")
+}
+
+func TestFileReadWriterWidthBuffered(t *testing.T) {
+	r := require.New(t)
+
+	readWriter, err := NewFileReadWriter(filepath.Join(t.TempDir(), "test"), 4096)
+	r.NoError(err)
+	t.Cleanup(func() { readWriter.Close() })
+
+	for i := 0; i < 5; i++ {
+		_, err := readWriter.Append(makeLabel(fmt.Sprint(i)))
+		r.NoError(err)
+		width, err := readWriter.Width()
+		r.NoError(err)
+		r.Equal(uint64(i+1), width)
+	}
+
+	// Buffered nodes can be read after seeking to them.
+	r.NoError(readWriter.Seek(4))
+	next, err := readWriter.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("4")), string(next))
+
+	r.NoError(readWriter.Flush())
+	width, err := readWriter.Width()
+	r.NoError(err)
+	r.Equal(uint64(5), width)
+}
+
+func TestOpenFileReader(t *testing.T) {
+	r := require.New(t)
+
+	filename := filepath.Join(t.TempDir(), "test")
+	_, err := OpenFileReader(filename)
+	r.ErrorIs(err, os.ErrNotExist)
+	_, err = os.Stat(filename)
+	r.ErrorIs(err, os.ErrNotExist, "the missing file must not be created")
+
+	readWriter, err := NewFileReadWriter(filename, 4096)
+	r.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err := readWriter.Append(makeLabel(fmt.Sprint(i)))
+		r.NoError(err)
+	}
+	r.NoError(readWriter.Close())
+
+	reader, err := OpenFileReader(filename)
+	r.NoError(err)
+	t.Cleanup(func() { reader.Close() })
+	width, err := reader.Width()
+	r.NoError(err)
+	r.Equal(uint64(3), width)
+	r.NoError(reader.Seek(1))
+	next, err := reader.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("1")), string(next))
+
+	_, err = reader.Append(makeLabel("3"))
+	r.ErrorIs(err, ErrReadOnlyLayer)
+	r.ErrorIs(reader.Flush(), ErrReadOnlyLayer)
+}
+
+func TestOpenFileReaderWithNodeSize(t *testing.T) {
+	r := require.New(t)
+
+	filename := filepath.Join(t.TempDir(), "test")
+	readWriter, err := NewFileReadWriterWithNodeSize(filename, 4096, 20)
+	r.NoError(err)
+	for i := 0; i < 3; i++ {
+		_, err := readWriter.Append(makeLabel(fmt.Sprint(i))[:20])
+		r.NoError(err)
+	}
+	r.NoError(readWriter.Close())
+
+	reader, err := OpenFileReaderWithNodeSize(filename, 20)
+	r.NoError(err)
+	t.Cleanup(func() { reader.Close() })
+	r.Equal(20, reader.NodeSize())
+	width, err := reader.Width()
+	r.NoError(err)
+	r.Equal(uint64(3), width)
+	r.NoError(reader.Seek(2))
+	next, err := reader.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("2")[:20]), string(next))
+}
+
+func TestFileReadWriterWithOptions(t *testing.T) {
+	r := require.New(t)
+
+	readWriter, err := NewFileReadWriterWithOptions(filepath.Join(t.TempDir(), "test"), 1<<20, true)
+	r.NoError(err)
+	t.Cleanup(func() { readWriter.Close() })
+	r.True(readWriter.fsync)
+
+	_, err = readWriter.Append(makeLabel("synced"))
+	r.NoError(err)
+	r.NoError(readWriter.Flush())
+	next, err := readWriter.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("synced")), string(next))
+}
+
+func BenchmarkFileReadWriterAppend(b *testing.B) {
+	node := makeLabel("node")
+	for _, bufferSize := range []int{64 << 10, 4 << 20} {
+		b.Run(fmt.Sprintf("Buffer%dKB", bufferSize>>10), func(b *testing.B) {
+			readWriter, err := NewFileReadWriterWithOptions(filepath.Join(b.TempDir(), "layer"), bufferSize, false)
+			require.NoError(b, err)
+			b.SetBytes(NodeSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := readWriter.Append(node); err != nil {
+					b.Fatal(err)
+				}
+			}
+			require.NoError(b, readWriter.Flush())
+			b.StopTimer()
+			require.NoError(b, readWriter.Close())
+		})
+	}
+}
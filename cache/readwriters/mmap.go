@@ -0,0 +1,162 @@
+//go:build unix
+
+package readwriters
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+// minMmapCapacity is the initial size of the mapping of an empty MmapReadWriter.
+const minMmapCapacity = 1 << 20
+
+// NewMmapReadWriter creates a read-writer backed by a memory mapping of filename, which is created if it doesn't exist.
+// Reads are plain slice indexing. Appends grow the file and the mapping by doubling them when needed, and Close
+// truncates the file back to the written nodes. If the process exits without calling Close, the file may end with
+// zeroed nodes that were preallocated.
+func NewMmapReadWriter(filename string) (*MmapReadWriter, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, OwnerReadWrite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for mmap read-writer: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to get stats for mmap read-writer: %v", err)
+	}
+	rw := &MmapReadWriter{f: f, size: int(info.Size())}
+	if rw.size > 0 {
+		if err := rw.remap(rw.size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return rw, nil
+}
+
+type MmapReadWriter struct {
+	f    *os.File
+	data []byte // The mapping, which may be larger than the written nodes.
+	size int    // The number of written bytes.
+	// position in data determined in nodes unit.
+	position uint64
+}
+
+// A compile time check to ensure that MmapReadWriter fully implements LayerReadWriter.
+var _ shared.LayerReadWriter = (*MmapReadWriter)(nil)
+
+// remap grows the file to capacity bytes and maps all of it, replacing the previous mapping.
+func (rw *MmapReadWriter) remap(capacity int) error {
+	if rw.data != nil {
+		if err := unix.Munmap(rw.data); err != nil {
+			return fmt.Errorf("failed to unmap file: %v", err)
+		}
+		rw.data = nil
+	}
+	if err := rw.f.Truncate(int64(capacity)); err != nil {
+		return fmt.Errorf("failed to grow file: %v", err)
+	}
+	data, err := unix.Mmap(int(rw.f.Fd()), 0, capacity, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("failed to mmap file: %v", err)
+	}
+	rw.data = data
+	return nil
+}
+
+func (rw *MmapReadWriter) width() uint64 {
+	return uint64(rw.size / NodeSize)
+}
+
+func (rw *MmapReadWriter) Seek(index uint64) error {
+	if index >= rw.width() {
+		return io.EOF
+	}
+	rw.position = index
+	return nil
+}
+
+func (rw *MmapReadWriter) ReadNext() ([]byte, error) {
+	value, err := rw.ReadNodeAt(rw.position)
+	if err != nil {
+		return nil, err
+	}
+	rw.position++
+	return value, nil
+}
+
+// ReadNodeAt reads the node at the given index without moving the read position. It's safe for concurrent use, as long
+// as nothing is appended meanwhile.
+func (rw *MmapReadWriter) ReadNodeAt(index uint64) ([]byte, error) {
+	if index >= rw.width() {
+		return nil, io.EOF
+	}
+	value := make([]byte, NodeSize)
+	offset := index * NodeSize
+	copy(value, rw.data[offset:offset+NodeSize])
+	return value, nil
+}
+
+func (rw *MmapReadWriter) Width() (uint64, error) {
+	return rw.width(), nil
+}
+
+func (rw *MmapReadWriter) Append(p []byte) (n int, err error) {
+	if rw.size+len(p) > len(rw.data) {
+		capacity := 2 * len(rw.data)
+		if capacity < minMmapCapacity {
+			capacity = minMmapCapacity
+		}
+		for capacity < rw.size+len(p) {
+			capacity *= 2
+		}
+		if err := rw.remap(capacity); err != nil {
+			return 0, err
+		}
+	}
+	copy(rw.data[rw.size:], p)
+	rw.size += len(p)
+	return len(p), nil
+}
+
+func (rw *MmapReadWriter) Flush() error {
+	if err := msync(rw.data); err != nil {
+		return fmt.Errorf("failed to sync mmap read-writer: %v", err)
+	}
+	return nil
+}
+
+// Close syncs and unmaps the file, and truncates it to the written nodes.
+func (rw *MmapReadWriter) Close() error {
+	if rw.f == nil {
+		return nil
+	}
+	if err := rw.Flush(); err != nil {
+		return err
+	}
+	if rw.data != nil {
+		if err := unix.Munmap(rw.data); err != nil {
+			return fmt.Errorf("failed to unmap file: %v", err)
+		}
+		rw.data = nil
+	}
+	if err := rw.f.Truncate(int64(rw.size)); err != nil {
+		return fmt.Errorf("failed to truncate file: %v", err)
+	}
+	err := rw.f.Close()
+	rw.f = nil
+	return err
+}
+
+// msync synchronously writes the changes to a shared mapping to its file.
+func msync(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return unix.Msync(data, unix.MS_SYNC)
+}
@@ -0,0 +1,101 @@
+//go:build unix
+
+package readwriters
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+func TestMmapReadWriter(t *testing.T) {
+	r := require.New(t)
+	filename := filepath.Join(t.TempDir(), "layer")
+
+	fileWriter, err := NewFileReadWriter(filename, 4096)
+	r.NoError(err)
+	for i := 0; i < 100; i++ {
+		_, err := fileWriter.Append(makeLabel(fmt.Sprint(i)))
+		r.NoError(err)
+	}
+	r.NoError(fileWriter.Close())
+
+	mmapReadWriter, err := NewMmapReadWriter(filename)
+	r.NoError(err)
+	width, err := mmapReadWriter.Width()
+	r.NoError(err)
+	r.Equal(uint64(100), width)
+	for i := 0; i < 100; i++ {
+		next, err := mmapReadWriter.ReadNext()
+		r.NoError(err)
+		r.Equal(string(makeLabel(fmt.Sprint(i))), string(next))
+	}
+	_, err = mmapReadWriter.ReadNext()
+	r.EqualError(err, "EOF")
+
+	// Grow the mapping beyond its initial size.
+	const numNodes = 2*minMmapCapacity/NodeSize + 1
+	for i := 100; i < numNodes; i++ {
+		_, err := mmapReadWriter.Append(makeLabel(fmt.Sprint(i)))
+		r.NoError(err)
+	}
+	r.NoError(mmapReadWriter.Seek(numNodes - 1))
+	next, err := mmapReadWriter.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel(fmt.Sprint(numNodes-1))), string(next))
+	r.NoError(mmapReadWriter.Close())
+
+	fileReader, err := NewFileReadWriter(filename, 4096)
+	r.NoError(err)
+	defer fileReader.Close()
+	width, err = fileReader.Width()
+	r.NoError(err)
+	r.Equal(uint64(numNodes), width)
+	r.NoError(fileReader.Seek(150))
+	next, err = fileReader.ReadNext()
+	r.NoError(err)
+	r.Equal(string(makeLabel("150")), string(next))
+}
+
+func BenchmarkLayerReadWriters(b *testing.B) {
+	const numNodes = 1 << 16
+	newReadWriters := map[string]func(filename string) (shared.LayerReadWriter, error){
+		"File": func(filename string) (shared.LayerReadWriter, error) { return NewFileReadWriter(filename, 4096) },
+		"Mmap": func(filename string) (shared.LayerReadWriter, error) { return NewMmapReadWriter(filename) },
+	}
+	for name, newReadWriter := range newReadWriters {
+		readWriter, err := newReadWriter(filepath.Join(b.TempDir(), "layer"))
+		require.NoError(b, err)
+		for i := 0; i < numNodes; i++ {
+			_, err := readWriter.Append(makeLabel(fmt.Sprint(i)))
+			require.NoError(b, err)
+		}
+		require.NoError(b, readWriter.Flush())
+
+		b.Run(name+"/Sequential", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if i%numNodes == 0 {
+					require.NoError(b, readWriter.Seek(0))
+				}
+				if _, err := readWriter.ReadNext(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/Random", func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, readWriter.Seek(uint64(rng.Intn(numNodes))))
+				if _, err := readWriter.ReadNext(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		require.NoError(b, readWriter.Close())
+	}
+}
@@ -0,0 +1,240 @@
+//go:build unix
+
+package readwriters
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+const (
+	mmapArenaMagic     = "MTARENA1"
+	mmapArenaMaxLayers = 64
+	// The header holds the magic followed by the width of every layer, so the arena can be reopened.
+	mmapArenaHeaderSize = len(mmapArenaMagic) + mmapArenaMaxLayers*8
+)
+
+// MmapArena is a single memory-mapped file that holds all layers of a cache. Each layer gets a fixed region of the
+// file, sized so that every layer can hold half as many nodes as the layer below it, and appends bump a per-layer
+// width that's stored in the file's header. The arena is unmapped once it and all layers returned by Layer are closed.
+type MmapArena struct {
+	f    *os.File
+	data []byte
+
+	mu       sync.Mutex
+	offsets  [mmapArenaMaxLayers + 1]int
+	openRefs int
+}
+
+// OpenMmapArena opens the arena at path, creating it with totalBytes if it doesn't exist. When the file already exists,
+// it's reopened with its existing size and totalBytes is ignored, so previously written layers are preserved.
+func OpenMmapArena(path string, totalBytes int) (*MmapArena, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, OwnerReadWrite)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for mmap arena: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to get stats for mmap arena: %v", err)
+	}
+	isNew := info.Size() == 0
+	size := int(info.Size())
+	if isNew {
+		size = totalBytes
+		if err := f.Truncate(int64(size)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to allocate mmap arena: %v", err)
+		}
+	}
+	a := &MmapArena{f: f, openRefs: 1}
+	if err := a.computeOffsets(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.data, err = unix.Mmap(int(f.Fd()), 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to mmap arena: %v", err)
+	}
+	if isNew {
+		copy(a.data, mmapArenaMagic)
+	} else if string(a.data[:len(mmapArenaMagic)]) != mmapArenaMagic {
+		a.unmap()
+		return nil, errors.New("file is not an mmap arena")
+	}
+	return a, nil
+}
+
+// computeOffsets splits the space after the header into a region per layer. Layer h can hold ceil(baseCap / 2^h)
+// nodes, which sums up to at most 2*baseCap + mmapArenaMaxLayers nodes.
+func (a *MmapArena) computeOffsets(size int) error {
+	availableNodes := (size - mmapArenaHeaderSize) / NodeSize
+	if availableNodes < mmapArenaMaxLayers+2 {
+		return fmt.Errorf("mmap arena of %d bytes is too small", size)
+	}
+	baseCap := (availableNodes - mmapArenaMaxLayers) / 2
+	offset := mmapArenaHeaderSize
+	for h := 0; h < mmapArenaMaxLayers; h++ {
+		a.offsets[h] = offset
+		offset += ((baseCap + 1<<h - 1) >> h) * NodeSize
+	}
+	a.offsets[mmapArenaMaxLayers] = offset
+	return nil
+}
+
+// Layer returns a LayerReadWriter backed by the arena's region for the given layer height.
+func (a *MmapArena) Layer(layerHeight uint) (*MmapArenaLayer, error) {
+	if layerHeight >= mmapArenaMaxLayers {
+		return nil, fmt.Errorf("layer height %d is out of range for mmap arena", layerHeight)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.data == nil {
+		return nil, errors.New("mmap arena is closed")
+	}
+	a.openRefs++
+	return &MmapArenaLayer{arena: a, height: layerHeight}, nil
+}
+
+// NonEmptyLayers returns the heights of all layers that hold at least one node, in ascending order.
+func (a *MmapArena) NonEmptyLayers() []uint {
+	var heights []uint
+	for h := uint(0); h < mmapArenaMaxLayers; h++ {
+		if a.width(h) > 0 {
+			heights = append(heights, h)
+		}
+	}
+	return heights
+}
+
+// Close releases the handle returned by OpenMmapArena. Layers that are still open keep the arena mapped until they're
+// closed as well.
+func (a *MmapArena) Close() error {
+	return a.release()
+}
+
+func (a *MmapArena) widthOffset(layerHeight uint) int {
+	return len(mmapArenaMagic) + int(layerHeight)*8
+}
+
+func (a *MmapArena) width(layerHeight uint) uint64 {
+	offset := a.widthOffset(layerHeight)
+	return binary.LittleEndian.Uint64(a.data[offset : offset+8])
+}
+
+func (a *MmapArena) setWidth(layerHeight uint, width uint64) {
+	offset := a.widthOffset(layerHeight)
+	binary.LittleEndian.PutUint64(a.data[offset:offset+8], width)
+}
+
+func (a *MmapArena) capacity(layerHeight uint) uint64 {
+	return uint64(a.offsets[layerHeight+1]-a.offsets[layerHeight]) / NodeSize
+}
+
+func (a *MmapArena) node(layerHeight uint, index uint64) []byte {
+	offset := a.offsets[layerHeight] + int(index)*NodeSize
+	return a.data[offset : offset+NodeSize]
+}
+
+func (a *MmapArena) sync() error {
+	if err := msync(a.data); err != nil {
+		return fmt.Errorf("failed to sync mmap arena: %v", err)
+	}
+	return nil
+}
+
+func (a *MmapArena) release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.data == nil {
+		return nil
+	}
+	a.openRefs--
+	if a.openRefs > 0 {
+		return nil
+	}
+	if err := a.sync(); err != nil {
+		return err
+	}
+	return a.unmap()
+}
+
+func (a *MmapArena) unmap() error {
+	err := unix.Munmap(a.data)
+	a.data = nil
+	if closeErr := a.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// MmapArenaLayer is a single layer within an MmapArena.
+type MmapArenaLayer struct {
+	arena    *MmapArena
+	height   uint
+	position uint64
+}
+
+// A compile time check to ensure that MmapArenaLayer fully implements LayerReadWriter.
+var _ shared.LayerReadWriter = (*MmapArenaLayer)(nil)
+
+func (l *MmapArenaLayer) Seek(index uint64) error {
+	if index >= l.arena.width(l.height) {
+		return io.EOF
+	}
+	l.position = index
+	return nil
+}
+
+func (l *MmapArenaLayer) ReadNext() ([]byte, error) {
+	if l.position >= l.arena.width(l.height) {
+		return nil, io.EOF
+	}
+	value := make([]byte, NodeSize)
+	copy(value, l.arena.node(l.height, l.position))
+	l.position++
+	return value, nil
+}
+
+func (l *MmapArenaLayer) Width() (uint64, error) {
+	return l.arena.width(l.height), nil
+}
+
+func (l *MmapArenaLayer) Append(p []byte) (n int, err error) {
+	if len(p)%NodeSize != 0 {
+		return 0, fmt.Errorf("mmap arena appends must be a multiple of %d bytes, got %d", NodeSize, len(p))
+	}
+	width := l.arena.width(l.height)
+	nodes := uint64(len(p) / NodeSize)
+	if width+nodes > l.arena.capacity(l.height) {
+		return 0, fmt.Errorf("mmap arena layer %d is full (capacity %d nodes)", l.height, l.arena.capacity(l.height))
+	}
+	for i := uint64(0); i < nodes; i++ {
+		copy(l.arena.node(l.height, width+i), p[i*NodeSize:(i+1)*NodeSize])
+	}
+	l.arena.setWidth(l.height, width+nodes)
+	return len(p), nil
+}
+
+func (l *MmapArenaLayer) Flush() error {
+	return l.arena.sync()
+}
+
+// Close releases the layer. The arena is synced and unmapped when its last layer is closed.
+func (l *MmapArenaLayer) Close() error {
+	if l.arena == nil {
+		return nil
+	}
+	err := l.arena.release()
+	l.arena = nil
+	return err
+}
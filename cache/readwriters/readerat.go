@@ -0,0 +1,81 @@
+package readwriters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spacemeshos/merkle-tree/shared"
+)
+
+// ErrReadOnlyLayer is returned when appending to a layer that can only be read.
+var ErrReadOnlyLayer = errors.New("layer is read-only")
+
+// NewReaderAtLayer returns a read-only layer of width nodes, read from r with ReadAt. Unlike Seek and ReadNext, which
+// share a single read position, ReadNodeAt doesn't modify the layer, so many goroutines can read different nodes
+// without locking. The caller keeps ownership of r: Close doesn't close it.
+func NewReaderAtLayer(r io.ReaderAt, width uint64) *ReaderAtLayer {
+	return &ReaderAtLayer{r: r, width: width}
+}
+
+type ReaderAtLayer struct {
+	r     io.ReaderAt
+	width uint64
+	// position of the next node to read by ReadNext, in nodes.
+	position uint64
+}
+
+// A compile time check to ensure that ReaderAtLayer fully implements LayerReadWriter.
+var _ shared.LayerReadWriter = (*ReaderAtLayer)(nil)
+
+// ReadNodeAt reads the node at the given index. It's safe for concurrent use, as long as r's ReadAt is, which the
+// io.ReaderAt contract requires.
+func (l *ReaderAtLayer) ReadNodeAt(index uint64) ([]byte, error) {
+	if index >= l.width {
+		return nil, io.EOF
+	}
+	ret := make([]byte, NodeSize)
+	n, err := l.r.ReadAt(ret, int64(index)*NodeSize)
+	if n == NodeSize {
+		// ReadAt may return io.EOF along with the last node.
+		return ret, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, fmt.Errorf("while reading node %d: %w", index, err)
+}
+
+func (l *ReaderAtLayer) Seek(index uint64) error {
+	if index >= l.width {
+		return io.EOF
+	}
+	l.position = index
+	return nil
+}
+
+func (l *ReaderAtLayer) ReadNext() ([]byte, error) {
+	ret, err := l.ReadNodeAt(l.position)
+	if err != nil {
+		return nil, err
+	}
+	l.position++
+	return ret, nil
+}
+
+func (l *ReaderAtLayer) Width() (uint64, error) {
+	return l.width, nil
+}
+
+func (l *ReaderAtLayer) Append(p []byte) (n int, err error) {
+	return 0, ErrReadOnlyLayer
+}
+
+func (l *ReaderAtLayer) Flush() error {
+	return ErrReadOnlyLayer
+}
+
+// Close does nothing, as r is owned by the caller.
+func (l *ReaderAtLayer) Close() error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package readwriters
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderAtLayer(t *testing.T) {
+	r := require.New(t)
+
+	const width = 100
+	slice := NewSliceReadWriter(nil)
+	for i := 0; i < width; i++ {
+		_, err := slice.Append(makeLabel(fmt.Sprintf("node %d", i)))
+		r.NoError(err)
+	}
+	r.NoError(slice.Flush())
+	layer := NewReaderAtLayer(bytes.NewReader(slice.slice), width)
+
+	expected := make([][]byte, width)
+	for i := range expected {
+		r.NoError(slice.Seek(uint64(i)))
+		node, err := slice.ReadNext()
+		r.NoError(err)
+		expected[i] = node
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				index := uint64((g*37 + i*13) % width)
+				node, err := layer.ReadNodeAt(index)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(expected[index], node) {
+					errs <- fmt.Errorf("node %d: expected %x, got %x", index, expected[index], node)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+
+	r.NoError(layer.Seek(width - 1))
+	node, err := layer.ReadNext()
+	r.NoError(err)
+	r.Equal(expected[width-1], node)
+	_, err = layer.ReadNext()
+	r.ErrorIs(err, io.EOF)
+	r.ErrorIs(layer.Seek(width), io.EOF)
+
+	_, err = layer.Append(makeLabel("new"))
+	r.ErrorIs(err, ErrReadOnlyLayer)
+	r.ErrorIs(layer.Flush(), ErrReadOnlyLayer)
+
+	// A width larger than the data reports a truncated node.
+	_, err = NewReaderAtLayer(bytes.NewReader(slice.slice), width+1).ReadNodeAt(width)
+	r.EqualError(err, "while reading node 100: unexpected EOF")
+}
@@ -12,16 +12,34 @@ type SliceReadWriter struct {
 	// a continuous memory for keeping nodes
 	slice []byte
 	// position in slice determined in nodes unit
-	// must be multiplied by `NodeSize`` to get its
+	// must be multiplied by the node size to get its
 	// location in `slice`
 	position uint64
+	// nodeSize is the size of each node, or NodeSize if zero.
+	nodeSize int
 }
 
 // A compile time check to ensure that SliceReadWriter fully implements LayerReadWriter.
 var _ shared.LayerReadWriter = (*SliceReadWriter)(nil)
 
+// NewSliceReadWriter returns a read-writer over the given contiguous nodes. The slice isn't copied, so it must not be
+// modified by the caller while the read-writer is in use.
+func NewSliceReadWriter(nodes []byte) *SliceReadWriter {
+	return &SliceReadWriter{slice: nodes}
+}
+
+// NewSliceReadWriterWithNodeSize returns an empty read-writer for nodes of the given size.
+func NewSliceReadWriterWithNodeSize(nodeSize int) *SliceReadWriter {
+	return &SliceReadWriter{nodeSize: nodeSize}
+}
+
+// NodeSize returns the size of the nodes in the layer.
+func (s *SliceReadWriter) NodeSize() int {
+	return nodeSizeOrDefault(s.nodeSize)
+}
+
 func (s *SliceReadWriter) width() uint64 {
-	return uint64(len(s.slice) / NodeSize)
+	return uint64(len(s.slice) / s.NodeSize())
 }
 
 func (s *SliceReadWriter) Width() (uint64, error) {
@@ -37,16 +55,27 @@ func (s *SliceReadWriter) Seek(index uint64) error {
 }
 
 func (s *SliceReadWriter) ReadNext() ([]byte, error) {
-	if s.position >= s.width() {
-		return nil, io.EOF
+	value, err := s.ReadNodeAt(s.position)
+	if err != nil {
+		return nil, err
 	}
-	value := make([]byte, NodeSize)
-	index := s.position * NodeSize
-	copy(value, s.slice[index:index+NodeSize])
 	s.position++
 	return value, nil
 }
 
+// ReadNodeAt reads the node at the given index without moving the read position. It's safe for concurrent use, as long
+// as nothing is appended meanwhile.
+func (s *SliceReadWriter) ReadNodeAt(index uint64) ([]byte, error) {
+	if index >= s.width() {
+		return nil, io.EOF
+	}
+	nodeSize := uint64(s.NodeSize())
+	value := make([]byte, nodeSize)
+	offset := index * nodeSize
+	copy(value, s.slice[offset:offset+nodeSize])
+	return value, nil
+}
+
 func (s *SliceReadWriter) Append(p []byte) (n int, err error) {
 	s.slice = append(s.slice, p...)
 	return len(p), nil
@@ -59,3 +88,10 @@ func (s *SliceReadWriter) Flush() error {
 func (s *SliceReadWriter) Close() error {
 	return nil
 }
+
+func nodeSizeOrDefault(nodeSize int) int {
+	if nodeSize == 0 {
+		return NodeSize
+	}
+	return nodeSize
+}
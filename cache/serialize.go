@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
+)
+
+// serializedFormatVersion is the version of the format written by WriteTo.
+const serializedFormatVersion = 1
+
+// maxSerializedNodeSize limits the node size accepted by ReadFrom, to avoid huge allocations on malformed input.
+const maxSerializedNodeSize = 1 << 20
+
+// WriteTo serializes all layers of r to w, so the cache can be shipped as a single blob and read back with ReadFrom.
+// The format starts with a version byte and a header: the number of layers, followed by the height, width and node size
+// of every layer (all uvarints), in ascending order of height. The nodes of every layer follow, in the same order.
+func WriteTo(w io.Writer, r CacheReader) error {
+	layers := r.Layers()
+	heights := make([]uint, 0, len(layers))
+	for height := range layers {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	bw := bufio.NewWriter(w)
+	header := []byte{serializedFormatVersion}
+	header = binary.AppendUvarint(header, uint64(len(heights)))
+	widths := make([]uint64, len(heights))
+	for i, height := range heights {
+		width, err := layers[height].Width()
+		if err != nil {
+			return fmt.Errorf("while getting width of layer %d: %w", height, err)
+		}
+		widths[i] = width
+		header = binary.AppendUvarint(header, uint64(height))
+		header = binary.AppendUvarint(header, width)
+		header = binary.AppendUvarint(header, uint64(layerNodeSize(layers[height])))
+	}
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	for i, height := range heights {
+		if widths[i] == 0 {
+			continue
+		}
+		layer := layers[height]
+		if err := layer.Seek(0); err != nil {
+			return fmt.Errorf("while seeking to start of layer %d: %w", height, err)
+		}
+		for j := uint64(0); j < widths[i]; j++ {
+			n, err := layer.ReadNext()
+			if err != nil {
+				return fmt.Errorf("while reading node %d of layer %d: %w", j, height, err)
+			}
+			if _, err := bw.Write(n); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadFrom reads a cache serialized with WriteTo into memory. The structure of the cache is validated, like in
+// Writer.GetReader. The hash function isn't serialized, so it must be provided to recalculate the layers that aren't
+// cached.
+func ReadFrom(rd io.Reader, hash HashFunc) (CacheReader, error) {
+	br := bufio.NewReader(rd)
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("while reading format version: %w", err)
+	}
+	if version != serializedFormatVersion {
+		return nil, fmt.Errorf("unsupported cache format version %d", version)
+	}
+	numLayers, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("while reading number of layers: %w", err)
+	}
+	if numLayers > 64 {
+		return nil, fmt.Errorf("invalid number of layers %d", numLayers)
+	}
+	type layerHeader struct {
+		height          uint
+		width, nodeSize uint64
+	}
+	headers := make([]layerHeader, numLayers)
+	for i := range headers {
+		var fields [3]uint64
+		for j := range fields {
+			if fields[j], err = binary.ReadUvarint(br); err != nil {
+				return nil, fmt.Errorf("while reading header of layer %d: %w", i, err)
+			}
+		}
+		headers[i] = layerHeader{height: uint(fields[0]), width: fields[1], nodeSize: fields[2]}
+		if headers[i].nodeSize == 0 || headers[i].nodeSize > maxSerializedNodeSize {
+			return nil, fmt.Errorf("layer %d has invalid node size %d", headers[i].height, headers[i].nodeSize)
+		}
+		if i > 0 && headers[i].height <= headers[i-1].height {
+			return nil, fmt.Errorf("layer heights must be ascending, got %d after %d", headers[i].height,
+				headers[i-1].height)
+		}
+	}
+	c := NewWriter(MinHeightPolicy(0), MakeSliceReadWriterFactory())
+	c.SetHash(hash)
+	for _, h := range headers {
+		layer := readwriters.NewSliceReadWriterWithNodeSize(int(h.nodeSize))
+		buf := make([]byte, h.nodeSize)
+		for j := uint64(0); j < h.width; j++ {
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, fmt.Errorf("while reading node %d of layer %d: %w", j, h.height, err)
+			}
+			if _, err := layer.Append(buf); err != nil {
+				return nil, err
+			}
+		}
+		c.SetLayer(h.height, layer)
+	}
+	if _, err := br.ReadByte(); err == nil {
+		return nil, errors.New("unexpected trailing data after cache")
+	} else if err != io.EOF {
+		return nil, err
+	}
+	return c.GetReader()
+}
+
+// layerNodeSize returns the size of the nodes in layer, if it reports it with a NodeSize method, and NodeSize otherwise.
+func layerNodeSize(layer LayerReader) int {
+	if sizer, ok := layer.(interface{ NodeSize() int }); ok {
+		return sizer.NodeSize()
+	}
+	return NodeSize
+}
@@ -0,0 +1,66 @@
+package cache_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 1: true, 3: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := merkle.NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 13; i++ {
+		leaf := make([]byte, merkle.NodeSize)
+		binary.LittleEndian.PutUint64(leaf, i)
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	_, expectedLeaves, expectedProof, err := merkle.GenerateProof(merkle.SetOf(2, 12), cacheReader)
+	r.NoError(err)
+
+	var buf bytes.Buffer
+	r.NoError(cache.WriteTo(&buf, cacheReader))
+	serialized := buf.Bytes()
+	restored, err := cache.ReadFrom(bytes.NewReader(serialized), merkle.GetSha256Parent)
+	r.NoError(err)
+
+	r.Len(restored.Layers(), len(cacheReader.Layers()))
+	for height, layer := range cacheReader.Layers() {
+		expectedWidth, err := layer.Width()
+		r.NoError(err)
+		restoredLayer := restored.GetLayerReader(height)
+		r.NotNil(restoredLayer, "layer %d", height)
+		width, err := restoredLayer.Width()
+		r.NoError(err)
+		r.Equal(expectedWidth, width, "layer %d", height)
+	}
+
+	_, leaves, proof, err := merkle.GenerateProof(merkle.SetOf(2, 12), restored)
+	r.NoError(err)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+	valid, err := merkle.ValidatePartialTree([]uint64{2, 12}, leaves, proof, root, merkle.GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	_, err = cache.ReadFrom(bytes.NewReader(serialized[:len(serialized)-1]), merkle.GetSha256Parent)
+	r.ErrorContains(err, "while reading node 0 of layer 3")
+	_, err = cache.ReadFrom(bytes.NewReader(append(serialized, 0)), merkle.GetSha256Parent)
+	r.EqualError(err, "unexpected trailing data after cache")
+	_, err = cache.ReadFrom(bytes.NewReader(append([]byte{2}, serialized[1:]...)), merkle.GetSha256Parent)
+	r.EqualError(err, "unsupported cache format version 2")
+	// A cache without a base layer doesn't pass validation.
+	_, err = cache.ReadFrom(bytes.NewReader([]byte{1, 1, 1, 0, 32}), merkle.GetSha256Parent)
+	r.EqualError(err, "reader for base layer must be included")
+}
@@ -0,0 +1,118 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// GenerateCompactProof works like GenerateProof, but also returns directions: a bitfield with a bit for every proof node,
+// which is set when the node is a left sibling, i.e. it's hashed on the left. Bit i is bit i%8 of directions[i/8].
+// The proof nodes are the same as those returned by GenerateProof. Validate the proof with VerifyCompactProof, which
+// doesn't need to know the width of the tree.
+func GenerateCompactProof(
+	provenLeafIndices Set,
+	treeCache CacheReader,
+) (sortedProvenLeafIndices []uint64, provenLeaves, nodes [][]byte, directions []byte, err error) {
+	sortedProvenLeafIndices, provenLeaves, proofNodes, err := GenerateProofWithPositions(provenLeafIndices, treeCache)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	nodes = make([][]byte, len(proofNodes))
+	directions = make([]byte, (len(proofNodes)+7)/8)
+	for i, n := range proofNodes {
+		nodes[i] = n.Value
+		if !n.Pos.isRightSibling() {
+			directions[i/8] |= 1 << (i % 8)
+		}
+	}
+	return sortedProvenLeafIndices, provenLeaves, nodes, directions, nil
+}
+
+// VerifyCompactProof calculates the root from a proof generated by GenerateCompactProof and compares it to
+// expectedRoot. The leaf indices must be sorted. They determine where the paths of the proven leaves meet and the side
+// on which every proof node is hashed, which the directions must agree with. The calculation stops when the proof nodes
+// run out, so the width of the tree isn't needed, but all leaf indices must be within the subtree whose root is
+// calculated.
+func VerifyCompactProof(leafIndices []uint64, leaves, nodes [][]byte, directions []byte, expectedRoot []byte,
+	hash HashFunc,
+) (bool, error) {
+	if len(leafIndices) == 0 {
+		return false, fmt.Errorf("at least one leaf is required for validation")
+	}
+	if hash == nil {
+		return false, errNilHash
+	}
+	if len(leafIndices) != len(leaves) {
+		return false, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(leaves),
+			len(leafIndices))
+	}
+	for i := 1; i < len(leafIndices); i++ {
+		if leafIndices[i] <= leafIndices[i-1] {
+			return false, fmt.Errorf("leaf indices must be sorted and unique, got %d after %d", leafIndices[i],
+				leafIndices[i-1])
+		}
+	}
+	if len(directions) != (len(nodes)+7)/8 {
+		return false, fmt.Errorf("directions for %d proof nodes must have %d bytes, got %d", len(nodes),
+			(len(nodes)+7)/8, len(directions))
+	}
+	if len(nodes)%8 != 0 && directions[len(directions)-1]>>(len(nodes)%8) != 0 {
+		return false, fmt.Errorf("directions have bits set beyond the last proof node")
+	}
+	v := compactVerifier{indices: leafIndices, leaves: leaves, nodes: nodes, directions: directions, hash: hash}
+	root, err := v.calcRoot(MaxUint)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(root, expectedRoot), nil
+}
+
+type compactVerifier struct {
+	indices    []uint64
+	leaves     [][]byte
+	nodes      [][]byte
+	directions []byte
+	consumed   int // The number of proof nodes consumed so far, used to find their directions.
+	hash       HashFunc
+}
+
+// calcRoot calculates the root of the subtree of the next leaf up to stopAtLayer, or until the proof nodes run out when
+// stopAtLayer is MaxUint.
+func (v *compactVerifier) calcRoot(stopAtLayer uint) ([]byte, error) {
+	activePos := Position{Index: v.indices[0]}
+	activeNode := v.leaves[0]
+	v.indices, v.leaves = v.indices[1:], v.leaves[1:]
+	for ; activePos.Height < stopAtLayer; activePos = activePos.parent() {
+		if len(v.indices) > 0 && activePos.sibling().isAncestorOf(Position{Index: v.indices[0]}) {
+			sibling, err := v.calcRoot(activePos.Height)
+			if err != nil {
+				return nil, err
+			}
+			activeNode = v.hash(nil, activeNode, sibling)
+			continue
+		}
+		if len(v.nodes) == 0 {
+			if stopAtLayer == MaxUint && len(v.indices) == 0 {
+				if activePos.Index != 0 {
+					return nil, fmt.Errorf("the proof nodes end at Position %s, which isn't the root of a tree",
+						activePos)
+				}
+				break
+			}
+			return nil, fmt.Errorf("proof nodes ran out below Position %s", activePos.parent())
+		}
+		isLeft := v.directions[v.consumed/8]&(1<<(v.consumed%8)) != 0
+		if isLeft != activePos.isRightSibling() {
+			return nil, fmt.Errorf("direction of proof node %d doesn't match the sibling of Position %s", v.consumed,
+				activePos)
+		}
+		if isLeft {
+			activeNode = v.hash(nil, v.nodes[0], activeNode)
+		} else {
+			activeNode = v.hash(nil, activeNode, v.nodes[0])
+		}
+		v.nodes = v.nodes[1:]
+		v.consumed++
+	}
+	return activeNode, nil
+}
@@ -0,0 +1,100 @@
+package merkle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestGenerateCompactProof(t *testing.T) {
+	for _, tc := range []struct {
+		width   uint64
+		indices []uint64
+	}{
+		{8, []uint64{0}},
+		{8, []uint64{5}},
+		{8, []uint64{0, 4, 7}},
+		{8, []uint64{1, 2, 3, 6}},
+		{10, []uint64{9}},
+		{10, []uint64{3, 8}},
+		{10, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	} {
+		t.Run(fmt.Sprintf("%d/%v", tc.width, tc.indices), func(t *testing.T) {
+			r := require.New(t)
+			cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+			tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+			r.NoError(err)
+			for i := uint64(0); i < tc.width; i++ {
+				r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+			}
+			root := tree.Root()
+			cacheReader, err := cacheWriter.GetReader()
+			r.NoError(err)
+
+			expectedIndices, expectedLeaves, expectedProof, err := GenerateProof(setOf(tc.indices...), cacheReader)
+			r.NoError(err)
+			indices, leaves, nodes, directions, err := merkle.GenerateCompactProof(setOf(tc.indices...), cacheReader)
+			r.NoError(err)
+			r.Equal(expectedIndices, indices)
+			r.Equal(expectedLeaves, leaves)
+			r.Equal(expectedProof, nodes)
+			r.Len(directions, (len(nodes)+7)/8)
+
+			valid, err := merkle.VerifyCompactProof(indices, leaves, nodes, directions, root, GetSha256Parent)
+			r.NoError(err)
+			r.True(valid)
+
+			// A flipped direction disagrees with the leaf indices.
+			if len(nodes) > 0 {
+				flipped := append([]byte(nil), directions...)
+				flipped[0] ^= 1
+				valid, err = merkle.VerifyCompactProof(indices, leaves, nodes, flipped, root, GetSha256Parent)
+				r.ErrorContains(err, "direction of proof node 0 doesn't match")
+				r.False(valid)
+			}
+		})
+	}
+}
+
+func TestVerifyCompactProofErrors(t *testing.T) {
+	r := require.New(t)
+
+	leaf := NewNodeFromUint64(0)
+	nodes := [][]byte{NewNodeFromUint64(1), NewNodeFromUint64(2)}
+	root := GetSha256Parent(nil, GetSha256Parent(nil, leaf, nodes[0]), nodes[1])
+	valid, err := merkle.VerifyCompactProof([]uint64{0}, [][]byte{leaf}, nodes, []byte{0}, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	_, err = merkle.VerifyCompactProof([]uint64{0}, [][]byte{leaf}, nodes, nil, root, GetSha256Parent)
+	r.EqualError(err, "directions for 2 proof nodes must have 1 bytes, got 0")
+	_, err = merkle.VerifyCompactProof([]uint64{0}, [][]byte{leaf}, nodes, []byte{4}, root, GetSha256Parent)
+	r.EqualError(err, "directions have bits set beyond the last proof node")
+	_, err = merkle.VerifyCompactProof([]uint64{0, 1}, [][]byte{leaf}, nodes, []byte{0}, root, GetSha256Parent)
+	r.EqualError(err, "number of leaves (1) must equal number of indices (2)")
+	_, err = merkle.VerifyCompactProof([]uint64{1, 0}, [][]byte{leaf, leaf}, nodes, []byte{0}, root,
+		GetSha256Parent)
+	r.EqualError(err, "leaf indices must be sorted and unique, got 0 after 1")
+	_, err = merkle.VerifyCompactProof([]uint64{0, 5}, [][]byte{leaf, leaf}, nodes[:1], []byte{0}, root,
+		GetSha256Parent)
+	r.EqualError(err, "proof nodes ran out below Position <h: 2 i: 0>")
+	_, err = merkle.VerifyCompactProof([]uint64{0}, [][]byte{leaf}, nodes, []byte{0}, root, nil)
+	r.EqualError(err, "hash function is required for validation")
+
+	// The proof of leaf 1 in a 2-leaf tree doesn't prove the leaf at any other index.
+	l0, l1 := NewNodeFromUint64(0), NewNodeFromUint64(1)
+	root = GetSha256Parent(nil, l0, l1)
+	valid, err = merkle.VerifyCompactProof([]uint64{1}, [][]byte{l1}, [][]byte{l0}, []byte{1}, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+	valid, err = merkle.VerifyCompactProof([]uint64{0}, [][]byte{l1}, [][]byte{l0}, []byte{1}, root, GetSha256Parent)
+	r.EqualError(err, "direction of proof node 0 doesn't match the sibling of Position <h: 0 i: 0>")
+	r.False(valid)
+	valid, err = merkle.VerifyCompactProof([]uint64{7}, [][]byte{l1}, [][]byte{l0}, []byte{1}, root, GetSha256Parent)
+	r.EqualError(err, "the proof nodes end at Position <h: 1 i: 3>, which isn't the root of a tree")
+	r.False(valid)
+}
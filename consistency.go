@@ -0,0 +1,158 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+)
+
+// Consistency proofs follow RFC 6962 (Certificate Transparency), where unbalanced trees aren't padded: the root of a
+// tree of width n is the hash of the root of its first 2^k leaves, for the largest 2^k < n, and the root of the
+// remaining leaves. The roots of such trees are the peaks of the tree, bagged with BagPeaks, and match Tree roots only
+// when the width is a power of two.
+
+// UnpaddedRoot returns the RFC 6962 root of the first width leaves in treeCache, which may hold more leaves. Complete
+// subtrees are read from the cache, or calculated if their layer isn't cached.
+func UnpaddedRoot(width uint64, treeCache CacheReader) ([]byte, error) {
+	if err := checkConsistencyWidth(width, treeCache); err != nil {
+		return nil, err
+	}
+	if width == 0 {
+		return nil, fmt.Errorf("tree of width 0 has no root")
+	}
+	return unpaddedSubtreeRoot(treeCache, 0, width)
+}
+
+// GenerateConsistencyProof generates an RFC 6962 consistency proof that the tree of the first m leaves in treeCache is a
+// prefix of the tree of its first n leaves. The proof is empty when m is 0 or equals n. Validate it with
+// VerifyConsistencyProof, using the roots returned by UnpaddedRoot.
+func GenerateConsistencyProof(m, n uint64, treeCache CacheReader) ([][]byte, error) {
+	if m > n {
+		return nil, fmt.Errorf("old width %d is greater than new width %d", m, n)
+	}
+	if err := checkConsistencyWidth(n, treeCache); err != nil {
+		return nil, err
+	}
+	if m == 0 || m == n {
+		return nil, nil
+	}
+	return consistencySubproof(treeCache, m, 0, n, true)
+}
+
+// VerifyConsistencyProof verifies an RFC 6962 consistency proof that the tree of width m with root rootM is a prefix of
+// the tree of width n with root rootN. Any tree is consistent with the empty tree, so for m == 0 the proof must be empty
+// and rootM is ignored. Otherwise rootM is required. For m == n the proof must be empty and the roots equal.
+func VerifyConsistencyProof(m, n uint64, proof [][]byte, rootM, rootN []byte, hash HashFunc) (bool, error) {
+	if hash == nil {
+		return false, errNilHash
+	}
+	switch {
+	case m > n:
+		return false, fmt.Errorf("old width %d is greater than new width %d", m, n)
+	case m != 0 && rootM == nil:
+		return false, fmt.Errorf("root of tree of width %d is required", m)
+	case m == 0 || m == n:
+		if len(proof) > 0 {
+			return false, fmt.Errorf("proof between widths %d and %d must be empty, got %d nodes", m, n, len(proof))
+		}
+		return m == 0 || bytes.Equal(rootM, rootN), nil
+	}
+	// When m is a power of two, the old root is a node of the new tree and isn't included in the proof.
+	if m&(m-1) == 0 {
+		proof = append([][]byte{rootM}, proof...)
+	}
+	if len(proof) == 0 {
+		return false, fmt.Errorf("proof between widths %d and %d can't be empty", m, n)
+	}
+	fn, sn := m-1, n-1
+	shift := bits.TrailingZeros64(^fn)
+	fn, sn = fn>>shift, sn>>shift
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false, fmt.Errorf("proof between widths %d and %d has too many nodes", m, n)
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = hash(nil, c, fr)
+			sr = hash(nil, c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			sr = hash(nil, sr, c)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 {
+		return false, fmt.Errorf("proof between widths %d and %d is missing nodes", m, n)
+	}
+	return bytes.Equal(fr, rootM) && bytes.Equal(sr, rootN), nil
+}
+
+// consistencySubproof implements SUBPROOF from RFC 6962 for the subtree of the given width starting at leaf start, of
+// which the first m leaves belong to the old tree. complete tells whether the subtree of the first m leaves is the whole
+// old tree, whose root the verifier already knows.
+func consistencySubproof(c CacheReader, m, start, width uint64, complete bool) ([][]byte, error) {
+	if m == width {
+		if complete {
+			return nil, nil
+		}
+		root, err := unpaddedSubtreeRoot(c, start, width)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{root}, nil
+	}
+	k := largestPowerOfTwoBelow(width)
+	var proof [][]byte
+	var sibling []byte
+	var err error
+	if m <= k {
+		if proof, err = consistencySubproof(c, m, start, k, complete); err == nil {
+			sibling, err = unpaddedSubtreeRoot(c, start+k, width-k)
+		}
+	} else {
+		if proof, err = consistencySubproof(c, m-k, start+k, width-k, false); err == nil {
+			sibling, err = unpaddedSubtreeRoot(c, start, k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// unpaddedSubtreeRoot returns the RFC 6962 root of the width leaves starting at start, which must be a multiple of the
+// largest power of two not greater than width.
+func unpaddedSubtreeRoot(c CacheReader, start, width uint64) ([]byte, error) {
+	if width&(width-1) == 0 {
+		height := uint(bits.TrailingZeros64(width))
+		return GetNode(c, Position{Index: start >> height, Height: height})
+	}
+	k := largestPowerOfTwoBelow(width)
+	left, err := unpaddedSubtreeRoot(c, start, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := unpaddedSubtreeRoot(c, start+k, width-k)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetHashFunc()(nil, left, right), nil
+}
+
+// largestPowerOfTwoBelow returns the largest power of two smaller than n, which must be greater than 1.
+func largestPowerOfTwoBelow(n uint64) uint64 {
+	return 1 << (bits.Len64(n-1) - 1)
+}
+
+func checkConsistencyWidth(width uint64, treeCache CacheReader) error {
+	cacheWidth, err := treeCache.GetLayerReader(0).Width()
+	if err != nil {
+		return fmt.Errorf("while getting base layer width: %w", err)
+	}
+	if width > cacheWidth {
+		return fmt.Errorf("width %d is greater than the width of the cache (%d)", width, cacheWidth)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package merkle_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+// rfc6962Leaves are the leaves of the RFC 6962 test vectors, as used by Certificate Transparency implementations.
+var rfc6962Leaves = [][]byte{
+	{},
+	{0x00},
+	{0x10},
+	{0x20, 0x21},
+	{0x30, 0x31},
+	{0x40, 0x41, 0x42, 0x43},
+	{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+	{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+}
+
+// rfc6962Roots are the roots of the trees of the first 1 to 8 rfc6962Leaves.
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func rfc6962Cache(r *require.Assertions, width int, policy cache.CachingPolicy) CacheReader {
+	cacheWriter := cache.NewWriter(policy, cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().
+		WithHashFunc(merkle.GetRFC6962Parent).
+		WithLeafHashFunc(merkle.GetRFC6962LeafHash).
+		WithCacheWriter(cacheWriter).
+		Build()
+	r.NoError(err)
+	r.NoError(tree.AddLeaves(rfc6962Leaves[:width]))
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	return cacheReader
+}
+
+func decodeHexNodes(r *require.Assertions, encoded ...string) [][]byte {
+	var nodes [][]byte
+	for _, h := range encoded {
+		n, err := hex.DecodeString(h)
+		r.NoError(err)
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func TestUnpaddedRoot(t *testing.T) {
+	r := require.New(t)
+
+	expectedRoots := decodeHexNodes(r, rfc6962Roots...)
+	for width := 1; width <= len(rfc6962Leaves); width++ {
+		fullCache := rfc6962Cache(r, len(rfc6962Leaves), cache.MinHeightPolicy(0))
+		baseCache := rfc6962Cache(r, width, cache.SpecificLayersPolicy(map[uint]bool{0: true}))
+		for _, c := range []CacheReader{fullCache, baseCache} {
+			root, err := merkle.UnpaddedRoot(uint64(width), c)
+			r.NoError(err)
+			r.Equal(expectedRoots[width-1], root, "width %d", width)
+		}
+	}
+
+	_, err := merkle.UnpaddedRoot(0, rfc6962Cache(r, 3, cache.MinHeightPolicy(0)))
+	r.EqualError(err, "tree of width 0 has no root")
+	_, err = merkle.UnpaddedRoot(4, rfc6962Cache(r, 3, cache.MinHeightPolicy(0)))
+	r.EqualError(err, "width 4 is greater than the width of the cache (3)")
+}
+
+func TestGenerateConsistencyProof(t *testing.T) {
+	r := require.New(t)
+
+	c := rfc6962Cache(r, len(rfc6962Leaves), cache.MinHeightPolicy(0))
+	for _, tc := range []struct {
+		m, n  uint64
+		proof []string
+	}{
+		{1, 1, nil},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	} {
+		t.Run(fmt.Sprintf("%d-%d", tc.m, tc.n), func(t *testing.T) {
+			r := require.New(t)
+			proof, err := merkle.GenerateConsistencyProof(tc.m, tc.n, c)
+			r.NoError(err)
+			r.Equal(decodeHexNodes(r, tc.proof...), proof)
+		})
+	}
+}
+
+func TestVerifyConsistencyProof(t *testing.T) {
+	r := require.New(t)
+
+	roots := decodeHexNodes(r, rfc6962Roots...)
+	hash := merkle.GetRFC6962Parent
+	for n := uint64(1); n <= uint64(len(rfc6962Leaves)); n++ {
+		// The proofs only depend on the first n leaves, whether or not more leaves are cached.
+		for _, c := range []CacheReader{
+			rfc6962Cache(r, len(rfc6962Leaves), cache.MinHeightPolicy(0)),
+			rfc6962Cache(r, int(n), cache.SpecificLayersPolicy(map[uint]bool{0: true})),
+		} {
+			for m := uint64(1); m <= n; m++ {
+				proof, err := merkle.GenerateConsistencyProof(m, n, c)
+				r.NoError(err)
+				valid, err := merkle.VerifyConsistencyProof(m, n, proof, roots[m-1], roots[n-1], hash)
+				r.NoError(err)
+				r.True(valid, "%d-%d", m, n)
+
+				// The proof must not validate against a different old root.
+				if m > 1 {
+					valid, err = merkle.VerifyConsistencyProof(m, n, proof, roots[m-2], roots[n-1], hash)
+					if err == nil {
+						r.False(valid, "%d-%d", m, n)
+					}
+				}
+				for i := range proof {
+					tampered := append([][]byte(nil), proof...)
+					tampered[i] = roots[0]
+					valid, err = merkle.VerifyConsistencyProof(m, n, tampered, roots[m-1], roots[n-1], hash)
+					r.NoError(err)
+					r.False(valid, "%d-%d with tampered node %d", m, n, i)
+				}
+			}
+		}
+	}
+
+	// Any tree is consistent with the empty tree.
+	proof, err := merkle.GenerateConsistencyProof(0, 5, rfc6962Cache(r, 5, cache.MinHeightPolicy(0)))
+	r.NoError(err)
+	r.Empty(proof)
+	valid, err := merkle.VerifyConsistencyProof(0, 5, nil, nil, roots[4], hash)
+	r.NoError(err)
+	r.True(valid)
+
+	valid, err = merkle.VerifyConsistencyProof(3, 3, nil, roots[2], roots[3], hash)
+	r.NoError(err)
+	r.False(valid)
+	_, err = merkle.VerifyConsistencyProof(3, 3, roots[:1], roots[2], roots[2], hash)
+	r.EqualError(err, "proof between widths 3 and 3 must be empty, got 1 nodes")
+	_, err = merkle.VerifyConsistencyProof(5, 3, nil, roots[4], roots[2], hash)
+	r.EqualError(err, "old width 5 is greater than new width 3")
+	_, err = merkle.VerifyConsistencyProof(3, 8, roots[:1], roots[2], roots[7], hash)
+	r.EqualError(err, "proof between widths 3 and 8 is missing nodes")
+	_, err = merkle.VerifyConsistencyProof(4, 8, roots[:3], roots[3], roots[7], hash)
+	r.EqualError(err, "proof between widths 4 and 8 has too many nodes")
+	_, err = merkle.GenerateConsistencyProof(3, 9, rfc6962Cache(r, 8, cache.MinHeightPolicy(0)))
+	r.EqualError(err, "width 9 is greater than the width of the cache (8)")
+	_, err = merkle.VerifyConsistencyProof(3, 8, nil, roots[2], roots[7], nil)
+	r.Error(err)
+
+	// When m is a power of two, the old root is the first node the proof is validated with, so it can't be omitted.
+	proof, err = merkle.GenerateConsistencyProof(4, 8, rfc6962Cache(r, 8, cache.MinHeightPolicy(0)))
+	r.NoError(err)
+	_, err = merkle.VerifyConsistencyProof(4, 8, proof, nil, roots[7], hash)
+	r.EqualError(err, "root of tree of width 4 is required")
+	_, err = merkle.VerifyConsistencyProof(8, 8, nil, nil, nil, hash)
+	r.EqualError(err, "root of tree of width 8 is required")
+}
@@ -73,6 +73,11 @@ type LeafIterator struct {
 	leaves  [][]byte
 }
 
+// NewLeafIterator returns an iterator over leaves, where leaves[i] is the leaf at indices[i].
+func NewLeafIterator(indices []uint64, leaves [][]byte) *LeafIterator {
+	return &LeafIterator{indices: indices, leaves: leaves}
+}
+
 // LeafIterator.next() returns the leaf index and value
 func (it *LeafIterator) next() (Position, []byte, error) {
 	if len(it.indices) == 0 {
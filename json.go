@@ -0,0 +1,134 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// proofJSON is the JSON representation of a Proof. Leaves and nodes are lowercase hex strings.
+type proofJSON struct {
+	HashName string   `json:"hash,omitempty"`
+	NodeSize int      `json:"nodeSize"`
+	Indices  []uint64 `json:"indices"`
+	Leaves   []string `json:"leaves"`
+	Nodes    []string `json:"nodes"`
+}
+
+// MarshalJSON encodes the proof as JSON, with leaves and nodes as lowercase hex strings. The leaves are sorted by index
+// so the output is stable. A zero NodeSize is encoded as NodeSize.
+func (p Proof) MarshalJSON() ([]byte, error) {
+	if len(p.Indices) != len(p.Leaves) {
+		return nil, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(p.Leaves),
+			len(p.Indices))
+	}
+	if p.NodeSize == 0 {
+		p.NodeSize = NodeSize
+	}
+	if err := p.checkNodeSizes(); err != nil {
+		return nil, err
+	}
+	order := make([]int, len(p.Indices))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return p.Indices[order[i]] < p.Indices[order[j]] })
+	encoded := proofJSON{
+		HashName: p.HashName,
+		NodeSize: p.NodeSize,
+		Indices:  make([]uint64, len(order)),
+		Leaves:   make([]string, len(order)),
+		Nodes:    encodeHexNodes(p.Nodes),
+	}
+	for i, j := range order {
+		encoded.Indices[i] = p.Indices[j]
+		encoded.Leaves[i] = hex.EncodeToString(p.Leaves[j])
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON. Leaves and nodes whose size isn't the proof's node size are
+// rejected.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var encoded proofJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	if encoded.NodeSize == 0 {
+		encoded.NodeSize = NodeSize
+	}
+	decoded := Proof{HashName: encoded.HashName, NodeSize: encoded.NodeSize, Indices: encoded.Indices}
+	var err error
+	if decoded.Leaves, err = decodeHexNodes(encoded.Leaves); err != nil {
+		return fmt.Errorf("while decoding leaves: %w", err)
+	}
+	if decoded.Nodes, err = decodeHexNodes(encoded.Nodes); err != nil {
+		return fmt.Errorf("while decoding nodes: %w", err)
+	}
+	if len(decoded.Indices) != len(decoded.Leaves) {
+		return fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(decoded.Leaves),
+			len(decoded.Indices))
+	}
+	if err := decoded.checkNodeSizes(); err != nil {
+		return err
+	}
+	*p = decoded
+	return nil
+}
+
+// MarshalJSON encodes the snapshot as an array of lowercase hex strings, with null for layers without a parked node.
+func (s ParkingSnapshot) MarshalJSON() ([]byte, error) {
+	encoded := make([]*string, len(s))
+	for i, n := range s {
+		if n != nil {
+			h := hex.EncodeToString(n)
+			encoded[i] = &h
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a snapshot encoded with MarshalJSON. Parked nodes must be NodeSize bytes long.
+func (s *ParkingSnapshot) UnmarshalJSON(data []byte) error {
+	var encoded []*string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded := make(ParkingSnapshot, len(encoded))
+	for i, h := range encoded {
+		if h == nil {
+			continue
+		}
+		n, err := hex.DecodeString(*h)
+		if err != nil {
+			return fmt.Errorf("while decoding parked node %d: %w", i, err)
+		}
+		if len(n) != NodeSize {
+			return fmt.Errorf("parked node %d has size %d instead of %d", i, len(n), NodeSize)
+		}
+		decoded[i] = n
+	}
+	*s = decoded
+	return nil
+}
+
+func encodeHexNodes(nodes [][]byte) []string {
+	encoded := make([]string, len(nodes))
+	for i, n := range nodes {
+		encoded[i] = hex.EncodeToString(n)
+	}
+	return encoded
+}
+
+func decodeHexNodes(encoded []string) ([][]byte, error) {
+	var nodes [][]byte
+	for i, h := range encoded {
+		n, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
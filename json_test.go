@@ -0,0 +1,76 @@
+package merkle_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestProofJSON(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	indices, leaves, nodes, err := GenerateProof(setOf(0, 4, 7), cacheReader)
+	r.NoError(err)
+
+	proof := merkle.Proof{
+		HashName: merkle.Sha256HashName,
+		NodeSize: NodeSize,
+		Indices:  indices,
+		Leaves:   leaves,
+		Nodes:    nodes,
+	}
+	data, err := json.Marshal(proof)
+	r.NoError(err)
+	r.NotRegexp("[A-F]", string(data)) // Hex is lowercase.
+	r.Contains(string(data), `"indices":[0,4,7]`)
+	r.Contains(string(data), `"leaves":["0000000000000000000000000000000000000000000000000000000000000000",`+
+		`"0400000000000000000000000000000000000000000000000000000000000000",`+
+		`"0700000000000000000000000000000000000000000000000000000000000000"]`)
+
+	var decoded merkle.Proof
+	r.NoError(json.Unmarshal(data, &decoded))
+	r.Equal(proof, decoded)
+	valid, err := decoded.Verify(tree.Root())
+	r.NoError(err)
+	r.True(valid)
+
+	// Leaves are sorted by index, keeping them paired.
+	unsorted := proof
+	unsorted.Indices = []uint64{7, 0, 4}
+	unsorted.Leaves = [][]byte{leaves[2], leaves[0], leaves[1]}
+	unsortedData, err := json.Marshal(unsorted)
+	r.NoError(err)
+	r.Equal(data, unsortedData)
+
+	err = json.Unmarshal([]byte(`{"nodeSize":32,"indices":[0],"leaves":["00"],"nodes":[]}`), &decoded)
+	r.EqualError(err, "leaf 0 has size 1 instead of 32")
+	err = json.Unmarshal([]byte(`{"nodeSize":32,"indices":[0],"leaves":["zz"],"nodes":[]}`), &decoded)
+	r.ErrorContains(err, "while decoding leaves: entry 0: ")
+}
+
+func TestParkingSnapshotJSON(t *testing.T) {
+	r := require.New(t)
+
+	snapshot := merkle.ParkingSnapshot{nil, NewNodeFromUint64(0xab), nil}
+	data, err := json.Marshal(snapshot)
+	r.NoError(err)
+	r.Equal(`[null,"ab00000000000000000000000000000000000000000000000000000000000000",null]`, string(data))
+
+	var decoded merkle.ParkingSnapshot
+	r.NoError(json.Unmarshal(data, &decoded))
+	r.Equal(snapshot, decoded)
+
+	r.EqualError(json.Unmarshal([]byte(`["abcd"]`), &decoded), "parked node 0 has size 2 instead of 32")
+}
@@ -0,0 +1,92 @@
+package merkle
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/spacemeshos/merkle-tree/cache"
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
+)
+
+// LiveCache is a fully cached tree that can be appended to while proofs are generated from earlier snapshots of it.
+// All methods are safe for concurrent use.
+type LiveCache struct {
+	mu     sync.Mutex
+	tree   *Tree
+	layers map[uint]*liveLayer
+	hash   HashFunc
+}
+
+// NewLiveCache returns an empty LiveCache that hashes with the given function.
+func NewLiveCache(hash HashFunc) (*LiveCache, error) {
+	lc := &LiveCache{layers: make(map[uint]*liveLayer), hash: hash}
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), func(layerHeight uint) (LayerReadWriter, error) {
+		layer := &liveLayer{}
+		lc.layers[layerHeight] = layer
+		return layer, nil
+	})
+	tree, err := NewTreeBuilder().WithHashFunc(hash).WithCacheWriter(cacheWriter).Build()
+	if err != nil {
+		return nil, err
+	}
+	lc.tree = tree
+	return lc, nil
+}
+
+// Append adds a leaf to the tree.
+func (lc *LiveCache) Append(leaf []byte) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.tree.AddLeaf(leaf)
+}
+
+// ReaderSnapshot returns a CacheReader with a point-in-time view of the tree, which isn't affected by later appends.
+// The snapshot shares memory with the LiveCache rather than copying the layers.
+func (lc *LiveCache) ReaderSnapshot() (CacheReader, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	snapshot := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	snapshot.SetHash(lc.hash)
+	for height, layer := range lc.layers {
+		// Appends never modify nodes within the current width, and the capacity limit ensures that appending to the
+		// snapshot's layer doesn't write into the live layer's buffer.
+		nodes := layer.nodes[:len(layer.nodes):len(layer.nodes)]
+		snapshot.SetLayer(height, readwriters.NewSliceReadWriter(nodes))
+	}
+	return snapshot.GetReader()
+}
+
+var errLiveLayerRead = errors.New("live cache layers must be read through a snapshot")
+
+// liveLayer is a write-only layer of a LiveCache. Reads go through the read-writers of a snapshot.
+type liveLayer struct {
+	nodes []byte
+}
+
+// A compile time check to ensure that liveLayer fully implements LayerReadWriter.
+var _ LayerReadWriter = (*liveLayer)(nil)
+
+func (l *liveLayer) Seek(uint64) error {
+	return errLiveLayerRead
+}
+
+func (l *liveLayer) ReadNext() ([]byte, error) {
+	return nil, errLiveLayerRead
+}
+
+func (l *liveLayer) Width() (uint64, error) {
+	return uint64(len(l.nodes) / NodeSize), nil
+}
+
+func (l *liveLayer) Append(p []byte) (n int, err error) {
+	l.nodes = append(l.nodes, p...)
+	return len(p), nil
+}
+
+func (l *liveLayer) Flush() error {
+	return nil
+}
+
+func (l *liveLayer) Close() error {
+	return nil
+}
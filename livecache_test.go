@@ -0,0 +1,63 @@
+package merkle_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+)
+
+func TestLiveCache(t *testing.T) {
+	r := require.New(t)
+	const leafCount = 200
+
+	liveCache, err := merkle.NewLiveCache(merkle.GetSha256Parent)
+	r.NoError(err)
+	_, err = liveCache.ReaderSnapshot()
+	r.EqualError(err, "base layer cannot be empty")
+	r.NoError(liveCache.Append(NewNodeFromUint64(0)))
+
+	appendErr := make(chan error, 1)
+	go func() {
+		defer close(appendErr)
+		for i := uint64(1); i < leafCount; i++ {
+			if err := liveCache.Append(NewNodeFromUint64(i)); err != nil {
+				appendErr <- err
+				return
+			}
+		}
+	}()
+
+	for width := uint64(0); width < leafCount; {
+		snapshot, err := liveCache.ReaderSnapshot()
+		r.NoError(err)
+		width, err = snapshot.GetLayerReader(0).Width()
+		r.NoError(err)
+
+		expectedRoot := buildRoot(t, width)
+		provenIndices := []uint64{0, width - 1}
+		if width == 1 {
+			provenIndices = provenIndices[:1]
+		}
+		_, leaves, proof, err := merkle.GenerateProof(setOf(provenIndices...), snapshot)
+		r.NoError(err)
+		valid, err := merkle.ValidatePartialTree(provenIndices, leaves, proof, expectedRoot, merkle.GetSha256Parent)
+		r.NoError(err)
+		r.True(valid, "width: %d", width)
+
+		if width == leafCount {
+			break
+		}
+	}
+	r.NoError(<-appendErr)
+}
+
+func buildRoot(t *testing.T, width uint64) []byte {
+	tree, err := merkle.NewTree()
+	require.NoError(t, err)
+	for i := uint64(0); i < width; i++ {
+		require.NoError(t, tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	return tree.Root()
+}
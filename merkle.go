@@ -1,9 +1,17 @@
 package merkle
 
 import (
+	"bytes"
+	stdsha256 "crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
+	"math"
+	"math/bits"
+	"sync"
 
 	"github.com/minio/sha256-simd"
+	"github.com/zeebo/blake3"
 
 	"github.com/spacemeshos/merkle-tree/shared"
 )
@@ -19,6 +27,9 @@ type (
 	CacheReader     = shared.CacheReader
 )
 
+// LeafHashFunc hashes a leaf before it's added to the tree, appending the result to buf.
+type LeafHashFunc func(buf, leaf []byte) []byte
+
 var RootHeightFromWidth = shared.RootHeightFromWidth
 
 var EmptyNode node
@@ -30,6 +41,10 @@ var PaddingValue = node{
 	OnProvenPath: false,
 }
 
+// ErrPaddingLeaf is returned by AddLeaf when a tree built with WithRejectPaddingLeaves is given a leaf that equals the
+// padding value.
+var ErrPaddingLeaf = errors.New("leaf equals the padding value")
+
 // node is a node in the merkle tree.
 type node struct {
 	value        []byte
@@ -86,29 +101,163 @@ func (s *sparseBoolStack) Pop() bool {
 	return ret
 }
 
+// HasTrueBefore returns whether any of the remaining true indices is lower than endIndex.
+func (s *sparseBoolStack) HasTrueBefore(endIndex uint64) bool {
+	return len(s.sortedTrueIndices) > 0 && s.sortedTrueIndices[0] < endIndex
+}
+
+// Skip advances the stack by n indices, discarding any true indices among them.
+func (s *sparseBoolStack) Skip(n uint64) {
+	s.currentIndex += n
+	for len(s.sortedTrueIndices) > 0 && s.sortedTrueIndices[0] < s.currentIndex {
+		s.sortedTrueIndices = s.sortedTrueIndices[1:]
+	}
+}
+
 // Tree calculates a merkle tree root. It can optionally calculate a proof, or partial tree, for leaves defined in
 // advance. Leaves are appended to the tree incrementally. It uses O(log(n)) memory to calculate the root and
 // O(k*log(n)) (k being the number of leaves to prove) memory to calculate proofs.
 //
 // Tree is NOT thread safe.
 type Tree struct {
-	baseLayer     *layer // The leaf layer (0)
-	hash          HashFunc
-	proof         [][]byte
-	leavesToProve *sparseBoolStack
-	cacheWriter   CacheWriter
-	minHeight     uint
-	parentBuf     []byte
+	baseLayer      *layer // The leaf layer (0)
+	hash           HashFunc
+	proof          [][]byte
+	leavesToProve  *sparseBoolStack
+	cacheWriter    CacheWriter
+	minHeight      uint
+	parentBuf      []byte
+	leafCount      uint64
+	leafObserver   func(index uint64, leaf []byte)
+	provePredicate func(index uint64, leaf []byte) bool
+	leafHash       LeafHashFunc
+	leafBuf        []byte
+	metrics        Metrics
+	nodePool       *sync.Pool // Recycles the buffers of ephemeral nodes, as *[]byte. Optional.
+
+	rejectPaddingLeaves bool
+	checkLeafSize       bool // Whether leaves must have the size of the padding node.
+
+	padToPowerOfTwo bool
+	paddingNodes    [][]byte // Roots of all-padding subtrees, by height. Only used when padToPowerOfTwo is set.
+	noPadding       bool
+	padding         node // PaddingValue, or a zero node of a different size.
+}
+
+// UnbalancedTreeError is returned by CheckedRoot and CheckedRootAndProof when a tree built with WithNoPadding would
+// need padding to calculate its root.
+type UnbalancedTreeError struct {
+	LeafCount uint64
+	MinHeight uint
+}
+
+func (e *UnbalancedTreeError) Error() string {
+	return fmt.Sprintf("tree with %d leaves and min height %d is unbalanced and padding is disabled", e.LeafCount,
+		e.MinHeight)
 }
 
 // AddLeaf incorporates a new leaf to the state of the tree. It updates the state required to eventually determine the
-// root of the tree and also updates the proof, if applicable.
+// root of the tree and also updates the proof, if applicable. When the tree has a leaf hash function, the leaf is
+// hashed with it before being added, while the prove predicate and leaf observer still get the original value.
 func (t *Tree) AddLeaf(value []byte) error {
-	n := node{
-		value:        value,
-		OnProvenPath: t.leavesToProve.Pop(),
+	if t.leafHash == nil {
+		return t.addLeafNode(value, value)
+	}
+	t.leafBuf = t.leafHash(t.leafBuf[:0], value)
+	return t.addLeafNode(t.leafBuf, value)
+}
+
+// AddLeafHashed works like AddLeaf, but the leaf node is added to the leaf layer as-is, even if the tree has a leaf
+// hash function. Use it for leaves that are already hashed, e.g. to mix raw values added with AddLeaf and hashes of
+// values computed elsewhere. The prove predicate and leaf observer get the leaf node. Without a leaf hash function,
+// AddLeafHashed and AddLeaf are equivalent.
+func (t *Tree) AddLeafHashed(leafNode []byte) error {
+	return t.addLeafNode(leafNode, leafNode)
+}
+
+// addLeafNode adds the leaf node value, which is the leaf hash of the original value unless the leaf was added as-is.
+func (t *Tree) addLeafNode(value, original []byte) error {
+	n := node{value: value}
+	if t.checkLeafSize && len(n.value) != len(t.padding.value) {
+		return fmt.Errorf("leaf %d has size %d instead of %d", t.leafCount, len(n.value), len(t.padding.value))
+	}
+	if t.rejectPaddingLeaves && bytes.Equal(n.value, t.padding.value) {
+		return fmt.Errorf("%w: leaf %d", ErrPaddingLeaf, t.leafCount)
+	}
+	n.OnProvenPath = t.leavesToProve.Pop()
+	if t.provePredicate != nil && t.provePredicate(t.leafCount, original) {
+		n.OnProvenPath = true
+	}
+	if t.leafObserver != nil {
+		t.leafObserver(t.leafCount, original)
+	}
+	t.leafCount++
+	return t.addNode(t.baseLayer, n)
+}
+
+// AddLeaves incorporates the given leaves, in order, exactly as if AddLeaf was called for each of them. It stops at the
+// first error.
+func (t *Tree) AddLeaves(values [][]byte) error {
+	for i, value := range values {
+		if err := t.AddLeaf(value); err != nil {
+			return fmt.Errorf("while adding leaf %d of batch: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Reset clears the state of the tree, so it can be reused for a new set of leaves without reallocating its layers and
+// buffers. The hash function and other options are kept, but there are no leaves to prove after a reset. Trees with a
+// cache writer can't be reset, since the cached layers can't be cleared, so an error is returned for them.
+func (t *Tree) Reset() error {
+	if _, cacheDisabled := t.cacheWriter.(disabledCacheWriter); !cacheDisabled {
+		return errors.New("cannot reset a tree with a cache writer")
+	}
+	for l := t.baseLayer; l != nil; l = l.next {
+		l.parking.value = l.parking.value[:0]
+		l.parking.OnProvenPath = false
+	}
+	// The previous proof may still be used by the caller, so it must not be reused.
+	t.proof = nil
+	t.leavesToProve = NewSparseBoolStack(nil)
+	t.leafCount = 0
+	return nil
+}
+
+// AddSubtree incorporates the root of a complete subtree of the given height, as if all of its 2^height leaves were
+// added with AddLeaf. The tree must currently have a multiple of 2^height leaves. Leaves of the subtree can't be
+// proven and aren't reported to the leaf observer, and the subtree can't be added when any layer below its root is
+// cached, since the cache would be missing the subtree's nodes.
+func (t *Tree) AddSubtree(root []byte, height uint) error {
+	if height >= 64 {
+		return fmt.Errorf("cannot add subtree of height %d: its leaves can't be counted", height)
+	}
+	size := uint64(1) << height
+	if t.leafCount%size != 0 {
+		return fmt.Errorf("cannot add subtree of height %d after %d leaves", height, t.leafCount)
+	}
+	if t.leafCount > math.MaxUint64-size {
+		return fmt.Errorf("cannot add subtree of height %d after %d leaves: too many leaves", height, t.leafCount)
+	}
+	if t.leavesToProve.HasTrueBefore(t.leafCount + size) {
+		return fmt.Errorf("cannot add subtree of height %d: it contains leaves to prove", height)
 	}
 	l := t.baseLayer
+	for ; l.height < height; l = l.next {
+		if err := l.ensureNextLayerExists(t.cacheWriter); err != nil {
+			return err
+		}
+		if l.cache != nil {
+			return fmt.Errorf("cannot add subtree of height %d: layer %d is cached", height, l.height)
+		}
+	}
+	t.leavesToProve.Skip(size)
+	t.leafCount += size
+	return t.addNode(l, node{value: root})
+}
+
+// addNode adds a node to layer l, and propagates the resulting parents up the tree.
+func (t *Tree) addNode(l *layer, n node) error {
 	var lastCachingError error
 
 	// Loop through the layers, starting from the base layer.
@@ -158,16 +307,66 @@ func (t *Tree) AddLeaf(value []byte) error {
 }
 
 // Root returns the root of the tree.
-// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly.
+// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly, unless padding is
+// disabled with WithNoPadding, in which case it returns nil.
 func (t *Tree) Root() []byte {
+	if root, found := t.balancedRoot(); found {
+		return root
+	}
 	root, _ := t.RootAndProof()
 	return root
 }
 
+// CheckedRoot works like Root, but returns an *UnbalancedTreeError if padding is disabled with WithNoPadding and the
+// tree is unbalanced.
+func (t *Tree) CheckedRoot() ([]byte, error) {
+	if err := t.checkPadding(); err != nil {
+		return nil, err
+	}
+	return t.Root(), nil
+}
+
+// CheckedRootAndProof works like RootAndProof, but returns an *UnbalancedTreeError if padding is disabled with
+// WithNoPadding and the tree is unbalanced.
+func (t *Tree) CheckedRootAndProof() ([]byte, [][]byte, error) {
+	if err := t.checkPadding(); err != nil {
+		return nil, nil, err
+	}
+	root, proof := t.RootAndProof()
+	return root, proof, nil
+}
+
+// checkPadding returns an error if the tree needs padding even though it's disabled.
+func (t *Tree) checkPadding() error {
+	if !t.noPadding {
+		return nil
+	}
+	if _, balanced := t.balancedRoot(); !balanced {
+		return &UnbalancedTreeError{LeafCount: t.leafCount, MinHeight: t.minHeight}
+	}
+	return nil
+}
+
+// balancedRoot returns the parked node of the top layer if the tree is balanced and reaches minHeight, in which case
+// that node is the root and no padding or hashing is needed.
+func (t *Tree) balancedRoot() ([]byte, bool) {
+	top := t.topLayer()
+	if top.height < t.minHeight || top.parking.IsEmpty() {
+		return nil, false
+	}
+	for l := t.baseLayer; l != top; l = l.next {
+		if !l.parking.IsEmpty() {
+			return nil, false
+		}
+	}
+	return top.parking.value, true
+}
+
 // Proof returns a partial tree proving the membership of leaves that were passed in leavesToProve when the tree was
 // initialized. For a single proved leaf this is a standard merkle proof (one sibling per layer of the tree from the
 // leaves to the root, excluding the proved leaf and root).
-// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly.
+// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly, unless padding is
+// disabled with WithNoPadding, in which case it returns nil.
 func (t *Tree) Proof() [][]byte {
 	_, proof := t.RootAndProof()
 	return proof
@@ -176,18 +375,23 @@ func (t *Tree) Proof() [][]byte {
 // RootAndProof returns the root of the tree and a partial tree proving the membership of leaves that were passed in
 // leavesToProve when the tree was initialized. For a single proved leaf this is a standard merkle proof (one sibling
 // per layer of the tree from the leaves to the root, excluding the proved leaf and root).
-// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly.
+// If the tree is unbalanced (num. of leaves is not a power of 2) it will perform padding on-the-fly, unless padding is
+// disabled with WithNoPadding, in which case it returns nil for both.
 func (t *Tree) RootAndProof() ([]byte, [][]byte) {
+	if t.checkPadding() != nil {
+		return nil, nil
+	}
 	ephemeralProof := t.proof
 	var ephemeralNode node
-	l := t.baseLayer
+	var ephemeralBuf *[]byte // The pooled buffer holding ephemeralNode, if any.
+	l, top := t.baseLayer, t.topLayer()
 	for height := uint(0); height < t.minHeight || l != nil; height++ {
 
 		// If we've reached the last layer and the ephemeral node is still empty, the tree is balanced and the parked
 		// node is its root.
 		// In any other case (minHeight not reached, or the tree is unbalanced) we want to add padding at this point.
 		reachedMinHeight := height >= t.minHeight
-		onLastLayer := l != nil && l.next == nil
+		onLastLayer := l != nil && l == top
 		parkingIsBalancedTreeRoot := reachedMinHeight && onLastLayer && ephemeralNode.IsEmpty()
 		if parkingIsBalancedTreeRoot {
 			return l.parking.value, ephemeralProof
@@ -197,7 +401,12 @@ func (t *Tree) RootAndProof() ([]byte, [][]byte) {
 		if l != nil {
 			parking = l.parking
 		}
-		parent, lChild, rChild := t.calcEphemeralParent(parking, ephemeralNode)
+		var buf []byte
+		parentBuf := t.getNodeBuf()
+		if parentBuf != nil {
+			buf = *parentBuf
+		}
+		parent, lChild, rChild := t.calcEphemeralParent(buf, parking, ephemeralNode, height)
 
 		// Consider adding children to the ephemeralProof. `onProvenPath` must be explicitly set -- an empty node has
 		// the default value `false` and would never pass this point.
@@ -209,21 +418,89 @@ func (t *Tree) RootAndProof() ([]byte, [][]byte) {
 				ephemeralProof = append(ephemeralProof, rChild.value)
 			}
 		}
+		// The previous ephemeral node is one of the children. Unless it was just added to the proof, it's no longer
+		// needed.
+		if ephemeralBuf != nil && !(parent.OnProvenPath && !ephemeralNode.OnProvenPath) {
+			t.putNodeBuf(ephemeralBuf)
+		}
+		ephemeralBuf = nil
+		if parentBuf != nil {
+			if parent.IsEmpty() {
+				t.putNodeBuf(parentBuf)
+			} else {
+				*parentBuf = parent.value
+				ephemeralBuf = parentBuf
+			}
+		}
 		ephemeralNode = parent
-		if l != nil {
+		if l == top {
+			l = nil
+		} else if l != nil {
 			l = l.next
 		}
 	}
 	return ephemeralNode.value, ephemeralProof
 }
 
+// topLayer returns the highest layer that has a parked node, or the base layer if there are none. Layers above it
+// may exist if the tree was pre-grown, but they don't affect the state of the tree.
+func (t *Tree) topLayer() *layer {
+	top := t.baseLayer
+	for l := t.baseLayer.next; l != nil; l = l.next {
+		if !l.parking.IsEmpty() {
+			top = l
+		}
+	}
+	return top
+}
+
+// Grow pre-allocates the layers of the tree (and requests their cache writers) up to toHeight, so that AddLeaf doesn't
+// need to create them as the tree grows. When a cache writer is attached, toHeight shouldn't exceed the final height
+// of the tree, since the cache would otherwise contain empty layers above the root.
+func (t *Tree) Grow(toHeight uint) error {
+	for l := t.baseLayer; l.height < toHeight; l = l.next {
+		if err := l.ensureNextLayerExists(t.cacheWriter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LeafCount returns the number of leaves added to the tree, including leaves covered by AddSubtree and the leaf count
+// restored by TreeBuilder.WithResumeState. SetParkedNodes doesn't change it, so after SetParkedNodes it only counts the
+// leaves added since.
+func (t *Tree) LeafCount() uint64 {
+	return t.leafCount
+}
+
+// Height returns the height of the tree's root as it would be calculated now: the height of the highest parked node,
+// plus one if any lower layer also has a parked node and padding is needed, or minHeight if that's larger. Unlike
+// LeafCount, it reflects nodes restored with SetParkedNodes. An empty tree has height 0, unless minHeight is set.
+func (t *Tree) Height() uint {
+	var height uint
+	top := t.topLayer()
+	if !top.parking.IsEmpty() {
+		height = top.height
+		for l := t.baseLayer; l != top; l = l.next {
+			if !l.parking.IsEmpty() {
+				height++
+				break
+			}
+		}
+	}
+	if height < t.minHeight {
+		return t.minHeight
+	}
+	return height
+}
+
 // GetParkedNodes appends parked nodes from all layers
 // starting with the base layer to the `ret`.
 func (t *Tree) GetParkedNodes(ret [][]byte) [][]byte {
-	layer := t.baseLayer
+	layer, top := t.baseLayer, t.topLayer()
 	for {
 		ret = append(ret, layer.parking.value)
-		if layer.next == nil {
+		if layer == top {
 			break
 		} else {
 			layer = layer.next
@@ -232,6 +509,38 @@ func (t *Tree) GetParkedNodes(ret [][]byte) [][]byte {
 	return ret
 }
 
+// resume restores the parked nodes and leaf count of a tree that had leafCount leaves. See
+// TreeBuilder.WithResumeState.
+func (t *Tree) resume(parked [][]byte, leafCount uint64) error {
+	if uint(len(parked)) < uint(bits.Len64(leafCount)) {
+		return fmt.Errorf("%d parked nodes are too few for %d leaves", len(parked), leafCount)
+	}
+	for height, n := range parked {
+		if isParked := height < 64 && leafCount>>height&1 == 1; isParked != (len(n) > 0) {
+			return fmt.Errorf("parked nodes don't match %d leaves at layer %d", leafCount, height)
+		}
+	}
+	if t.leavesToProve.HasTrueBefore(leafCount) {
+		return fmt.Errorf("cannot prove leaves before the resumed leaf count %d", leafCount)
+	}
+	l := t.baseLayer
+	for height, n := range parked {
+		l.parking.value = append(l.parking.value[:0], n...)
+		if height < len(parked)-1 {
+			if err := l.ensureNextLayerExists(t.cacheWriter); err != nil {
+				return err
+			}
+			l = l.next
+		}
+	}
+	t.leavesToProve.Skip(leafCount)
+	t.leafCount = leafCount
+	return nil
+}
+
+// SetParkedNodes restores the parked nodes of a previous tree, as returned by GetParkedNodes. It doesn't restore the
+// leaf count, so LeafCount and the indices of leaves to prove and of observed leaves start from zero. Use
+// TreeBuilder.WithResumeState to restore both.
 func (t *Tree) SetParkedNodes(nodes [][]byte) error {
 	layer := t.baseLayer
 	for i := 0; i < len(nodes); i++ {
@@ -251,37 +560,193 @@ func (t *Tree) SetParkedNodes(nodes [][]byte) error {
 	return nil
 }
 
+// paddingAt returns the node used for padding at the given height. By default this is PaddingValue (or a zero node of
+// the tree's node size) on every layer. When padding to a power of two, it's the root of a subtree of that height whose
+// leaves are all padding.
+func (t *Tree) paddingAt(height uint) node {
+	if !t.padToPowerOfTwo {
+		return t.padding
+	}
+	if len(t.paddingNodes) == 0 {
+		t.paddingNodes = append(t.paddingNodes, t.padding.value)
+	}
+	for uint(len(t.paddingNodes)) <= height {
+		below := t.paddingNodes[len(t.paddingNodes)-1]
+		t.paddingNodes = append(t.paddingNodes, t.hash(nil, below, below))
+	}
+	return node{value: t.paddingNodes[height]}
+}
+
 // calcEphemeralParent calculates the parent using the layer parking and ephemeralNode. When one of those is missing it
-// uses PaddingValue to pad. It returns the actual nodes used along with the parent.
-func (t *Tree) calcEphemeralParent(parking, ephemeralNode node) (parent, lChild, rChild node) {
+// uses the padding for the given height instead. It returns the actual nodes used along with the parent. The buf can be
+// used to reuse memory for hashing.
+func (t *Tree) calcEphemeralParent(buf []byte, parking, ephemeralNode node, height uint) (parent, lChild, rChild node) {
 	switch {
 	case !parking.IsEmpty() && !ephemeralNode.IsEmpty():
 		lChild, rChild = parking, ephemeralNode
 
 	case !parking.IsEmpty() && ephemeralNode.IsEmpty():
-		lChild, rChild = parking, PaddingValue
+		lChild, rChild = parking, t.paddingAt(height)
 
 	case parking.IsEmpty() && !ephemeralNode.IsEmpty():
-		lChild, rChild = ephemeralNode, PaddingValue
+		lChild, rChild = ephemeralNode, t.paddingAt(height)
 
 	default: // both are empty
 		return EmptyNode, EmptyNode, EmptyNode
 	}
-	return t.calcParent(nil, lChild, rChild), lChild, rChild
+	return t.calcParent(buf, lChild, rChild), lChild, rChild
+}
+
+// getNodeBuf returns an empty buffer from the node pool, or nil if the tree has no node pool.
+func (t *Tree) getNodeBuf() *[]byte {
+	if t.nodePool == nil {
+		return nil
+	}
+	buf, ok := t.nodePool.Get().(*[]byte)
+	if !ok {
+		buf = new([]byte)
+	}
+	*buf = (*buf)[:0]
+	return buf
+}
+
+func (t *Tree) putNodeBuf(buf *[]byte) {
+	t.nodePool.Put(buf)
 }
 
 // calcParent calculates the parent node of two child nodes.
 // The buf can be used to reuse memory for hashing.
 func (t *Tree) calcParent(buf []byte, lChild, rChild node) node {
+	t.metrics.OnHash()
 	return node{
 		value:        t.hash(buf, lChild.value, rChild.value),
 		OnProvenPath: lChild.OnProvenPath || rChild.OnProvenPath,
 	}
 }
 
+// StreamProve builds a tree from leaves read from the channel until it's closed, and returns its root along with the
+// proven leaves and proof for the leaves in indices. It doesn't need a cache, so each leaf is only read once. All leaves
+// must have the size of the first one.
+//
+// On error, StreamProve keeps receiving from leaves and discards them until the channel is closed, so the producer
+// never blocks on a send. The producer must therefore always close the channel, and should stop producing early by
+// other means (e.g. a context) if the remaining leaves are expensive to produce.
+func StreamProve(leaves <-chan []byte, indices Set, hash HashFunc) (root []byte, provenLeaves, proof [][]byte,
+	err error,
+) {
+	defer func() {
+		if err != nil {
+			for range leaves {
+			}
+		}
+	}()
+	t, err := NewTreeBuilder().WithHashFunc(hash).WithLeavesToProve(indices).Build()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("while building a tree: %w", err)
+	}
+	leafSize := -1
+	for leaf := range leaves {
+		if leafSize == -1 {
+			leafSize = len(leaf)
+		} else if len(leaf) != leafSize {
+			return nil, nil, nil, fmt.Errorf("leaf %d has size %d instead of %d", t.leafCount, len(leaf), leafSize)
+		}
+		if indices[t.leafCount] {
+			provenLeaves = append(provenLeaves, append([]byte(nil), leaf...))
+		}
+		if err := t.AddLeaf(leaf); err != nil {
+			return nil, nil, nil, fmt.Errorf("while adding a leaf: %w", err)
+		}
+	}
+	root, proof = t.RootAndProof()
+	return root, provenLeaves, proof, nil
+}
+
+// GetSha256Parent hashes the children with SHA-256, using the SIMD-accelerated implementation from
+// github.com/minio/sha256-simd. It's the default hash function and the fastest choice on CPUs with SHA extensions or
+// AVX-512.
 func GetSha256Parent(buf, lChild, rChild []byte) []byte {
 	hasher := sha256.New()
 	hasher.Write(lChild)
 	hasher.Write(rChild)
 	return hasher.Sum(buf)
 }
+
+// GetStdSha256Parent hashes the children with SHA-256 from the standard library. It produces the same results as
+// GetSha256Parent and can be passed to TreeBuilder.WithHashFunc instead, e.g. on platforms where the SIMD
+// implementation isn't faster or when only standard library code may be used for auditing purposes.
+func GetStdSha256Parent(buf, lChild, rChild []byte) []byte {
+	hasher := stdsha256.New()
+	hasher.Write(lChild)
+	hasher.Write(rChild)
+	return hasher.Sum(buf)
+}
+
+// GetBlake3Parent hashes the children with BLAKE3 and returns a 32-byte digest appended to buf. It can be passed to
+// TreeBuilder.WithHashFunc and ValidatePartialTree as a faster alternative to SHA-256 on CPUs without SHA extensions.
+// Trees built with it have different roots than trees built with GetSha256Parent.
+func GetBlake3Parent(buf, lChild, rChild []byte) []byte {
+	hasher := blake3.New()
+	hasher.Write(lChild)
+	hasher.Write(rChild)
+	return hasher.Sum(buf)
+}
+
+// GetRFC6962LeafHash hashes a leaf as specified in RFC 6962 (Certificate Transparency): SHA-256 of the leaf prefixed
+// with 0x00. Use it with TreeBuilder.WithLeafHashFunc and GetRFC6962Parent to build CT-compatible trees.
+func GetRFC6962LeafHash(buf, leaf []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write([]byte{0x00})
+	hasher.Write(leaf)
+	return hasher.Sum(buf)
+}
+
+// GetRFC6962Parent hashes the children as specified in RFC 6962: SHA-256 of the children prefixed with 0x01. The
+// distinct prefixes prevent a leaf from being confused with an internal node. RFC 6962 trees aren't padded, so roots
+// only match CT roots when the number of leaves is a power of two.
+func GetRFC6962Parent(buf, lChild, rChild []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write([]byte{0x01})
+	hasher.Write(lChild)
+	hasher.Write(rChild)
+	return hasher.Sum(buf)
+}
+
+// SortedPairHashFunc wraps hash so the children are ordered lexicographically before hashing, as done by
+// OpenZeppelin's MerkleProof. Proofs for such trees don't depend on whether a sibling is on the left or the right. Equal
+// children are hashed in either order with the same result.
+func SortedPairHashFunc(hash HashFunc) HashFunc {
+	return func(buf, lChild, rChild []byte) []byte {
+		if bytes.Compare(lChild, rChild) > 0 {
+			lChild, rChild = rChild, lChild
+		}
+		return hash(buf, lChild, rChild)
+	}
+}
+
+// HashFuncFromStdHash adapts a standard hash.Hash (e.g. sha3.New256 or blake2b.New256) to a HashFunc. The returned
+// function reuses a single hash.Hash created by newHash, so it's not safe for concurrent use. Use
+// HashFuncFromStdHashPool when hashing from several goroutines.
+func HashFuncFromStdHash(newHash func() hash.Hash) HashFunc {
+	hasher := newHash()
+	return func(buf, lChild, rChild []byte) []byte {
+		hasher.Reset()
+		hasher.Write(lChild)
+		hasher.Write(rChild)
+		return hasher.Sum(buf[:0])
+	}
+}
+
+// HashFuncFromStdHashPool works like HashFuncFromStdHash, but takes the hash.Hash from a sync.Pool on every call, so the
+// returned function is safe for concurrent use.
+func HashFuncFromStdHashPool(newHash func() hash.Hash) HashFunc {
+	pool := sync.Pool{New: func() any { return newHash() }}
+	return func(buf, lChild, rChild []byte) []byte {
+		hasher := pool.Get().(hash.Hash)
+		defer pool.Put(hasher)
+		hasher.Reset()
+		hasher.Write(lChild)
+		hasher.Write(rChild)
+		return hasher.Sum(buf[:0])
+	}
+}
@@ -1,9 +1,12 @@
 package merkle_test
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -153,6 +156,40 @@ func TestNewTreeUnbalanced3(t *testing.T) {
 	r.Equal(expectedRoot, root)
 }
 
+func TestNewTreePadToPowerOfTwo(t *testing.T) {
+	r := require.New(t)
+	leavesToProve := setOf(3, 9)
+
+	padded, err := NewTreeBuilder().WithPadToPowerOfTwo().WithLeavesToProve(leavesToProve).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 10; i++ {
+		r.NoError(padded.AddLeaf(NewNodeFromUint64(i)))
+	}
+
+	full, err := NewProvingTree(leavesToProve)
+	r.NoError(err)
+	for i := uint64(0); i < 16; i++ {
+		leaf := NewNodeFromUint64(i)
+		if i >= 10 {
+			leaf = make([]byte, NodeSize) // Padding.
+		}
+		r.NoError(full.AddLeaf(leaf))
+	}
+
+	expectedRoot, expectedProof := full.RootAndProof()
+	root, proof := padded.RootAndProof()
+	r.Equal(expectedRoot, root)
+	r.Equal(expectedProof, proof)
+
+	onTheFlyRoot, _ := NewNodeFromHex("59f32a43534fe4c4c0966421aef624267cdf65bd11f74998c60f27c7caccb12d")
+	r.NotEqual(onTheFlyRoot, root)
+
+	valid, err := ValidatePartialTree(leavesToProve.AsSortedSlice(),
+		[][]byte{NewNodeFromUint64(3), NewNodeFromUint64(9)}, proof, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid, "Proof should be valid, but isn't")
+}
+
 func TestNewTreeUnbalancedProof(t *testing.T) {
 	r := require.New(t)
 
@@ -231,6 +268,579 @@ func BenchmarkNewTreeSmall(b *testing.B) {
 	*/
 }
 
+func TestTreeBuilder_WithNodePool(t *testing.T) {
+	r := require.New(t)
+
+	pool := &sync.Pool{New: func() any { return new([]byte) }}
+	leavesToProve := setOf(2, 9)
+	pooledTree, err := NewTreeBuilder().WithNodePool(pool).WithLeavesToProve(leavesToProve).Build()
+	r.NoError(err)
+	tree, err := NewProvingTree(leavesToProve)
+	r.NoError(err)
+
+	// Roots and proofs returned earlier must not be affected by recycled buffers.
+	var roots, proofs [][][]byte
+	for i := uint64(0); i < 21; i++ {
+		r.NoError(pooledTree.AddLeaf(NewNodeFromUint64(i)))
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		root, proof := pooledTree.RootAndProof()
+		expectedRoot, expectedProof := tree.RootAndProof()
+		r.Equal(expectedRoot, root)
+		r.Equal(expectedProof, proof)
+		roots = append(roots, [][]byte{root, expectedRoot})
+		proofs = append(proofs, [][]byte{flatten(proof), flatten(expectedProof)})
+	}
+	for i := range roots {
+		r.Equal(roots[i][1], roots[i][0])
+		r.Equal(proofs[i][1], proofs[i][0])
+	}
+}
+
+func flatten(nodes [][]byte) []byte {
+	var flat []byte
+	for _, n := range nodes {
+		flat = append(flat, n...)
+	}
+	return flat
+}
+
+func BenchmarkTreeBuilder_WithNodePool(b *testing.B) {
+	leaves := make([][]byte, 1<<10+1)
+	for i := range leaves {
+		leaves[i] = NewNodeFromUint64(uint64(i))
+	}
+	pool := &sync.Pool{New: func() any { return new([]byte) }}
+	for _, bc := range []struct {
+		name    string
+		builder merkle.TreeBuilder
+	}{
+		{"NoPool", NewTreeBuilder()},
+		{"Pool", NewTreeBuilder().WithNodePool(pool)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tree, _ := bc.builder.Build()
+			_ = tree.AddLeaves(leaves)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = tree.Root()
+			}
+		})
+	}
+}
+
+func TestTree_AddLeaves(t *testing.T) {
+	r := require.New(t)
+
+	var leaves [][]byte
+	for i := uint64(0); i < 10; i++ {
+		leaves = append(leaves, NewNodeFromUint64(i))
+	}
+	newTree := func() (*merkle.Tree, *cache.Writer) {
+		cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).WithLeavesToProve(setOf(3, 8)).Build()
+		r.NoError(err)
+		return tree, cacheWriter
+	}
+
+	expectedTree, expectedCacheWriter := newTree()
+	for _, leaf := range leaves {
+		r.NoError(expectedTree.AddLeaf(leaf))
+	}
+	tree, cacheWriter := newTree()
+	r.NoError(tree.AddLeaves(leaves[:4]))
+	r.NoError(tree.AddLeaves(nil))
+	r.NoError(tree.AddLeaves(leaves[4:]))
+
+	expectedRoot, expectedProof := expectedTree.RootAndProof()
+	root, proof := tree.RootAndProof()
+	r.Equal(expectedRoot, root)
+	r.Equal(expectedProof, proof)
+
+	expectedCacheReader, err := expectedCacheWriter.GetReader()
+	r.NoError(err)
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	r.Equal(len(expectedCacheReader.Layers()), len(cacheReader.Layers()))
+	for height, expectedLayer := range expectedCacheReader.Layers() {
+		r.Equal(expectedLayer, cacheReader.Layers()[height], "layer %d", height)
+	}
+}
+
+func BenchmarkTree_AddLeaves(b *testing.B) {
+	const size = 1 << 20
+	leaves := make([][]byte, size)
+	for i := range leaves {
+		leaves[i] = NewNodeFromUint64(uint64(i))
+	}
+	b.Run("AddLeaf", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree, _ := NewTree()
+			for _, leaf := range leaves {
+				_ = tree.AddLeaf(leaf)
+			}
+		}
+	})
+	b.Run("AddLeaves", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tree, _ := NewTree()
+			_ = tree.AddLeaves(leaves)
+		}
+	})
+}
+
+func TestTree_LeafCountAndHeight(t *testing.T) {
+	r := require.New(t)
+
+	for _, tc := range []struct {
+		leaves         uint64
+		minHeight      uint
+		expectedHeight uint
+	}{
+		{leaves: 0, expectedHeight: 0},
+		{leaves: 1, expectedHeight: 0},
+		{leaves: 2, expectedHeight: 1},
+		{leaves: 8, expectedHeight: 3},
+		{leaves: 9, expectedHeight: 4},
+		{leaves: 10, expectedHeight: 4},
+		{leaves: 0, minHeight: 3, expectedHeight: 3},
+		{leaves: 5, minHeight: 5, expectedHeight: 5},
+		{leaves: 16, minHeight: 2, expectedHeight: 4},
+	} {
+		tree, err := NewTreeBuilder().WithMinHeight(tc.minHeight).Build()
+		r.NoError(err)
+		for i := uint64(0); i < tc.leaves; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		r.Equal(tc.leaves, tree.LeafCount())
+		r.Equal(tc.expectedHeight, tree.Height(), "leaves: %d, min height: %d", tc.leaves, tc.minHeight)
+		if tc.leaves > 0 && tc.minHeight == 0 {
+			r.Equal(merkle.RootHeightFromWidth(tc.leaves), tree.Height())
+		}
+
+		// Parked nodes restore the height, but not the leaf count.
+		resumed, err := NewTreeBuilder().WithMinHeight(tc.minHeight).Build()
+		r.NoError(err)
+		r.NoError(resumed.SetParkedNodes(tree.GetParkedNodes(nil)))
+		r.Zero(resumed.LeafCount())
+		r.Equal(tc.expectedHeight, resumed.Height())
+	}
+}
+
+func TestTreeBuilder_WithRejectPaddingLeaves(t *testing.T) {
+	r := require.New(t)
+
+	zeroLeaf := make([]byte, NodeSize)
+
+	tree, err := NewTreeBuilder().WithRejectPaddingLeaves().WithLeavesToProve(setOf(1)).Build()
+	r.NoError(err)
+	r.NoError(tree.AddLeaf(NewNodeFromUint64(1)))
+	err = tree.AddLeaf(zeroLeaf)
+	r.ErrorIs(err, merkle.ErrPaddingLeaf)
+	r.EqualError(err, "leaf equals the padding value: leaf 1")
+	// The rejected leaf doesn't change the state of the tree.
+	r.Equal(uint64(1), tree.LeafCount())
+	r.NoError(tree.AddLeaf(NewNodeFromUint64(2)))
+	_, proof := tree.RootAndProof()
+	r.Equal([][]byte{NewNodeFromUint64(1)}, proof)
+
+	tree, err = NewTree()
+	r.NoError(err)
+	r.NoError(tree.AddLeaf(zeroLeaf))
+}
+
+func TestTree_AddLeafWrongSize(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	r.NoError(tree.AddLeaf(NewNodeFromUint64(0)))
+	r.EqualError(tree.AddLeaf(make([]byte, 20)), "leaf 1 has size 20 instead of 32")
+	r.Equal(uint64(1), tree.LeafCount())
+
+	tree, err = NewTreeBuilder().WithNodeSize(20).Build()
+	r.NoError(err)
+	r.NoError(tree.AddLeaf(make([]byte, 20)))
+	r.EqualError(tree.AddLeaf(NewNodeFromUint64(1)), "leaf 1 has size 32 instead of 20")
+
+	// Without a cache or an explicit node size, leaves of any size are accepted.
+	tree, err = NewTreeBuilder().WithHashFunc(concatLeaves).Build()
+	r.NoError(err)
+	r.NoError(tree.AddLeaf([]byte{1}))
+}
+
+func TestTreeBuilder_WithPaddingValue(t *testing.T) {
+	r := require.New(t)
+
+	sentinel := bytes.Repeat([]byte{0xff}, NodeSize)
+	leafIndices := []uint64{3, 9}
+	leaves := [][]byte{NewNodeFromUint64(3), NewNodeFromUint64(9)}
+	build := func(builder merkle.TreeBuilder) ([]byte, [][]byte) {
+		tree, err := builder.WithLeavesToProve(setOf(leafIndices...)).Build()
+		r.NoError(err)
+		for i := uint64(0); i < 10; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		return tree.RootAndProof()
+	}
+
+	defaultRoot, _ := build(NewTreeBuilder())
+	root, proof := build(NewTreeBuilder().WithPaddingValue(sentinel))
+	r.NotEqual(defaultRoot, root)
+	r.Contains(proof, sentinel)
+
+	valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+	valid, err = merkle.ValidatePartialTreeWithWidth(10, leafIndices, leaves, proof, root, GetSha256Parent,
+		merkle.ValidationPaddingValue(sentinel))
+	r.NoError(err)
+	r.True(valid)
+	_, err = merkle.ValidatePartialTreeWithWidth(10, leafIndices, leaves, proof, root, GetSha256Parent)
+	r.Error(err, "the sentinel isn't the default padding")
+
+	// Trailing padding siblings omitted from the proof of a min-height tree are filled in with the padding value.
+	root, proof = build(NewTreeBuilder().WithPaddingValue(sentinel).WithMinHeight(6))
+	shortProof := proof[:len(proof)-2]
+	valid, err = merkle.ValidatePartialTreeToHeight(6, leafIndices, leaves, shortProof, root, GetSha256Parent,
+		merkle.ValidationPaddingValue(sentinel))
+	r.NoError(err)
+	r.True(valid)
+	valid, err = merkle.ValidatePartialTreeToHeight(6, leafIndices, leaves, shortProof, root, GetSha256Parent)
+	r.NoError(err)
+	r.False(valid)
+
+	_, err = NewTreeBuilder().WithPaddingValue(sentinel).WithNodeSize(20).Build()
+	r.EqualError(err, "padding value has size 32 instead of 20")
+}
+
+func TestTree_Reset(t *testing.T) {
+	r := require.New(t)
+
+	tree, err := NewProvingTree(setOf(3))
+	r.NoError(err)
+	for i := uint64(0); i < 10; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	_, proof := tree.RootAndProof()
+	proofCopy := append([][]byte(nil), proof...)
+
+	r.NoError(tree.Reset())
+	r.Zero(tree.LeafCount())
+	r.Zero(tree.Height())
+	expectedTree, err := NewTree()
+	r.NoError(err)
+	for i := uint64(100); i < 105; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		r.NoError(expectedTree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	r.Equal(expectedTree.Root(), tree.Root())
+	r.Empty(tree.Proof())
+	r.Equal(proofCopy, proof, "the proof returned before the reset must not change")
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err = NewCachingTree(cacheWriter)
+	r.NoError(err)
+	r.EqualError(tree.Reset(), "cannot reset a tree with a cache writer")
+}
+
+func BenchmarkTree_Reset(b *testing.B) {
+	leaves := make([][]byte, 64)
+	for i := range leaves {
+		leaves[i] = NewNodeFromUint64(uint64(i))
+	}
+	b.Run("NewTree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree, _ := NewTree()
+			_ = tree.AddLeaves(leaves)
+			_ = tree.Root()
+		}
+	})
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		tree, _ := NewTree()
+		for i := 0; i < b.N; i++ {
+			_ = tree.Reset()
+			_ = tree.AddLeaves(leaves)
+			_ = tree.Root()
+		}
+	})
+}
+
+func TestGetStdSha256Parent(t *testing.T) {
+	r := require.New(t)
+
+	simdTree, err := NewTree()
+	r.NoError(err)
+	stdTree, err := NewTreeBuilder().WithHashFunc(merkle.GetStdSha256Parent).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(simdTree.AddLeaf(NewNodeFromUint64(i)))
+		r.NoError(stdTree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	r.Equal(simdTree.Root(), stdTree.Root())
+	r.Equal("89a0f1577268cc19b0a39c7a69f804fd140640c699585eb635ebb03c06154cce", hex.EncodeToString(stdTree.Root()))
+}
+
+func TestGetBlake3Parent(t *testing.T) {
+	r := require.New(t)
+
+	tree, err := NewTreeBuilder().WithHashFunc(merkle.GetBlake3Parent).WithLeavesToProve(setOf(3)).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	expectedRoot, _ := hex.DecodeString("a2876a19d9c6928af86ee3b082eb18d839db64d94b34c2c15195a67abdf1b1d4")
+	r.Equal(expectedRoot, tree.Root())
+
+	// The official BLAKE3 test vector for a 64-byte input, whose i-th byte is i % 251.
+	input := make([]byte, 2*NodeSize)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+	parent := merkle.GetBlake3Parent(nil, input[:NodeSize], input[NodeSize:])
+	r.Equal("4eed7141ea4a5cd4b788606bd23f46e212af9cacebacdc7d1f4c6dc7f2511b98", hex.EncodeToString(parent))
+
+	valid, err := ValidatePartialTree([]uint64{3}, [][]byte{NewNodeFromUint64(3)}, tree.Proof(), tree.Root(),
+		merkle.GetBlake3Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestHashFuncFromStdHash(t *testing.T) {
+	r := require.New(t)
+
+	for name, hash := range map[string]merkle.HashFunc{
+		"single": merkle.HashFuncFromStdHash(sha256.New),
+		"pool":   merkle.HashFuncFromStdHashPool(sha256.New),
+	} {
+		lChild, rChild := NewNodeFromUint64(1), NewNodeFromUint64(2)
+		r.Equal(GetSha256Parent(nil, lChild, rChild), hash(nil, lChild, rChild), name)
+		// Consecutive calls must not leak state from the previous call.
+		r.Equal(GetSha256Parent(nil, rChild, lChild), hash(make([]byte, 0, NodeSize), rChild, lChild), name)
+
+		expectedTree, err := NewTree()
+		r.NoError(err)
+		tree, err := NewTreeBuilder().WithHashFunc(hash).Build()
+		r.NoError(err)
+		for i := uint64(0); i < 10; i++ {
+			r.NoError(expectedTree.AddLeaf(NewNodeFromUint64(i)))
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		r.Equal(expectedTree.Root(), tree.Root(), name)
+	}
+}
+
+func TestTreeBuilder_WithLeafHashFunc(t *testing.T) {
+	r := require.New(t)
+
+	// The leaves and root of the RFC 6962 test vectors from the Certificate Transparency reference implementation.
+	leaves := [][]byte{
+		{},
+		{0x00},
+		{0x10},
+		{0x20, 0x21},
+		{0x30, 0x31},
+		{0x40, 0x41, 0x42, 0x43},
+		{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57},
+		{0x60, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+	}
+	expectedRoot, _ := hex.DecodeString("5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328")
+
+	var observed [][]byte
+	tree, err := NewTreeBuilder().
+		WithHashFunc(merkle.GetRFC6962Parent).
+		WithLeafHashFunc(merkle.GetRFC6962LeafHash).
+		WithLeavesToProve(setOf(5)).
+		WithLeafObserver(func(index uint64, leaf []byte) { observed = append(observed, leaf) }).
+		Build()
+	r.NoError(err)
+	for _, leaf := range leaves {
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	root, proof := tree.RootAndProof()
+	r.Equal(expectedRoot, root)
+	r.Equal(leaves, observed)
+
+	valid, err := merkle.ValidatePartialTreeWithLeafHash([]uint64{5}, [][]byte{leaves[5]}, proof, expectedRoot,
+		merkle.GetRFC6962LeafHash, merkle.GetRFC6962Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	// Without the leaf hash, the raw leaf doesn't validate.
+	valid, err = ValidatePartialTree([]uint64{5}, [][]byte{leaves[5]}, proof, expectedRoot, merkle.GetRFC6962Parent)
+	r.NoError(err)
+	r.False(valid)
+}
+
+func TestTree_AddLeafHashed(t *testing.T) {
+	r := require.New(t)
+
+	expectedRoot, _ := hex.DecodeString("5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328")
+	var observed [][]byte
+	tree, err := NewTreeBuilder().
+		WithHashFunc(merkle.GetRFC6962Parent).
+		WithLeafHashFunc(merkle.GetRFC6962LeafHash).
+		WithLeavesToProve(setOf(2, 3)).
+		WithLeafObserver(func(index uint64, leaf []byte) { observed = append(observed, leaf) }).
+		Build()
+	r.NoError(err)
+	var leafNodes [][]byte
+	for i, leaf := range rfc6962Leaves {
+		leafNode := merkle.GetRFC6962LeafHash(nil, leaf)
+		leafNodes = append(leafNodes, leafNode)
+		if i%2 == 0 {
+			r.NoError(tree.AddLeaf(leaf))
+		} else {
+			r.NoError(tree.AddLeafHashed(leafNode))
+		}
+	}
+	root, proof := tree.RootAndProof()
+	r.Equal(expectedRoot, root)
+	for i := range observed {
+		if i%2 == 0 {
+			r.Equal(rfc6962Leaves[i], observed[i])
+		} else {
+			r.Equal(leafNodes[i], observed[i])
+		}
+	}
+
+	// The proof is for the leaf nodes, whichever way they were added.
+	valid, err := ValidatePartialTree([]uint64{2, 3}, leafNodes[2:4], proof, expectedRoot, merkle.GetRFC6962Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+// verifyOpenZeppelin is a port of OpenZeppelin's MerkleProof.verify, hashing with SHA-256 instead of keccak256.
+func verifyOpenZeppelin(proof [][]byte, root, leaf []byte) bool {
+	computedHash := leaf
+	for _, sibling := range proof {
+		if bytes.Compare(computedHash, sibling) < 0 {
+			computedHash = GetSha256Parent(nil, computedHash, sibling)
+		} else {
+			computedHash = GetSha256Parent(nil, sibling, computedHash)
+		}
+	}
+	return bytes.Equal(computedHash, root)
+}
+
+func TestTreeBuilder_WithSortedSiblings(t *testing.T) {
+	r := require.New(t)
+
+	for _, leafToProve := range []uint64{0, 3, 7} {
+		cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().
+			WithSortedSiblings().
+			WithLeavesToProve(setOf(leafToProve)).
+			WithCacheWriter(cacheWriter).
+			Build()
+		r.NoError(err)
+		for i := uint64(0); i < 8; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		root, proof := tree.RootAndProof()
+		leaf := NewNodeFromUint64(leafToProve)
+		r.True(verifyOpenZeppelin(proof, root, leaf))
+
+		valid, err := ValidatePartialTree([]uint64{leafToProve}, [][]byte{leaf}, proof, root, GetSha256Parent,
+			merkle.SortSiblings())
+		r.NoError(err)
+		r.True(valid)
+
+		// Proofs generated from the cache use the same sibling set.
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		_, _, cachedProof, err := GenerateProof(setOf(leafToProve), cacheReader)
+		r.NoError(err)
+		r.Equal(proof, cachedProof)
+	}
+
+	// Equal children hash the same in either order.
+	node := NewNodeFromUint64(1)
+	r.Equal(GetSha256Parent(nil, node, node), merkle.SortedPairHashFunc(GetSha256Parent)(nil, node, node))
+}
+
+func getTruncatedSha256Parent(buf, lChild, rChild []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, lChild...), rChild...))
+	return append(buf, sum[:20]...)
+}
+
+func TestTreeBuilder_WithNodeSize(t *testing.T) {
+	r := require.New(t)
+
+	const nodeSize = 20
+	var leaves [][]byte
+	for i := uint64(0); i < 10; i++ {
+		leaves = append(leaves, NewNodeFromUint64(i)[:nodeSize])
+	}
+
+	// Calculate the expected root, padding every layer with a zero node of the configured size.
+	layer := leaves
+	for len(layer) > 1 {
+		if len(layer)%2 == 1 {
+			layer = append(layer, make([]byte, nodeSize))
+		}
+		var parents [][]byte
+		for i := 0; i < len(layer); i += 2 {
+			parents = append(parents, getTruncatedSha256Parent(nil, layer[i], layer[i+1]))
+		}
+		layer = parents
+	}
+	expectedRoot := layer[0]
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactoryWithNodeSize(nodeSize))
+	tree, err := NewTreeBuilder().
+		WithHashFunc(getTruncatedSha256Parent).
+		WithNodeSize(nodeSize).
+		WithCacheWriter(cacheWriter).
+		Build()
+	r.NoError(err)
+	for _, leaf := range leaves {
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	r.Equal(expectedRoot, tree.Root())
+
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	baseLayer := cacheReader.GetLayerReader(0)
+	width, err := baseLayer.Width()
+	r.NoError(err)
+	r.Equal(uint64(len(leaves)), width)
+	r.NoError(baseLayer.Seek(0))
+	for _, leaf := range leaves {
+		node, err := baseLayer.ReadNext()
+		r.NoError(err)
+		r.Equal(leaf, node)
+	}
+
+	provenLeafIndices := setOf(3, 9)
+	sortedProvenLeafIndices, provenLeaves, proofNodes, err := GenerateProof(provenLeafIndices, cacheReader)
+	r.NoError(err)
+	r.Equal([][]byte{leaves[3], leaves[9]}, provenLeaves)
+	valid, err := ValidatePartialTree(sortedProvenLeafIndices, provenLeaves, proofNodes, expectedRoot,
+		getTruncatedSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func BenchmarkSha256Parent(b *testing.B) {
+	lChild, rChild := NewNodeFromUint64(0), NewNodeFromUint64(1)
+	buf := make([]byte, 0, NodeSize)
+	for name, hash := range map[string]merkle.HashFunc{
+		"simd":   GetSha256Parent,
+		"std":    merkle.GetStdSha256Parent,
+		"blake3": merkle.GetBlake3Parent,
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				buf = hash(buf[:0], lChild, rChild)
+			}
+		})
+	}
+}
+
 func BenchmarkNewTreeNoHashing(b *testing.B) {
 	var size uint64 = 1 << 28
 	tree, _ := NewTree()
@@ -247,6 +857,201 @@ func BenchmarkNewTreeNoHashing(b *testing.B) {
 	*/
 }
 
+func TestTree_LeafObserver(t *testing.T) {
+	r := require.New(t)
+
+	var indices []uint64
+	var leaves [][]byte
+	tree, err := NewTreeBuilder().WithLeafObserver(func(index uint64, leaf []byte) {
+		indices = append(indices, index)
+		leaves = append(leaves, append([]byte(nil), leaf...))
+	}).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+
+	r.Equal([]uint64{0, 1, 2, 3, 4, 5, 6, 7}, indices)
+	for i, leaf := range leaves {
+		r.Equal(NewNodeFromUint64(uint64(i)), leaf)
+	}
+}
+
+func TestTree_ProvePredicate(t *testing.T) {
+	r := require.New(t)
+
+	var leafIndices []uint64
+	var leaves [][]byte
+	tree, err := NewTreeBuilder().WithProvePredicate(func(index uint64, leaf []byte) bool {
+		if leaf[0]%2 != 0 {
+			return false
+		}
+		leafIndices = append(leafIndices, index)
+		leaves = append(leaves, append([]byte(nil), leaf...))
+		return true
+	}).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 10; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i * 3)))
+	}
+	r.Equal([]uint64{0, 2, 4, 6, 8}, leafIndices)
+
+	root, proof := tree.RootAndProof()
+	valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid, "Proof should be valid, but isn't")
+}
+
+func TestTree_NoPadding(t *testing.T) {
+	r := require.New(t)
+
+	balanced, err := NewTree()
+	r.NoError(err)
+	tree, err := NewTreeBuilder().WithNoPadding().WithLeavesToProve(setOf(3)).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(balanced.AddLeaf(NewNodeFromUint64(i)))
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, proof, err := tree.CheckedRootAndProof()
+	r.NoError(err)
+	r.Equal(balanced.Root(), root)
+	r.Len(proof, 3)
+
+	r.NoError(tree.AddLeaf(NewNodeFromUint64(8)))
+	root, err = tree.CheckedRoot()
+	var unbalancedErr *merkle.UnbalancedTreeError
+	r.ErrorAs(err, &unbalancedErr)
+	r.Equal(uint64(9), unbalancedErr.LeafCount)
+	r.EqualError(err, "tree with 9 leaves and min height 0 is unbalanced and padding is disabled")
+	r.Nil(root)
+	r.Nil(tree.Root())
+
+	tree, err = NewTreeBuilder().WithNoPadding().WithMinHeight(4).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	_, _, err = tree.CheckedRootAndProof()
+	r.ErrorAs(err, &unbalancedErr)
+	r.Equal(uint(4), unbalancedErr.MinHeight)
+}
+
+func TestTree_AddSubtree(t *testing.T) {
+	r := require.New(t)
+
+	subtreeRoot := func(first uint64) []byte {
+		subtree, err := NewTree()
+		r.NoError(err)
+		for i := first; i < first+4; i++ {
+			r.NoError(subtree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		return subtree.Root()
+	}
+
+	tree, err := NewTree()
+	r.NoError(err)
+	r.NoError(tree.AddSubtree(subtreeRoot(0), 2))
+	r.NoError(tree.AddSubtree(subtreeRoot(4), 2))
+	expectedRoot, _ := NewNodeFromHex("89a0f1577268cc19b0a39c7a69f804fd140640c699585eb635ebb03c06154cce")
+	r.Equal(expectedRoot, tree.Root())
+
+	// Subtrees can be mixed with regular leaves, as long as they're aligned.
+	tree, err = NewTree()
+	r.NoError(err)
+	for i := uint64(0); i < 4; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	r.NoError(tree.AddSubtree(subtreeRoot(4), 2))
+	r.Equal(expectedRoot, tree.Root())
+
+	r.NoError(tree.AddLeaf(NewNodeFromUint64(8)))
+	r.EqualError(tree.AddSubtree(subtreeRoot(0), 2), "cannot add subtree of height 2 after 9 leaves")
+
+	tree, err = NewProvingTree(setOf(5))
+	r.NoError(err)
+	r.NoError(tree.AddSubtree(subtreeRoot(0), 2))
+	r.EqualError(tree.AddSubtree(subtreeRoot(4), 2), "cannot add subtree of height 2: it contains leaves to prove")
+
+	// Heights that can't be counted in leaves are rejected instead of overflowing.
+	tree, err = NewTree()
+	r.NoError(err)
+	r.EqualError(tree.AddSubtree(subtreeRoot(0), 64), "cannot add subtree of height 64: its leaves can't be counted")
+	r.EqualError(tree.AddSubtree(subtreeRoot(0), 100), "cannot add subtree of height 100: its leaves can't be counted")
+	r.NoError(tree.AddSubtree(subtreeRoot(0), 63))
+	r.EqualError(tree.AddSubtree(subtreeRoot(0), 63),
+		"cannot add subtree of height 63 after 9223372036854775808 leaves: too many leaves")
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err = NewCachingTree(cacheWriter)
+	r.NoError(err)
+	r.EqualError(tree.AddSubtree(subtreeRoot(0), 2), "cannot add subtree of height 2: layer 0 is cached")
+}
+
+func TestTree_Grow(t *testing.T) {
+	r := require.New(t)
+	for _, numLeaves := range []uint64{0, 1, 5, 8, 10} {
+		lazy, err := NewProvingTree(setOf(1))
+		r.NoError(err)
+		grown, err := NewProvingTree(setOf(1))
+		r.NoError(err)
+		r.NoError(grown.Grow(5))
+		for i := uint64(0); i < numLeaves; i++ {
+			r.NoError(lazy.AddLeaf(NewNodeFromUint64(i)))
+			r.NoError(grown.AddLeaf(NewNodeFromUint64(i)))
+		}
+		expectedRoot, expectedProof := lazy.RootAndProof()
+		root, proof := grown.RootAndProof()
+		r.Equal(expectedRoot, root, "leaves: %d", numLeaves)
+		r.Equal(expectedProof, proof, "leaves: %d", numLeaves)
+		r.Equal(lazy.GetParkedNodes(nil), grown.GetParkedNodes(nil), "leaves: %d", numLeaves)
+	}
+}
+
+func BenchmarkTreeGrow(b *testing.B) {
+	const size = 1 << 20
+	b.Run("Lazy", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			tree, _ := NewTree()
+			for i := uint64(0); i < size; i++ {
+				_ = tree.AddLeaf(NewNodeFromUint64(i))
+			}
+		}
+	})
+	b.Run("PreGrown", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			tree, _ := NewTree()
+			_ = tree.Grow(20)
+			for i := uint64(0); i < size; i++ {
+				_ = tree.AddLeaf(NewNodeFromUint64(i))
+			}
+		}
+	})
+}
+
+func BenchmarkTreeRootBalanced(b *testing.B) {
+	hashes := 0
+	countingHash := func(buf, lChild, rChild []byte) []byte {
+		hashes++
+		return GetSha256Parent(buf, lChild, rChild)
+	}
+	tree, _ := NewTreeBuilder().WithHashFunc(countingHash).Build()
+	for i := uint64(0); i < 1<<16; i++ {
+		_ = tree.AddLeaf(NewNodeFromUint64(i))
+	}
+
+	hashes = 0
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = tree.Root()
+	}
+	b.StopTimer()
+	if hashes != 0 {
+		b.Fatalf("expected no hashes while getting the root of a balanced tree, got %d", hashes)
+	}
+	b.ReportMetric(float64(hashes)/float64(b.N), "hashes/op")
+}
+
 /*
 	28 layer tree takes 125 seconds to construct. Overhead (no hashing) is 15.5 seconds. Net: 109.5 seconds.
 	(8.5GB @ 32b leaves) => x30 256GB => 55 minutes for hashing, 8 minutes overhead.
@@ -373,6 +1178,57 @@ func TestNewProvingTreeMultiProof2(t *testing.T) {
 	***************************************************/
 }
 
+func TestStreamProve(t *testing.T) {
+	r := require.New(t)
+	leavesToProve := setOf(0, 4, 7)
+
+	leaves := make(chan []byte)
+	go func() {
+		for i := uint64(0); i < 8; i++ {
+			leaves <- NewNodeFromUint64(i)
+		}
+		close(leaves)
+	}()
+	root, provenLeaves, proof, err := merkle.StreamProve(leaves, leavesToProve, GetSha256Parent)
+	r.NoError(err)
+
+	tree, err := NewProvingTree(leavesToProve)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	expectedRoot, expectedProof := tree.RootAndProof()
+	r.Equal(expectedRoot, root)
+	r.Equal(expectedProof, proof)
+	r.Equal([][]byte{NewNodeFromUint64(0), NewNodeFromUint64(4), NewNodeFromUint64(7)}, provenLeaves)
+}
+
+func TestStreamProveDrainsOnError(t *testing.T) {
+	r := require.New(t)
+
+	leaves := make(chan []byte)
+	produced := make(chan struct{})
+	go func() {
+		defer close(produced)
+		for i := uint64(0); i < 8; i++ {
+			leaf := NewNodeFromUint64(i)
+			if i == 3 {
+				leaf = append(leaf, 0)
+			}
+			leaves <- leaf
+		}
+		close(leaves)
+	}()
+	_, _, _, err := merkle.StreamProve(leaves, setOf(0), GetSha256Parent)
+	r.EqualError(err, "leaf 3 has size 33 instead of 32")
+
+	select {
+	case <-produced:
+	case <-time.After(5 * time.Second):
+		r.Fail("the producer is blocked")
+	}
+}
+
 func NewNodeFromUint64(i uint64) []byte {
 	b := make([]byte, NodeSize)
 	binary.LittleEndian.PutUint64(b, i)
@@ -412,6 +1268,52 @@ func TestNewCachingTree(t *testing.T) {
 	// cacheWriter.Print(0 , 3)
 }
 
+func TestNewCachingTreeHashMismatch(t *testing.T) {
+	r := require.New(t)
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	cacheWriter.SetHash(concatLeaves)
+	cacheWriter.SetHashName("concat")
+
+	_, err := NewCachingTree(cacheWriter)
+	r.ErrorIs(err, merkle.ErrCacheHashMismatch)
+	r.ErrorContains(err, `cache uses "concat", tree uses "sha256"`)
+
+	// Closures made from the same function literal are told apart by their names.
+	_, err = NewTreeBuilder().WithHashFunc(concatLeaves).WithCacheWriter(cacheWriter).Build()
+	r.ErrorIs(err, merkle.ErrCacheHashMismatch)
+
+	_, err = NewTreeBuilder().WithHashFunc(concatLeaves).WithHashName("concat").WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+
+	// A wrapper of the default hash function can claim its name.
+	cacheWriter = cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	_, err = NewCachingTree(cacheWriter)
+	r.NoError(err)
+	r.Equal(merkle.Sha256HashName, cacheWriter.HashName())
+	wrapper := func(buf, lChild, rChild []byte) []byte { return GetSha256Parent(buf, lChild, rChild) }
+	_, err = NewTreeBuilder().WithHashFunc(wrapper).WithHashName(merkle.Sha256HashName).WithCacheWriter(cacheWriter).
+		Build()
+	r.NoError(err)
+
+	// Without names, a hash function set on the writer is compared with the tree's by its output.
+	cacheWriter = cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	cacheWriter.SetHash(concatLeaves)
+	_, err = NewCachingTree(cacheWriter)
+	r.ErrorIs(err, merkle.ErrCacheHashMismatch)
+	r.ErrorContains(err, "cache uses an unnamed hash function")
+	_, err = NewTreeBuilder().WithHashFunc(GetSha256Parent).WithCacheWriter(cacheWriter).Build()
+	r.ErrorIs(err, merkle.ErrCacheHashMismatch)
+	_, err = NewTreeBuilder().WithHashFunc(concatLeaves).WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+	cacheWriter = cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	cacheWriter.SetHash(wrapper)
+	_, err = NewCachingTree(cacheWriter)
+	r.NoError(err)
+
+	_, err = NewTreeBuilder().WithHashName("unregistered").Build()
+	r.EqualError(err, `hash function "unregistered" isn't registered`)
+}
+
 func BenchmarkNewCachingTreeSmall(b *testing.B) {
 	var size uint64 = 1 << 23
 	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(7), cache.MakeSliceReadWriterFactory())
@@ -512,6 +1414,94 @@ func TestTree_SetParkedNodes(t *testing.T) {
 	r.EqualValues(parkedNodes, tree.GetParkedNodes(nil))
 }
 
+func TestTree_SaveState(t *testing.T) {
+	r := require.New(t)
+
+	const width = 25
+	leavesToProve := setOf(3, 12, 20)
+	newTree := func() merkle.TreeBuilder {
+		return NewTreeBuilder().WithHashFunc(merkle.GetStdSha256Parent)
+	}
+	monolithic, err := newTree().WithLeavesToProve(leavesToProve).WithMinHeight(6).Build()
+	r.NoError(err)
+	for i := uint64(0); i < width; i++ {
+		r.NoError(monolithic.AddLeaf(NewNodeFromUint64(i)))
+	}
+	expectedRoot, expectedProof := monolithic.RootAndProof()
+
+	for _, split := range []uint64{0, 1, 12, 13, 16, width} {
+		tree, err := newTree().WithLeavesToProve(leavesToProve).WithMinHeight(6).Build()
+		r.NoError(err)
+		for i := uint64(0); i < split; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		state, err := tree.SaveState()
+		r.NoError(err)
+
+		resumed, err := newTree().WithSavedState(state).Build()
+		r.NoError(err)
+		r.Equal(split, resumed.LeafCount())
+		for i := split; i < width; i++ {
+			r.NoError(resumed.AddLeaf(NewNodeFromUint64(i)))
+		}
+		root, proof := resumed.RootAndProof()
+		r.Equal(expectedRoot, root, "split at %d", split)
+		r.Equal(expectedProof, proof, "split at %d", split)
+	}
+
+	tree, err := newTree().WithLeavesToProve(leavesToProve).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 5; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	state, err := tree.SaveState()
+	r.NoError(err)
+	_, err = newTree().WithSavedState(state[:len(state)-1]).Build()
+	r.EqualError(err, "while restoring saved state: truncated tree state")
+	_, err = newTree().WithSavedState(append([]byte{2}, state[1:]...)).Build()
+	r.EqualError(err, "while restoring saved state: unsupported tree state format version 2")
+	_, err = newTree().WithSavedState(append(state, 0)).Build()
+	r.EqualError(err, "while restoring saved state: 1 unexpected trailing bytes after tree state")
+	_, err = newTree().WithSavedState(state).WithLeavesToProve(setOf(7)).Build()
+	r.EqualError(err, "a saved state can't be combined with WithResumeState or WithLeavesToProve")
+}
+
+func TestTreeBuilder_WithResumeState(t *testing.T) {
+	r := require.New(t)
+
+	tree, err := NewTree()
+	r.NoError(err)
+	for i := uint64(0); i < 13; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	parked := tree.GetParkedNodes(nil)
+
+	resumed, err := NewTreeBuilder().WithResumeState(parked, 13).WithLeavesToProve(setOf(14)).Build()
+	r.NoError(err)
+	scratch, err := NewProvingTree(setOf(14))
+	r.NoError(err)
+	for i := uint64(0); i < 16; i++ {
+		if i >= 13 {
+			r.NoError(resumed.AddLeaf(NewNodeFromUint64(i)))
+		}
+		r.NoError(scratch.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, proof := resumed.RootAndProof()
+	expectedRoot, expectedProof := scratch.RootAndProof()
+	r.Equal(expectedRoot, root)
+	r.Equal(expectedProof, proof)
+	valid, err := ValidatePartialTree([]uint64{14}, [][]byte{NewNodeFromUint64(14)}, proof, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	_, err = NewTreeBuilder().WithResumeState(parked, 12).Build()
+	r.EqualError(err, "parked nodes don't match 12 leaves at layer 0")
+	_, err = NewTreeBuilder().WithResumeState(parked[:2], 13).Build()
+	r.EqualError(err, "2 parked nodes are too few for 13 leaves")
+	_, err = NewTreeBuilder().WithResumeState(parked, 13).WithLeavesToProve(setOf(3)).Build()
+	r.EqualError(err, "cannot prove leaves before the resumed leaf count 13")
+}
+
 func decode(r *require.Assertions, hexString string) []byte {
 	hash, err := hex.DecodeString(hexString)
 	r.NoError(err)
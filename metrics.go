@@ -0,0 +1,45 @@
+package merkle
+
+// Metrics receives callbacks describing the work done while building trees and generating proofs. Implementations must
+// be safe for concurrent use if they're shared between trees or caches used concurrently.
+type Metrics interface {
+	// OnCacheHit is called when GetNode finds a node in a cached layer of the given height.
+	OnCacheHit(height uint)
+	// OnNodeRead is called for every node read from a cached layer, including leaves read to recalculate nodes.
+	OnNodeRead(pos Position)
+	// OnHash is called for every parent node calculated by a tree.
+	OnHash()
+}
+
+// NoopMetrics is a Metrics that ignores all callbacks. It's used when no Metrics are set.
+type NoopMetrics struct{}
+
+// A compile time check to ensure that NoopMetrics fully implements Metrics.
+var _ Metrics = NoopMetrics{}
+
+func (NoopMetrics) OnCacheHit(uint)     {}
+func (NoopMetrics) OnNodeRead(Position) {}
+func (NoopMetrics) OnHash()             {}
+
+// metricsCacheReader is a CacheReader that reports the work done by GetNode and GenerateProof to metrics.
+type metricsCacheReader struct {
+	CacheReader
+	metrics Metrics
+}
+
+// CacheReaderWithMetrics returns a CacheReader that reads from c and reports cache hits, node reads and hash calls made
+// by GetNode and GenerateProof (and their variants) to metrics.
+func CacheReaderWithMetrics(c CacheReader, metrics Metrics) CacheReader {
+	if inner, ok := c.(*metricsCacheReader); ok {
+		c = inner.CacheReader
+	}
+	return &metricsCacheReader{CacheReader: c, metrics: metrics}
+}
+
+// cacheMetrics returns the Metrics attached to c with CacheReaderWithMetrics, or NoopMetrics.
+func cacheMetrics(c CacheReader) Metrics {
+	if m, ok := c.(*metricsCacheReader); ok && m.metrics != nil {
+		return m.metrics
+	}
+	return NoopMetrics{}
+}
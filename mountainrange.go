@@ -0,0 +1,57 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// BagPeaks combines the peaks of a Merkle mountain range (a forest of perfect subtrees of decreasing height) into a
+// single root. Peaks are bagged from right to left: the last two peaks are hashed together, then the result is hashed
+// with the preceding peak, and so on. A single peak is its own root.
+func BagPeaks(peaks [][]byte, hash HashFunc) []byte {
+	if len(peaks) == 0 {
+		return nil
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		root = hash(nil, peaks[i], root)
+	}
+	return root
+}
+
+// ValidateMountainRange validates that leaf is at leafIndex in a Merkle mountain range with the given peaks and that
+// the bagged peaks match expectedRoot. peakHeights holds the height of every peak, which must be strictly decreasing,
+// and proof is a regular proof for the leaf within its peak.
+func ValidateMountainRange(peaks [][]byte, peakHeights []uint, leafIndex uint64, leaf []byte, proof [][]byte,
+	expectedRoot []byte, hash HashFunc,
+) (bool, error) {
+	if len(peaks) != len(peakHeights) {
+		return false, fmt.Errorf("number of peaks (%d) must equal number of peak heights (%d)", len(peaks),
+			len(peakHeights))
+	}
+	var firstLeaf uint64
+	peak := -1
+	for i, height := range peakHeights {
+		if i > 0 && height >= peakHeights[i-1] {
+			return false, fmt.Errorf("peak heights must be strictly decreasing, got %v", peakHeights)
+		}
+		if peak == -1 && leafIndex < firstLeaf+1<<height {
+			peak = i
+		}
+		if peak == -1 {
+			firstLeaf += 1 << height
+		}
+	}
+	if peak == -1 {
+		return false, fmt.Errorf("leaf index %d is out of range for mountain range of %d leaves", leafIndex, firstLeaf)
+	}
+	if uint(len(proof)) != peakHeights[peak] {
+		return false, fmt.Errorf("proof length (%d) must equal the height of the peak (%d)", len(proof),
+			peakHeights[peak])
+	}
+	valid, err := ValidatePartialTree([]uint64{leafIndex - firstLeaf}, [][]byte{leaf}, proof, peaks[peak], hash)
+	if err != nil || !valid {
+		return false, err
+	}
+	return bytes.Equal(BagPeaks(peaks, hash), expectedRoot), nil
+}
@@ -0,0 +1,59 @@
+package merkle_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+)
+
+func TestValidateMountainRange(t *testing.T) {
+	r := require.New(t)
+
+	// A 10-leaf mountain range has a peak over leaves 0-7 and a peak over leaves 8-9.
+	buildPeak := func(first, width uint64, provenLeaf uint64) ([]byte, [][]byte) {
+		tree, err := NewProvingTree(setOf(provenLeaf - first))
+		r.NoError(err)
+		for i := first; i < first+width; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		return tree.RootAndProof()
+	}
+	peak0, proof3 := buildPeak(0, 8, 3)
+	peak1, proof9 := buildPeak(8, 2, 9)
+	peaks := [][]byte{peak0, peak1}
+	peakHeights := []uint{3, 1}
+
+	root := merkle.BagPeaks(peaks, GetSha256Parent)
+	r.Equal(GetSha256Parent(nil, peak0, peak1), root)
+	r.Equal(peak0, merkle.BagPeaks(peaks[:1], GetSha256Parent))
+
+	valid, err := merkle.ValidateMountainRange(peaks, peakHeights, 9, NewNodeFromUint64(9), proof9, root,
+		GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	valid, err = merkle.ValidateMountainRange(peaks, peakHeights, 3, NewNodeFromUint64(3), proof3, root,
+		GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	valid, err = merkle.ValidateMountainRange(peaks, peakHeights, 9, NewNodeFromUint64(8), proof9, root,
+		GetSha256Parent)
+	r.NoError(err)
+	r.False(valid)
+
+	valid, err = merkle.ValidateMountainRange([][]byte{peak0, peak0}, peakHeights, 3, NewNodeFromUint64(3), proof3,
+		root, GetSha256Parent)
+	r.NoError(err)
+	r.False(valid)
+
+	_, err = merkle.ValidateMountainRange(peaks, peakHeights, 10, NewNodeFromUint64(10), proof9, root,
+		GetSha256Parent)
+	r.EqualError(err, "leaf index 10 is out of range for mountain range of 10 leaves")
+
+	_, err = merkle.ValidateMountainRange(peaks, []uint{1, 3}, 9, NewNodeFromUint64(9), proof9, root,
+		GetSha256Parent)
+	r.EqualError(err, "peak heights must be strictly decreasing, got [1 3]")
+}
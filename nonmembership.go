@@ -0,0 +1,96 @@
+package merkle
+
+import "fmt"
+
+// NonMembershipProof proves that a key isn't a leaf of a tree whose leaves are sorted, by proving the leaves that
+// bracket it. Between the first and last leaves, these are two adjacent leaves, one lower and one higher than the key.
+// When the key is lower than all leaves, only the first leaf is proven, and when it's higher than all leaves, only the
+// last leaf is proven. Width is the number of leaves in the tree, so the verifier can tell that a leaf is the last one.
+type NonMembershipProof struct {
+	Width   uint64
+	Indices []uint64
+	Leaves  [][]byte
+	Nodes   [][]byte
+}
+
+// GenerateNonMembershipProof generates a proof that key isn't a leaf of the tree in sortedLeaves, whose leaves must be
+// sorted in ascending order according to cmp. cmp returns a negative number when a < b, zero when a == b and a positive
+// number when a > b, like bytes.Compare. The leaves are binary searched, so O(log(n)) leaves are read. An error is
+// returned if key is a leaf of the tree.
+func GenerateNonMembershipProof(key []byte, sortedLeaves CacheReader, cmp func(a, b []byte) int) (
+	NonMembershipProof, error,
+) {
+	width, err := sortedLeaves.GetLayerReader(0).Width()
+	if err != nil {
+		return NonMembershipProof{}, fmt.Errorf("while getting base layer width: %w", err)
+	}
+	if width == 0 {
+		return NonMembershipProof{}, fmt.Errorf("can't prove non-membership in an empty tree")
+	}
+	// Find the first leaf that isn't lower than the key. Like sort.Search, but over uint64 indices, which may not fit
+	// in an int.
+	next, high := uint64(0), width
+	for next < high {
+		mid := next + (high-next)/2
+		leaf, err := GetNode(sortedLeaves, Position{Index: mid})
+		if err != nil {
+			return NonMembershipProof{}, fmt.Errorf("while reading leaf %d: %w", mid, err)
+		}
+		if cmp(leaf, key) >= 0 {
+			high = mid
+		} else {
+			next = mid + 1
+		}
+	}
+	leavesToProve := make(Set)
+	if next > 0 {
+		leavesToProve[next-1] = true
+	}
+	if next < width {
+		leaf, err := GetNode(sortedLeaves, Position{Index: next})
+		if err != nil {
+			return NonMembershipProof{}, fmt.Errorf("while reading leaf %d: %w", next, err)
+		}
+		if cmp(leaf, key) == 0 {
+			return NonMembershipProof{}, fmt.Errorf("key is the leaf at index %d", next)
+		}
+		leavesToProve[next] = true
+	}
+	indices, leaves, nodes, err := GenerateProof(leavesToProve, sortedLeaves)
+	if err != nil {
+		return NonMembershipProof{}, err
+	}
+	return NonMembershipProof{Width: width, Indices: indices, Leaves: leaves, Nodes: nodes}, nil
+}
+
+// VerifyNonMembership verifies a proof generated by GenerateNonMembershipProof that key isn't a leaf of the tree with
+// the given root, whose leaves are sorted according to cmp. It checks that the proven leaves bracket the key, that they
+// are adjacent (or the first or last leaf of the tree) and that they're in the tree, using
+// ValidatePartialTreeWithWidth.
+func VerifyNonMembership(key []byte, proof NonMembershipProof, root []byte, hash HashFunc,
+	cmp func(a, b []byte) int,
+) (bool, error) {
+	if len(proof.Indices) != len(proof.Leaves) {
+		return false, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(proof.Leaves),
+			len(proof.Indices))
+	}
+	switch len(proof.Indices) {
+	case 1:
+		index, leaf := proof.Indices[0], proof.Leaves[0]
+		beforeFirst := index == 0 && cmp(key, leaf) < 0
+		afterLast := index == proof.Width-1 && cmp(leaf, key) < 0
+		if !beforeFirst && !afterLast {
+			return false, nil
+		}
+	case 2:
+		if proof.Indices[1] != proof.Indices[0]+1 {
+			return false, fmt.Errorf("proven leaves %d and %d aren't adjacent", proof.Indices[0], proof.Indices[1])
+		}
+		if cmp(proof.Leaves[0], key) >= 0 || cmp(key, proof.Leaves[1]) >= 0 {
+			return false, nil
+		}
+	default:
+		return false, fmt.Errorf("non-membership proof must prove 1 or 2 leaves, got %d", len(proof.Indices))
+	}
+	return ValidatePartialTreeWithWidth(proof.Width, proof.Indices, proof.Leaves, proof.Nodes, root, hash)
+}
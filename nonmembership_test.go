@@ -0,0 +1,83 @@
+package merkle_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+// sortedKey returns a node that sorts by i with bytes.Compare.
+func sortedKey(i uint64) []byte {
+	b := make([]byte, NodeSize)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+func TestGenerateNonMembershipProof(t *testing.T) {
+	r := require.New(t)
+
+	// The keys are 10, 20, ..., 100.
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+	for i := uint64(1); i <= 10; i++ {
+		r.NoError(tree.AddLeaf(sortedKey(i * 10)))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	for _, tc := range []struct {
+		name    string
+		key     uint64
+		indices []uint64
+	}{
+		{"between consecutive keys", 35, []uint64{2, 3}},
+		{"before first", 5, []uint64{0}},
+		{"after last", 105, []uint64{9}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			key := sortedKey(tc.key)
+			proof, err := merkle.GenerateNonMembershipProof(key, cacheReader, bytes.Compare)
+			r.NoError(err)
+			r.Equal(uint64(10), proof.Width)
+			r.Equal(tc.indices, proof.Indices)
+			for i, index := range proof.Indices {
+				r.Equal(sortedKey((index+1)*10), proof.Leaves[i])
+			}
+
+			valid, err := merkle.VerifyNonMembership(key, proof, root, GetSha256Parent, bytes.Compare)
+			r.NoError(err)
+			r.True(valid)
+
+			// The proof doesn't bracket other keys.
+			valid, err = merkle.VerifyNonMembership(sortedKey(50), proof, root, GetSha256Parent, bytes.Compare)
+			r.NoError(err)
+			r.False(valid)
+		})
+	}
+
+	_, err = merkle.GenerateNonMembershipProof(sortedKey(40), cacheReader, bytes.Compare)
+	r.EqualError(err, "key is the leaf at index 3")
+
+	// Leaves that bracket the key but aren't adjacent are rejected.
+	_, leaves, nodes, err := GenerateProof(setOf(2, 4), cacheReader)
+	r.NoError(err)
+	proof := merkle.NonMembershipProof{Width: 10, Indices: []uint64{2, 4}, Leaves: leaves, Nodes: nodes}
+	_, err = merkle.VerifyNonMembership(sortedKey(35), proof, root, GetSha256Parent, bytes.Compare)
+	r.EqualError(err, "proven leaves 2 and 4 aren't adjacent")
+
+	// The last leaf of a prefix of the tree doesn't prove that a key is after the last leaf.
+	_, leaves, nodes, err = GenerateProof(setOf(7), cacheReader)
+	r.NoError(err)
+	proof = merkle.NonMembershipProof{Width: 8, Indices: []uint64{7}, Leaves: leaves, Nodes: nodes}
+	valid, err := merkle.VerifyNonMembership(sortedKey(85), proof, root, GetSha256Parent, bytes.Compare)
+	r.Error(err)
+	r.False(valid)
+}
@@ -8,7 +8,26 @@ type Position struct {
 }
 
 func (p Position) String() string {
-	return fmt.Sprintf("<h: %d i: %b>", p.Height, p.Index)
+	return fmt.Sprintf("<h: %d i: %d>", p.Height, p.Index)
+}
+
+// DepthFromRoot returns the distance of the position from the root of a tree with the given root height. It returns
+// false if the position is above the root, and so isn't in the tree.
+func (p Position) DepthFromRoot(rootHeight uint) (depth uint, ok bool) {
+	if p.Height > rootHeight {
+		return 0, false
+	}
+	return rootHeight - p.Height, true
+}
+
+// StringWithRoot formats the position using its depth from the root of a tree with the given root height, which is
+// often more intuitive when following proof traversal. Positions above the root are formatted with their height.
+func (p Position) StringWithRoot(rootHeight uint) string {
+	depth, ok := p.DepthFromRoot(rootHeight)
+	if !ok {
+		return fmt.Sprintf("<h: %d above root idx %d>", p.Height, p.Index)
+	}
+	return fmt.Sprintf("<depth %d idx %d>", depth, p.Index)
 }
 
 func (p Position) sibling() Position {
@@ -21,3 +21,33 @@ func TestPosition_isAncestorOf(t *testing.T) {
 
 	require.False(t, isAncestor)
 }
+
+func TestPosition_DepthFromRoot(t *testing.T) {
+	r := require.New(t)
+	const rootHeight = 3 // 8-leaf tree.
+
+	tests := []struct {
+		pos         Position
+		depth       uint
+		str         string
+		strWithRoot string
+	}{
+		{Position{Index: 0, Height: 3}, 0, "<h: 3 i: 0>", "<depth 0 idx 0>"},
+		{Position{Index: 1, Height: 2}, 1, "<h: 2 i: 1>", "<depth 1 idx 1>"},
+		{Position{Index: 3, Height: 1}, 2, "<h: 1 i: 3>", "<depth 2 idx 3>"},
+		{Position{Index: 5, Height: 0}, 3, "<h: 0 i: 5>", "<depth 3 idx 5>"},
+	}
+	for _, tt := range tests {
+		depth, ok := tt.pos.DepthFromRoot(rootHeight)
+		r.True(ok)
+		r.Equal(tt.depth, depth)
+		r.Equal(tt.str, tt.pos.String())
+		r.Equal(tt.strWithRoot, tt.pos.StringWithRoot(rootHeight))
+	}
+
+	// Positions above the root aren't in the tree.
+	above := Position{Index: 0, Height: 4}
+	_, ok := above.DepthFromRoot(rootHeight)
+	r.False(ok)
+	r.Equal("<h: 4 above root idx 0>", above.StringWithRoot(rootHeight))
+}
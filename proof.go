@@ -0,0 +1,262 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Proof is a self-describing partial tree. In addition to the proven leaves and proof nodes it carries the name of the
+// hash function (as registered with RegisterHashFunc) and the node size, so a verifier doesn't need to know them in
+// advance.
+type Proof struct {
+	HashName string
+	NodeSize int
+	Indices  []uint64
+	Leaves   [][]byte
+	Nodes    [][]byte
+}
+
+// Verify validates the proof against root, using the hash function registered under p.HashName.
+func (p Proof) Verify(root []byte) (bool, error) {
+	hash, found := HashFuncByName(p.HashName)
+	if !found {
+		return false, fmt.Errorf("unknown hash function %q", p.HashName)
+	}
+	if err := p.checkNodeSizes(); err != nil {
+		return false, err
+	}
+	return ValidatePartialTree(p.Indices, p.Leaves, p.Nodes, root, hash)
+}
+
+func (p Proof) checkNodeSizes() error {
+	if p.NodeSize <= 0 {
+		return fmt.Errorf("invalid node size %d", p.NodeSize)
+	}
+	for i, leaf := range p.Leaves {
+		if len(leaf) != p.NodeSize {
+			return fmt.Errorf("leaf %d has size %d instead of %d", i, len(leaf), p.NodeSize)
+		}
+	}
+	for i, n := range p.Nodes {
+		if len(n) != p.NodeSize {
+			return fmt.Errorf("proof node %d has size %d instead of %d", i, len(n), p.NodeSize)
+		}
+	}
+	return nil
+}
+
+// VerifyResult describes the outcome of VerifyEncoded.
+type VerifyResult struct {
+	Valid              bool                // Whether the computed root equals the expected root.
+	ComputedRoot       []byte              // The root calculated from the proof.
+	RootHeight         uint                // The height of the computed root.
+	ProofNodesConsumed int                 // The number of proof nodes used to calculate the root.
+	ProofNodesLeftOver int                 // The number of proof nodes that weren't used.
+	Nodes              map[Position][]byte // Every node used or calculated on the way to the root, by position.
+}
+
+// FirstDivergence returns the lowest layer at which a node in r.Nodes differs from the node at the same position in
+// expected, e.g. nodes read from a trusted cache with GetNode. Positions missing from expected are skipped. It returns
+// false if no node differs.
+func (r VerifyResult) FirstDivergence(expected map[Position][]byte) (layer uint, diverges bool) {
+	for pos, n := range r.Nodes {
+		expectedNode, found := expected[pos]
+		if !found || bytes.Equal(n, expectedNode) {
+			continue
+		}
+		if !diverges || pos.Height < layer {
+			layer, diverges = pos.Height, true
+		}
+	}
+	return layer, diverges
+}
+
+// VerifyEncoded decodes a proof encoded with EncodeProof and validates it against expectedRoot, using hashByName to
+// find the hash function named in the proof. If hashByName is nil, HashFuncByName is used. In addition to validity,
+// the result describes the computed root, how much of the proof was used and every node on the way to the root, to
+// help diagnose invalid proofs. The expected root alone doesn't tell which node of an invalid proof is wrong, so use
+// FirstDivergence with the nodes of a trusted tree to find the lowest layer at which the proof departs from it. An
+// error is returned if the proof can't be decoded or validated at all. If calculating the root fails, the result
+// still describes the proof nodes and nodes used until then.
+func VerifyEncoded(proofBytes, expectedRoot []byte, hashByName func(string) (HashFunc, bool)) (VerifyResult, error) {
+	p, err := DecodeProof(proofBytes)
+	if err != nil {
+		return VerifyResult{}, fmt.Errorf("while decoding proof: %w", err)
+	}
+	if hashByName == nil {
+		hashByName = HashFuncByName
+	}
+	hash, found := hashByName(p.HashName)
+	if !found {
+		return VerifyResult{}, fmt.Errorf("unknown hash function %q", p.HashName)
+	}
+	v, err := newValidator(p.Indices, p.Leaves, p.Nodes, hash, false)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	v.knownNodes = make(map[Position][]byte)
+	root, _, err := v.CalcRoot(MaxUint)
+	leftOver := len(v.ProofNodes.nodes)
+	result := VerifyResult{
+		ProofNodesConsumed: len(p.Nodes) - leftOver,
+		ProofNodesLeftOver: leftOver,
+		Nodes:              v.knownNodes,
+	}
+	if err != nil {
+		return result, fmt.Errorf("while calculating root: %w", err)
+	}
+	result.ComputedRoot = root
+	result.RootHeight = v.knownRootHeight()
+	result.Nodes[Position{Height: result.RootHeight}] = root
+	result.Valid = bytes.Equal(root, expectedRoot)
+	return result, nil
+}
+
+var errTruncatedProof = errors.New("truncated proof")
+
+// EncodeProof serializes p. The encoding is the hash name (uvarint length followed by the name), the node size
+// (uvarint), the indices (uvarint count followed by uvarint indices), and the leaves and nodes (each a uvarint count
+// followed by NodeSize bytes per entry).
+func EncodeProof(p Proof) ([]byte, error) {
+	if err := p.checkNodeSizes(); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(p.HashName)+(len(p.Leaves)+len(p.Nodes))*p.NodeSize+len(p.Indices)*2+16)
+	buf = binary.AppendUvarint(buf, uint64(len(p.HashName)))
+	buf = append(buf, p.HashName...)
+	buf = binary.AppendUvarint(buf, uint64(p.NodeSize))
+	buf = binary.AppendUvarint(buf, uint64(len(p.Indices)))
+	for _, index := range p.Indices {
+		buf = binary.AppendUvarint(buf, index)
+	}
+	for _, section := range [][][]byte{p.Leaves, p.Nodes} {
+		buf = binary.AppendUvarint(buf, uint64(len(section)))
+		for _, n := range section {
+			buf = append(buf, n...)
+		}
+	}
+	return buf, nil
+}
+
+// DecodeProof deserializes a proof encoded with EncodeProof.
+func DecodeProof(data []byte) (Proof, error) {
+	d := proofDecoder{data: data}
+	var p Proof
+	p.HashName = string(d.bytes(d.uvarint()))
+	nodeSize := d.uvarint()
+	if d.err == nil && (nodeSize == 0 || nodeSize > math.MaxUint32) {
+		return Proof{}, fmt.Errorf("invalid node size %d", nodeSize)
+	}
+	p.NodeSize = int(nodeSize)
+	numIndices := d.count(1)
+	for i := uint64(0); i < numIndices && d.err == nil; i++ {
+		p.Indices = append(p.Indices, d.uvarint())
+	}
+	p.Leaves = d.nodes(p.NodeSize)
+	p.Nodes = d.nodes(p.NodeSize)
+	if d.err != nil {
+		return Proof{}, d.err
+	}
+	if len(d.data) > 0 {
+		return Proof{}, fmt.Errorf("%d unexpected trailing bytes after proof", len(d.data))
+	}
+	return p, nil
+}
+
+// proofFormatVersion is the version of the format written by MarshalProof.
+const proofFormatVersion = 1
+
+// MarshalProof serializes the output of GenerateProof. The encoding is a version byte followed by the EncodeProof
+// encoding of the proof, without a hash name, so both share a single format. All leaves and proof nodes must have the
+// same size.
+func MarshalProof(indices []uint64, leaves, proof [][]byte) ([]byte, error) {
+	if len(indices) != len(leaves) {
+		return nil, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(leaves), len(indices))
+	}
+	nodeSize := NodeSize
+	if len(leaves) > 0 {
+		nodeSize = len(leaves[0])
+	} else if len(proof) > 0 {
+		nodeSize = len(proof[0])
+	}
+	encoded, err := EncodeProof(Proof{NodeSize: nodeSize, Indices: indices, Leaves: leaves, Nodes: proof})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{proofFormatVersion}, encoded...), nil
+}
+
+// UnmarshalProof deserializes a proof serialized with MarshalProof.
+func UnmarshalProof(data []byte) (indices []uint64, leaves, proof [][]byte, err error) {
+	if len(data) == 0 {
+		return nil, nil, nil, errTruncatedProof
+	}
+	if data[0] != proofFormatVersion {
+		return nil, nil, nil, fmt.Errorf("unsupported proof format version %d", data[0])
+	}
+	p, err := DecodeProof(data[1:])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("while decoding proof: %w", err)
+	}
+	if len(p.Indices) != len(p.Leaves) {
+		return nil, nil, nil, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(p.Leaves),
+			len(p.Indices))
+	}
+	return p.Indices, p.Leaves, p.Nodes, nil
+}
+
+// proofDecoder consumes data, recording the first error. Once an error occurs, all subsequent reads return zero
+// values.
+type proofDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *proofDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncatedProof
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *proofDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = errTruncatedProof
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// count reads an element count and verifies that the remaining data can hold that many elements of at least minSize
+// bytes each, to avoid huge allocations on malformed input.
+func (d *proofDecoder) count(minSize int) uint64 {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)/minSize) {
+		d.err = errTruncatedProof
+		return 0
+	}
+	return n
+}
+
+func (d *proofDecoder) nodes(nodeSize int) [][]byte {
+	n := d.count(nodeSize)
+	var ret [][]byte
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		ret = append(ret, append([]byte(nil), d.bytes(uint64(nodeSize))...))
+	}
+	return ret
+}
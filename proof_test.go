@@ -0,0 +1,287 @@
+package merkle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+	"github.com/spacemeshos/merkle-tree/cache"
+)
+
+func TestProofEncodeDecodeVerify(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	indices, leaves, nodes, err := GenerateProof(setOf(0, 4, 7), cacheReader)
+	r.NoError(err)
+
+	proof := merkle.Proof{
+		HashName: merkle.Sha256HashName,
+		NodeSize: NodeSize,
+		Indices:  indices,
+		Leaves:   leaves,
+		Nodes:    nodes,
+	}
+	encoded, err := merkle.EncodeProof(proof)
+	r.NoError(err)
+	decoded, err := merkle.DecodeProof(encoded)
+	r.NoError(err)
+	r.Equal(proof, decoded)
+
+	valid, err := decoded.Verify(tree.Root())
+	r.NoError(err)
+	r.True(valid, "Proof should be valid, but isn't")
+
+	valid, err = decoded.Verify(NewNodeFromUint64(0))
+	r.NoError(err)
+	r.False(valid)
+
+	decoded.HashName = "unknown"
+	valid, err = decoded.Verify(tree.Root())
+	r.EqualError(err, `unknown hash function "unknown"`)
+	r.False(valid)
+
+	_, err = merkle.DecodeProof(encoded[:len(encoded)-1])
+	r.EqualError(err, "truncated proof")
+
+	_, err = merkle.DecodeProof(append(encoded, 0))
+	r.EqualError(err, "1 unexpected trailing bytes after proof")
+}
+
+func TestRegisterHashFunc(t *testing.T) {
+	r := require.New(t)
+
+	// The registry is global, so use a name that's unique to this test run.
+	name := fmt.Sprintf("concat-%p", t)
+	r.NoError(merkle.RegisterHashFunc(name, concatLeaves))
+	hash, found := merkle.HashFuncByName(name)
+	r.True(found)
+	r.NotNil(hash)
+
+	r.EqualError(merkle.RegisterHashFunc(name, concatLeaves), fmt.Sprintf("hash function %q is already registered", name))
+	r.EqualError(merkle.RegisterHashFunc("nil", nil), `cannot register nil hash function "nil"`)
+}
+
+func TestVerifyEncoded(t *testing.T) {
+	r := require.New(t)
+
+	tree, err := NewProvingTree(setOf(0, 4, 7))
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, nodes := tree.RootAndProof()
+	proof := merkle.Proof{
+		HashName: merkle.Sha256HashName,
+		NodeSize: NodeSize,
+		Indices:  []uint64{0, 4, 7},
+		Leaves:   [][]byte{NewNodeFromUint64(0), NewNodeFromUint64(4), NewNodeFromUint64(7)},
+		Nodes:    nodes,
+	}
+	encoded, err := merkle.EncodeProof(proof)
+	r.NoError(err)
+
+	result, err := merkle.VerifyEncoded(encoded, root, nil)
+	r.NoError(err)
+	r.True(result.Valid)
+	r.Equal(root, result.ComputedRoot)
+	r.Equal(uint(3), result.RootHeight)
+	r.Equal(4, result.ProofNodesConsumed)
+	r.Equal(0, result.ProofNodesLeftOver)
+	r.Len(result.Nodes, 13)
+	validNodes := result.Nodes
+	_, diverges := result.FirstDivergence(validNodes)
+	r.False(diverges)
+
+	// Corrupt the proof node at layer 1, the parent of leaves 2 and 3. The divergence from the valid proof's nodes
+	// starts there.
+	proof.Nodes = append([][]byte{}, nodes...)
+	proof.Nodes[1] = NewNodeFromUint64(41)
+	encoded, err = merkle.EncodeProof(proof)
+	r.NoError(err)
+	result, err = merkle.VerifyEncoded(encoded, root, nil)
+	r.NoError(err)
+	r.False(result.Valid)
+	r.Equal(proof.Nodes[1], result.Nodes[merkle.Position{Index: 1, Height: 1}])
+	layer, diverges := result.FirstDivergence(validNodes)
+	r.True(diverges)
+	r.Equal(uint(1), layer)
+
+	// A proof that runs out of nodes reports the nodes used until then. They're all correct, but the root is calculated
+	// too low.
+	proof.Nodes = nodes[:1]
+	encoded, err = merkle.EncodeProof(proof)
+	r.NoError(err)
+	result, err = merkle.VerifyEncoded(encoded, root, nil)
+	r.NoError(err)
+	r.False(result.Valid)
+	r.Equal(1, result.ProofNodesConsumed)
+	r.Equal(nodes[0], result.Nodes[merkle.Position{Index: 1}])
+	r.Equal(uint(1), result.RootHeight)
+	_, diverges = result.FirstDivergence(validNodes)
+	r.False(diverges)
+
+	// Corrupt the last proof node and add an extra one.
+	proof.Nodes = append([][]byte{}, nodes...)
+	proof.Nodes[3] = NewNodeFromUint64(42)
+	proof.Nodes = append(proof.Nodes, NewNodeFromUint64(43))
+	encoded, err = merkle.EncodeProof(proof)
+	r.NoError(err)
+
+	result, err = merkle.VerifyEncoded(encoded, root, nil)
+	r.NoError(err)
+	r.False(result.Valid)
+	r.NotEqual(root, result.ComputedRoot)
+	r.Equal(uint(4), result.RootHeight)
+	r.Equal(5, result.ProofNodesConsumed)
+	r.Equal(0, result.ProofNodesLeftOver)
+	layer, diverges = result.FirstDivergence(validNodes)
+	r.True(diverges)
+	r.Equal(uint(0), layer, "the last proof node is leaf 6")
+
+	_, err = merkle.VerifyEncoded(encoded, root, func(string) (merkle.HashFunc, bool) { return nil, false })
+	r.EqualError(err, `unknown hash function "sha256"`)
+
+	_, err = merkle.VerifyEncoded(encoded[:3], root, nil)
+	r.EqualError(err, "while decoding proof: truncated proof")
+}
+
+func TestProofProtoBytes(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 500; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	indices, leaves, nodes, err := GenerateProof(setOf(1, 300, 499), cacheReader)
+	r.NoError(err)
+
+	proof := merkle.Proof{
+		HashName: merkle.Sha256HashName,
+		NodeSize: NodeSize,
+		Indices:  indices,
+		Leaves:   leaves,
+		Nodes:    nodes,
+	}
+	encoded, err := proof.ToProtoBytes()
+	r.NoError(err)
+	var decoded merkle.Proof
+	r.NoError(decoded.FromProtoBytes(encoded))
+	r.Equal(proof, decoded)
+
+	valid, err := decoded.Verify(tree.Root())
+	r.NoError(err)
+	r.True(valid, "Proof should be valid, but isn't")
+
+	r.EqualError(decoded.FromProtoBytes(encoded[:len(encoded)-1]), "truncated proof")
+}
+
+func TestProofFromProtoBytesWireFormat(t *testing.T) {
+	r := require.New(t)
+
+	leaf := NewNodeFromUint64(7)
+	var data []byte
+	data = append(data, 0x08, 0x01)             // indices: 1 (unpacked)
+	data = append(data, 0x0a, 0x02, 0xac, 0x02) // indices: [300] (packed)
+	data = append(data, 0x12, 0x20)             // leaves: 32 bytes
+	data = append(data, leaf...)
+	data = append(data, 0x22, 0x03, 'a', 'b', 'c') // hash: "abc"
+	data = append(data, 0x30, 0x05)                // unknown field 6: 5
+	data = append(data, 0x3a, 0x01, 0xff)          // unknown field 7: 1 byte
+	data = append(data, 0x28, 0x20)                // node_size: 32
+
+	var decoded merkle.Proof
+	r.NoError(decoded.FromProtoBytes(data))
+	r.Equal(merkle.Proof{
+		HashName: "abc",
+		NodeSize: 32,
+		Indices:  []uint64{1, 300},
+		Leaves:   [][]byte{leaf},
+	}, decoded)
+
+	encoded, err := decoded.ToProtoBytes()
+	r.NoError(err)
+	r.Equal([]byte{0x0a, 0x03, 0x01, 0xac, 0x02, 0x12, 0x20}, encoded[:7])
+
+	r.EqualError(decoded.FromProtoBytes([]byte{0x28, 0x00}), "invalid node size 0")
+}
+
+func TestMarshalProof(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 1000; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	provenLeafIndices := make(map[uint64]bool)
+	for i := uint64(0); i < 1000; i += 7 {
+		provenLeafIndices[i] = true
+	}
+	indices, leaves, proof, err := GenerateProof(provenLeafIndices, cacheReader)
+	r.NoError(err)
+
+	for name, tc := range map[string]struct {
+		indices []uint64
+		leaves  [][]byte
+		proof   [][]byte
+	}{
+		"empty":       {},
+		"no proof":    {indices: []uint64{0}, leaves: [][]byte{NewNodeFromUint64(0)}},
+		"small nodes": {indices: []uint64{1}, leaves: [][]byte{{1, 2}}, proof: [][]byte{{3, 4}}},
+		"multiproof":  {indices: indices, leaves: leaves, proof: proof},
+	} {
+		data, err := merkle.MarshalProof(tc.indices, tc.leaves, tc.proof)
+		r.NoError(err, name)
+		decodedIndices, decodedLeaves, decodedProof, err := merkle.UnmarshalProof(data)
+		r.NoError(err, name)
+		r.Equal(tc.indices, decodedIndices, name)
+		r.Equal(tc.leaves, decodedLeaves, name)
+		r.Equal(tc.proof, decodedProof, name)
+
+		// The data is the EncodeProof encoding of the proof, after the version byte.
+		decoded, err := merkle.DecodeProof(data[1:])
+		r.NoError(err, name)
+		r.Equal(tc.proof, decoded.Nodes, name)
+
+		// Every truncation and every extension of the data must fail without panicking.
+		for i := 0; i < len(data); i++ {
+			_, _, _, err := merkle.UnmarshalProof(data[:i])
+			r.Error(err, "%s truncated to %d bytes", name, i)
+		}
+		_, _, _, err = merkle.UnmarshalProof(append(data, 0))
+		r.EqualError(err, "while decoding proof: 1 unexpected trailing bytes after proof", name)
+	}
+
+	valid, err := ValidatePartialTree(indices, leaves, proof, tree.Root(), GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+
+	_, err = merkle.MarshalProof([]uint64{0, 1}, [][]byte{NewNodeFromUint64(0)}, nil)
+	r.EqualError(err, "number of leaves (1) must equal number of indices (2)")
+	_, err = merkle.MarshalProof([]uint64{0}, [][]byte{NewNodeFromUint64(0)}, [][]byte{{1}})
+	r.EqualError(err, "proof node 0 has size 1 instead of 32")
+
+	_, _, _, err = merkle.UnmarshalProof([]byte{2})
+	r.EqualError(err, "unsupported proof format version 2")
+	_, _, _, err = merkle.UnmarshalProof([]byte{1, 0, 0})
+	r.EqualError(err, "while decoding proof: invalid node size 0")
+	_, _, _, err = merkle.UnmarshalProof([]byte{1, 0, 32, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	r.EqualError(err, "while decoding proof: truncated proof")
+}
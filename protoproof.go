@@ -0,0 +1,132 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Proof field numbers in the protobuf schema documented on Proof.ToProtoBytes.
+const (
+	protoFieldIndices  = 1
+	protoFieldLeaves   = 2
+	protoFieldNodes    = 3
+	protoFieldHash     = 4
+	protoFieldNodeSize = 5
+)
+
+// Protobuf wire types.
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+// ToProtoBytes serializes p as a protobuf message, without depending on a protobuf library. The encoding is
+// wire-compatible with the following proto3 schema, so proofs can be consumed by verifiers in other languages:
+//
+//	message Proof {
+//	  repeated uint64 indices = 1; // Packed.
+//	  repeated bytes leaves = 2;
+//	  repeated bytes nodes = 3;
+//	  string hash = 4;             // The name the hash function is registered under, see RegisterHashFunc.
+//	  uint32 node_size = 5;
+//	}
+func (p Proof) ToProtoBytes() ([]byte, error) {
+	if err := p.checkNodeSizes(); err != nil {
+		return nil, err
+	}
+	if uint64(p.NodeSize) > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid node size %d", p.NodeSize)
+	}
+	var buf []byte
+	if len(p.Indices) > 0 {
+		var packed []byte
+		for _, index := range p.Indices {
+			packed = binary.AppendUvarint(packed, index)
+		}
+		buf = appendProtoBytes(buf, protoFieldIndices, packed)
+	}
+	for _, leaf := range p.Leaves {
+		buf = appendProtoBytes(buf, protoFieldLeaves, leaf)
+	}
+	for _, n := range p.Nodes {
+		buf = appendProtoBytes(buf, protoFieldNodes, n)
+	}
+	if p.HashName != "" {
+		buf = appendProtoBytes(buf, protoFieldHash, []byte(p.HashName))
+	}
+	buf = binary.AppendUvarint(buf, protoFieldNodeSize<<3|protoWireVarint)
+	buf = binary.AppendUvarint(buf, uint64(p.NodeSize))
+	return buf, nil
+}
+
+// FromProtoBytes deserializes a protobuf message with the schema documented on ToProtoBytes into p. Like any protobuf
+// parser, it accepts both packed and unpacked indices and skips unknown fields.
+func (p *Proof) FromProtoBytes(data []byte) error {
+	d := proofDecoder{data: data}
+	var decoded Proof
+	for len(d.data) > 0 && d.err == nil {
+		key := d.uvarint()
+		field, wireType := key>>3, key&7
+		switch {
+		case field == protoFieldIndices && wireType == protoWireBytes:
+			packed := proofDecoder{data: d.bytes(d.uvarint())}
+			for len(packed.data) > 0 && packed.err == nil {
+				decoded.Indices = append(decoded.Indices, packed.uvarint())
+			}
+			if d.err == nil {
+				d.err = packed.err
+			}
+		case field == protoFieldIndices && wireType == protoWireVarint:
+			decoded.Indices = append(decoded.Indices, d.uvarint())
+		case field == protoFieldLeaves && wireType == protoWireBytes:
+			decoded.Leaves = append(decoded.Leaves, append([]byte(nil), d.bytes(d.uvarint())...))
+		case field == protoFieldNodes && wireType == protoWireBytes:
+			decoded.Nodes = append(decoded.Nodes, append([]byte(nil), d.bytes(d.uvarint())...))
+		case field == protoFieldHash && wireType == protoWireBytes:
+			decoded.HashName = string(d.bytes(d.uvarint()))
+		case field == protoFieldNodeSize && wireType == protoWireVarint:
+			nodeSize := d.uvarint()
+			if nodeSize > math.MaxUint32 {
+				return fmt.Errorf("invalid node size %d", nodeSize)
+			}
+			decoded.NodeSize = int(nodeSize)
+		default:
+			d.skipProtoField(field, wireType)
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if err := decoded.checkNodeSizes(); err != nil {
+		return err
+	}
+	*p = decoded
+	return nil
+}
+
+func appendProtoBytes(buf []byte, field uint64, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, field<<3|protoWireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// skipProtoField skips the value of an unknown field with the given wire type.
+func (d *proofDecoder) skipProtoField(field, wireType uint64) {
+	switch wireType {
+	case protoWireVarint:
+		d.uvarint()
+	case protoWireFixed64:
+		d.bytes(8)
+	case protoWireBytes:
+		d.bytes(d.uvarint())
+	case protoWireFixed32:
+		d.bytes(4)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unsupported wire type %d for field %d", wireType, field)
+		}
+	}
+}
@@ -1,16 +1,178 @@
 package merkle
 
 import (
+	"bytes"
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
 var ErrMissingValueAtBaseLayer = errors.New("reader for base layer must be included")
 
+// ErrCorruptedCache is returned when a cached layer's width is inconsistent with the width of the base layer, e.g.
+// because the file backing it was truncated.
+var ErrCorruptedCache = errors.New("corrupted cache")
+
+// ErrPadToPowerOfTwoCache is returned when generating a proof, or calculating a node, would require padding the cache of
+// an unbalanced tree built with TreeBuilder.WithPadToPowerOfTwo. Such trees are padded differently than GenerateProof
+// and GetNode pad.
+var ErrPadToPowerOfTwoCache = errors.New("padding the cache of a tree padded to a power of two isn't supported")
+
 func GenerateProof(
 	provenLeafIndices map[uint64]bool,
 	treeCache CacheReader,
+) (sortedProvenLeafIndices []uint64, provenLeaves, proofNodes [][]byte, err error) {
+	return GenerateProofContext(context.Background(), provenLeafIndices, treeCache)
+}
+
+// GenerateProofContext works like GenerateProof, but stops and returns ctx.Err() when ctx is done. The context is
+// checked before processing each proven leaf's subtree and before reading each leaf of the subtree.
+func GenerateProofContext(
+	ctx context.Context,
+	provenLeafIndices map[uint64]bool,
+	treeCache CacheReader,
+) (sortedProvenLeafIndices []uint64, provenLeaves, proofNodes [][]byte, err error) {
+	return generateProof(ctx, provenLeafIndices, treeCache, GetNode)
+}
+
+// GenerateProofWithHints works like GenerateProof, but nodes found in hints are used as-is instead of being read from
+// the cache or recalculated. This applies to proof nodes and to the nodes they're calculated from when their layer
+// isn't cached, so a hint deep below an uncached proof node saves recalculating its subtree. The proof nodes within
+// the subtree of a proven leaf up to the lowest cached layer above it are always calculated from the base layer, since
+// that subtree's leaves are read anyway. Hints are trusted: a wrong hint results in a proof that doesn't validate.
+func GenerateProofWithHints(
+	provenLeafIndices Set,
+	treeCache CacheReader,
+	hints map[Position][]byte,
+) (sortedProvenLeafIndices []uint64, provenLeaves, proofNodes [][]byte, err error) {
+	lookup := hintLookup{hints: hints, ancestors: make(map[Position]bool)}
+	for pos := range hints {
+		for p := pos.parent(); p.Height < 64 && !lookup.ancestors[p]; p = p.parent() {
+			lookup.ancestors[p] = true
+		}
+	}
+	getNodeWithHints := func(c CacheReader, nodePos Position) ([]byte, error) {
+		return getNode(c, nodePos, lookup)
+	}
+	return generateProof(context.Background(), provenLeafIndices, treeCache, getNodeWithHints)
+}
+
+// hintLookup is the nodeLookup of GenerateProofWithHints. Nodes are only calculated from their children when they're
+// ancestors of a hint.
+type hintLookup struct {
+	hints     map[Position][]byte
+	ancestors map[Position]bool
+}
+
+func (l hintLookup) lookup(pos Position) ([]byte, bool) {
+	n, found := l.hints[pos]
+	return n, found
+}
+
+func (l hintLookup) descend(pos Position, _ uint) bool {
+	return l.ancestors[pos]
+}
+
+func (hintLookup) store(Position, []byte) {}
+
+// ProofNode is a proof node along with its position in the tree.
+type ProofNode struct {
+	Pos   Position
+	Value []byte
+}
+
+// GenerateProofWithPositions works like GenerateProof, but returns each proof node along with its position in the
+// tree. Proof nodes that are padding beyond the right edge of an unbalanced tree have positions outside the tree.
+func GenerateProofWithPositions(
+	provenLeafIndices Set,
+	treeCache CacheReader,
+) (sortedProvenLeafIndices []uint64, provenLeaves [][]byte, proofNodes []ProofNode, err error) {
+	sortedProvenLeafIndices, provenLeaves, nodes, err := GenerateProof(provenLeafIndices, treeCache)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	width, err := treeCache.GetLayerReader(0).Width()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	positions := proofPositions(sortedProvenLeafIndices, RootHeightFromWidth(width))
+	if len(positions) != len(nodes) {
+		return nil, nil, nil, fmt.Errorf("expected %d proof nodes, got %d", len(positions), len(nodes))
+	}
+	proofNodes = make([]ProofNode, len(nodes))
+	for i := range nodes {
+		proofNodes[i] = ProofNode{Pos: positions[i], Value: nodes[i]}
+	}
+	return sortedProvenLeafIndices, provenLeaves, proofNodes, nil
+}
+
+// GenerateRangeProof generates a proof for the contiguous range of leaves [start, end). Since every leaf in the range
+// is proven, the proof only holds the siblings on the boundaries of the range, along with padding for ranges that reach
+// the unbalanced right edge of the tree. Validate it with ValidateRangeProof.
+func GenerateRangeProof(start, end uint64, treeCache CacheReader) (leaves, proof [][]byte, err error) {
+	width, err := treeCache.GetLayerReader(0).Width()
+	if err != nil {
+		return nil, nil, err
+	}
+	if start >= end || end > width {
+		return nil, nil, fmt.Errorf("invalid range [%d, %d) for tree of width %d", start, end, width)
+	}
+	leavesToProve := make(Set, end-start)
+	for i := start; i < end; i++ {
+		leavesToProve[i] = true
+	}
+	_, leaves, proof, err = GenerateProof(leavesToProve, treeCache)
+	return leaves, proof, err
+}
+
+// ExpectedProofLength returns the number of nodes in a proof for the given leaf indices in a tree of the given width.
+// The indices don't need to be sorted and may contain duplicates, but must be less than width.
+func ExpectedProofLength(width uint64, indices []uint64) int {
+	sorted := SetOf(indices...).AsSortedSlice()
+	return len(proofPositions(sorted, RootHeightFromWidth(width)))
+}
+
+// ProofOverhead returns the size of a proof for the given leaf indices in a tree of the given width, both in total and
+// per proven leaf, when every node takes nodeSize bytes. It's useful for comparing the cost of proving different sets
+// of leaves. perLeaf is 0 when no indices are given.
+func ProofOverhead(width uint64, indices []uint64, nodeSize int) (totalProofBytes int, perLeaf float64) {
+	totalProofBytes = ExpectedProofLength(width, indices) * nodeSize
+	if leafCount := len(SetOf(indices...)); leafCount > 0 {
+		perLeaf = float64(totalProofBytes) / float64(leafCount)
+	}
+	return totalProofBytes, perLeaf
+}
+
+// proofPositions returns the positions of the proof nodes for the given sorted leaf indices, in the order in which
+// they appear in the proof. It follows the same traversal as Validator.CalcRoot.
+func proofPositions(sortedLeafIndices []uint64, rootHeight uint) []Position {
+	var positions []Position
+	var traverse func(stopAtLayer uint)
+	traverse = func(stopAtLayer uint) {
+		activePos := Position{Index: sortedLeafIndices[0]}
+		sortedLeafIndices = sortedLeafIndices[1:]
+		for ; activePos.Height < stopAtLayer; activePos = activePos.parent() {
+			if len(sortedLeafIndices) > 0 && activePos.sibling().isAncestorOf(Position{Index: sortedLeafIndices[0]}) {
+				traverse(activePos.Height)
+				continue
+			}
+			positions = append(positions, activePos.sibling())
+		}
+	}
+	if len(sortedLeafIndices) > 0 {
+		traverse(rootHeight)
+	}
+	return positions
+}
+
+func generateProof(
+	ctx context.Context,
+	provenLeafIndices map[uint64]bool,
+	treeCache CacheReader,
+	getNode func(c CacheReader, nodePos Position) ([]byte, error),
 ) (sortedProvenLeafIndices []uint64, provenLeaves, proofNodes [][]byte, err error) {
 	provenLeafIndexIt := NewPositionsIterator(provenLeafIndices)
 	skipPositions := &positionsStack{}
@@ -18,9 +180,15 @@ func GenerateProof(
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	if width&(width-1) != 0 && padsToPowerOfTwo(treeCache) {
+		return nil, nil, nil, ErrPadToPowerOfTwoCache
+	}
 	rootHeight := RootHeightFromWidth(width)
 
 	for { // Process proven leaves:
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, err
+		}
 
 		// Get the leaf whose subtree we'll traverse.
 		nextProvenLeafPos, found := provenLeafIndexIt.peek()
@@ -38,7 +206,7 @@ func GenerateProof(
 		// Prepare list of leaves to prove in the subtree.
 		leavesToProve := provenLeafIndexIt.batchPop(subtreeStart.Index + width)
 
-		additionalProof, additionalLeaves, err := calcSubtreeProof(treeCache, leavesToProve, subtreeStart, width)
+		additionalProof, additionalLeaves, err := calcSubtreeProof(ctx, treeCache, leavesToProve, subtreeStart, width)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -60,7 +228,7 @@ func GenerateProof(
 				skipPositions.Push(currentPos.sibling())
 				break
 			}
-			currentVal, err := GetNode(treeCache, currentPos.sibling())
+			currentVal, err := getNode(treeCache, currentPos.sibling())
 			if err != nil {
 				return nil, nil, nil, err
 			}
@@ -71,7 +239,61 @@ func GenerateProof(
 	return Set(provenLeafIndices).AsSortedSlice(), provenLeaves, proofNodes, nil
 }
 
-func calcSubtreeProof(c CacheReader, leavesToProve Set, subtreeStart Position, width uint64) (
+// UpdateProof updates the proof of leaf k from a tree of m leaves to a tree of n leaves (n >= m) that extends it. The
+// siblings of k whose subtrees are complete in the m-leaf tree are unchanged and taken from oldProof. The siblings
+// that cover leaves in [m, n) must be supplied in newRightPath, ordered from the lowest layer up. Siblings entirely
+// beyond n are padding and are filled in automatically. They're set to padding, which must be the value passed to
+// TreeBuilder.WithPaddingValue, or to a zero node of the size of the other proof nodes when padding is nil. hash is the
+// tree's hash function. No nodes need to be rehashed, since unbalanced trees are padded with a single node per layer.
+func UpdateProof(oldProof [][]byte, k, m, n uint64, newRightPath [][]byte, hash HashFunc, padding []byte) (
+	[][]byte, error,
+) {
+	if hash == nil {
+		return nil, errNilHash
+	}
+	if k >= m {
+		return nil, fmt.Errorf("leaf index %d is out of range for tree of size %d", k, m)
+	}
+	if n < m {
+		return nil, fmt.Errorf("new size %d is smaller than old size %d", n, m)
+	}
+	if uint(len(oldProof)) != RootHeightFromWidth(m) {
+		return nil, fmt.Errorf("proof for tree of size %d must have %d nodes, got %d", m, RootHeightFromWidth(m),
+			len(oldProof))
+	}
+	if padding == nil {
+		nodeSize := NodeSize
+		if len(oldProof) > 0 {
+			nodeSize = len(oldProof[0])
+		} else if len(newRightPath) > 0 {
+			nodeSize = len(newRightPath[0])
+		}
+		padding = make([]byte, nodeSize)
+	}
+	newRootHeight := RootHeightFromWidth(n)
+	newProof := make([][]byte, 0, newRootHeight)
+	for height := uint(0); height < newRootHeight; height++ {
+		sibling := Position{Index: k >> height, Height: height}.sibling()
+		firstLeaf, lastLeaf := sibling.Index<<height, (sibling.Index+1)<<height-1
+		switch {
+		case lastLeaf < m:
+			newProof = append(newProof, oldProof[height])
+		case firstLeaf >= n:
+			newProof = append(newProof, padding)
+		case len(newRightPath) == 0:
+			return nil, fmt.Errorf("missing new node for sibling at Position %s", sibling)
+		default:
+			newProof = append(newProof, newRightPath[0])
+			newRightPath = newRightPath[1:]
+		}
+	}
+	if len(newRightPath) > 0 {
+		return nil, fmt.Errorf("%d unused nodes in new right path", len(newRightPath))
+	}
+	return newProof, nil
+}
+
+func calcSubtreeProof(ctx context.Context, c CacheReader, leavesToProve Set, subtreeStart Position, width uint64) (
 	additionalProof, additionalLeaves [][]byte, err error,
 ) {
 	// By subtracting subtreeStart.index we get the index relative to the subtree.
@@ -87,7 +309,8 @@ func calcSubtreeProof(c CacheReader, leavesToProve Set, subtreeStart Position, w
 		return nil, nil, fmt.Errorf("while preparing to traverse subtree: %w", err)
 	}
 
-	_, additionalProof, additionalLeaves, err = traverseSubtree(reader, width, c.GetHashFunc(), relativeLeavesToProve, nil)
+	_, additionalProof, additionalLeaves, err = traverseSubtree(ctx, reader, subtreeStart, width, c.GetHashFunc(),
+		cacheMetrics(c), cachePaddingValue(c, readerNodeSize(reader)), relativeLeavesToProve, nil)
 	if err != nil {
 		return nil, nil, fmt.Errorf("while traversing subtree: %w", err)
 	}
@@ -95,12 +318,21 @@ func calcSubtreeProof(c CacheReader, leavesToProve Set, subtreeStart Position, w
 	return additionalProof, additionalLeaves, err
 }
 
-func traverseSubtree(leafReader LayerReader, width uint64, hash HashFunc, leavesToProve Set,
-	externalPadding []byte,
+// subtreeNodePool recycles the ephemeral nodes of the trees built by traverseSubtree.
+var subtreeNodePool = sync.Pool{New: func() any { return new([]byte) }}
+
+// traverseSubtree builds the subtree whose leftmost leaf is at subtreeStart from width nodes read from leafReader, which
+// must already be positioned at subtreeStart. The subtree is padded with padding.
+func traverseSubtree(ctx context.Context, leafReader LayerReader, subtreeStart Position, width uint64, hash HashFunc,
+	metrics Metrics, padding []byte, leavesToProve Set, externalPadding []byte,
 ) (root []byte, proof, provenLeaves [][]byte, err error) {
 	shouldUseExternalPadding := externalPadding != nil
 	t, err := NewTreeBuilder().
 		WithHashFunc(hash).
+		WithPaddingValue(padding).
+		WithMetrics(metrics).
+		WithNodePool(&subtreeNodePool).
+		WithNodeSize(uint(readerNodeSize(leafReader))).
 		WithLeavesToProve(leavesToProve).
 		WithMinHeight(RootHeightFromWidth(width)). // This ensures the correct size tree, even if padding is needed.
 		Build()
@@ -108,6 +340,9 @@ func traverseSubtree(leafReader LayerReader, width uint64, hash HashFunc, leaves
 		return nil, nil, nil, fmt.Errorf("while building a tree: %w", err)
 	}
 	for i := uint64(0); i < width; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, err
+		}
 		leaf, err := leafReader.ReadNext()
 		if err == io.EOF {
 			// Add external padding if provided.
@@ -118,6 +353,8 @@ func traverseSubtree(leafReader LayerReader, width uint64, hash HashFunc, leaves
 			shouldUseExternalPadding = false
 		} else if err != nil {
 			return nil, nil, nil, fmt.Errorf("while reading a leaf: %w", err)
+		} else {
+			metrics.OnNodeRead(Position{Index: subtreeStart.Index + i, Height: subtreeStart.Height})
 		}
 		err = t.AddLeaf(leaf)
 		if err != nil {
@@ -131,18 +368,153 @@ func traverseSubtree(leafReader LayerReader, width uint64, hash HashFunc, leaves
 	return root, proof, provenLeaves, nil
 }
 
+// NodeMemo remembers nodes read or calculated by GetNode, so generating several proofs from a cache that's missing
+// intermediate layers doesn't recalculate the same nodes over and over. Nodes calculated on the way to a requested
+// node are remembered too, down to subtrees of 2^3 nodes of the cached layer below them, so requests for nested
+// positions also reuse work. A memo must only be used with a single cache. If the cache's base layer grows, the
+// remembered nodes are discarded, but other changes to the cache aren't detected.
+//
+// The memo guards its own state with a mutex, but the cache reader passed to it isn't safe for concurrent use, since
+// its layers share a single read position. Goroutines may share a memo only if each uses its own clone of the cache
+// (see cache.Reader.Clone).
+type NodeMemo struct {
+	mu        sync.Mutex
+	capacity  int // The maximum number of remembered nodes, or 0 for no limit.
+	nodes     map[Position]*list.Element
+	lru       *list.List // Remembered nodes, most recently used first.
+	baseWidth uint64     // The width of the base layer when the nodes were remembered.
+}
+
+// memoSubtreeHeight is the height of the subtrees above a cached layer that NodeMemo traverses as a whole, without
+// remembering their inner nodes. This keeps sequential reads of the cached layer and bounds the remembered nodes.
+const memoSubtreeHeight = 3
+
+type memoEntry struct {
+	pos  Position
+	node []byte
+}
+
+// NewNodeMemo returns an empty NodeMemo without a limit on the number of remembered nodes.
+func NewNodeMemo() *NodeMemo {
+	return NewNodeMemoLRU(0)
+}
+
+// NewNodeMemoLRU returns an empty NodeMemo that remembers at most capacity nodes, forgetting the least recently used
+// node when it's full. A capacity of 0 means no limit.
+func NewNodeMemoLRU(capacity int) *NodeMemo {
+	return &NodeMemo{capacity: capacity, nodes: make(map[Position]*list.Element), lru: list.New()}
+}
+
+// GetNode works like GetNode, but returns the remembered node if the position, or the nodes it's calculated from, were
+// read or calculated before.
+func (m *NodeMemo) GetNode(c CacheReader, nodePos Position) ([]byte, error) {
+	baseWidth, err := c.GetLayerReader(0).Width()
+	if err != nil {
+		return nil, fmt.Errorf("while getting base layer width: %w", err)
+	}
+	m.mu.Lock()
+	if baseWidth != m.baseWidth {
+		m.nodes = make(map[Position]*list.Element)
+		m.lru.Init()
+		m.baseWidth = baseWidth
+	}
+	m.mu.Unlock()
+	return getNode(c, nodePos, memoLookup{memo: m, baseWidth: baseWidth})
+}
+
+// memoLookup is the nodeLookup of a NodeMemo, for a cache whose base layer has baseWidth nodes.
+type memoLookup struct {
+	memo      *NodeMemo
+	baseWidth uint64
+}
+
+func (l memoLookup) lookup(pos Position) ([]byte, bool) {
+	l.memo.mu.Lock()
+	defer l.memo.mu.Unlock()
+	elem, found := l.memo.nodes[pos]
+	if !found {
+		return nil, false
+	}
+	l.memo.lru.MoveToFront(elem)
+	return elem.Value.(*memoEntry).node, true
+}
+
+func (l memoLookup) descend(pos Position, cachedHeight uint) bool {
+	return pos.Height > cachedHeight+memoSubtreeHeight
+}
+
+func (l memoLookup) store(pos Position, node []byte) {
+	m := l.memo
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.nodes[pos]; found || l.baseWidth != m.baseWidth {
+		return
+	}
+	m.nodes[pos] = m.lru.PushFront(&memoEntry{pos: pos, node: node})
+	if m.capacity > 0 && m.lru.Len() > m.capacity {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.nodes, oldest.Value.(*memoEntry).pos)
+	}
+}
+
+// GenerateProofWithMemo works like GenerateProof, but reuses nodes remembered by memo from previous calls.
+func GenerateProofWithMemo(
+	provenLeafIndices Set,
+	treeCache CacheReader,
+	memo *NodeMemo,
+) (sortedProvenLeafIndices []uint64, provenLeaves, proofNodes [][]byte, err error) {
+	return generateProof(context.Background(), provenLeafIndices, treeCache, memo.GetNode)
+}
+
 // GetNode reads the node at the requested Position from the cache or calculates it if not available.
 func GetNode(c CacheReader, nodePos Position) ([]byte, error) {
+	return getNode(c, nodePos, nil)
+}
+
+// nodeLookup supplies nodes to getNode and calcNode, so they don't have to be read from the cache or recalculated,
+// e.g. remembered nodes or hints.
+type nodeLookup interface {
+	// lookup returns the node at pos, if it's known.
+	lookup(pos Position) ([]byte, bool)
+	// descend reports whether nodes below pos, above the cached layer at cachedHeight, may be known. If so, pos is
+	// calculated from its children, which are looked up first, instead of traversing its whole subtree.
+	descend(pos Position, cachedHeight uint) bool
+	// store is called with every node read or calculated by getNode.
+	store(pos Position, node []byte)
+}
+
+// getNode works like GetNode, but nodes known to lookup, if it's not nil, are used as-is, including the nodes that the
+// requested node is calculated from.
+func getNode(c CacheReader, nodePos Position, lookup nodeLookup) ([]byte, error) {
+	if lookup == nil {
+		return readNode(c, nodePos, nil)
+	}
+	if n, found := lookup.lookup(nodePos); found {
+		return n, nil
+	}
+	n, err := readNode(c, nodePos, lookup)
+	if err != nil {
+		return nil, err
+	}
+	lookup.store(nodePos, n)
+	return n, nil
+}
+
+// readNode reads the node at nodePos from the cache, or calculates it with calcNode if its layer isn't cached.
+func readNode(c CacheReader, nodePos Position, lookup nodeLookup) ([]byte, error) {
 	// Get the cache reader for the requested node's layer.
 	reader := c.GetLayerReader(nodePos.Height)
 	// If the cache wasn't found, we calculate the minimal subtree that will get us the required node.
 	if reader == nil {
-		return calcNode(c, nodePos)
+		return calcNode(c, nodePos, lookup)
 	}
-
 	err := reader.Seek(nodePos.Index)
 	if err == io.EOF {
-		return calcNode(c, nodePos)
+		if err := checkTruncated(c, nodePos, reader); err != nil {
+			return nil, err
+		}
+		return calcNode(c, nodePos, lookup)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("while seeking to Position %s in cache: %w", nodePos, err)
@@ -151,10 +523,90 @@ func GetNode(c CacheReader, nodePos Position) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("while reading from cache: %w", err)
 	}
+	metrics := cacheMetrics(c)
+	metrics.OnCacheHit(nodePos.Height)
+	metrics.OnNodeRead(nodePos)
 	return currentVal, nil
 }
 
-func calcNode(c CacheReader, nodePos Position) ([]byte, error) {
+// FindLeaf returns the index of the first leaf in the cache's base layer that's equal to leaf. equal decides whether two
+// leaves are equal, e.g. for leaves with several equivalent encodings; if nil, bytes.Equal is used. Note that equal only
+// affects the lookup: the proof for the found leaf is still for the bytes in the cache.
+func FindLeaf(c CacheReader, leaf []byte, equal func(a, b []byte) bool) (index uint64, found bool, err error) {
+	if equal == nil {
+		equal = bytes.Equal
+	}
+	reader := c.GetLayerReader(0)
+	if reader == nil {
+		return 0, false, ErrMissingValueAtBaseLayer
+	}
+	if err := reader.Seek(0); err == io.EOF {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("while seeking to start of base layer: %w", err)
+	}
+	for index = 0; ; index++ {
+		value, err := reader.ReadNext()
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("while reading from cache: %w", err)
+		}
+		if equal(value, leaf) {
+			return index, true, nil
+		}
+	}
+}
+
+// FirstDifference returns the index of the first leaf that differs between the trees in caches a and b. It descends
+// from the root into the leftmost differing child, so it reads O(log(n)) nodes when the caches are complete. Nodes
+// missing from the caches are calculated. Both trees must have the same width.
+func FirstDifference(a, b CacheReader) (index uint64, differs bool, err error) {
+	width, err := a.GetLayerReader(0).Width()
+	if err != nil {
+		return 0, false, err
+	}
+	otherWidth, err := b.GetLayerReader(0).Width()
+	if err != nil {
+		return 0, false, err
+	}
+	if width != otherWidth {
+		return 0, false, fmt.Errorf("trees have different widths (%d and %d)", width, otherWidth)
+	}
+	pos := Position{Height: RootHeightFromWidth(width)}
+	differs, err = nodesDiffer(a, b, pos)
+	if err != nil || !differs {
+		return 0, false, err
+	}
+	for pos.Height > 0 {
+		pos = pos.leftChild()
+		leftDiffers, err := nodesDiffer(a, b, pos)
+		if err != nil {
+			return 0, false, err
+		}
+		if !leftDiffers {
+			pos = pos.sibling()
+		}
+	}
+	return pos.Index, true, nil
+}
+
+func nodesDiffer(a, b CacheReader, nodePos Position) (bool, error) {
+	aNode, err := GetNode(a, nodePos)
+	if err != nil {
+		return false, err
+	}
+	bNode, err := GetNode(b, nodePos)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(aNode, bNode), nil
+}
+
+// calcNode calculates the node at nodePos from the closest cached layer below it. When lookup may know nodes in between,
+// the node is calculated from its children, which are looked up or calculated in turn.
+func calcNode(c CacheReader, nodePos Position, lookup nodeLookup) ([]byte, error) {
 	if nodePos.Height == 0 {
 		return nil, ErrMissingValueAtBaseLayer
 	}
@@ -175,38 +627,139 @@ func calcNode(c CacheReader, nodePos Position) ([]byte, error) {
 		if err != nil && err != io.EOF {
 			return nil, fmt.Errorf("while seeking to Position %s in cache: %w", subtreeStart, err)
 		}
+		if err := checkTruncated(c, subtreeStart, reader); err != nil {
+			return nil, err
+		}
 		if subtreeStart.Height == 0 {
-			return PaddingValue.value, nil
+			if padsToPowerOfTwo(c) {
+				return nil, ErrPadToPowerOfTwoCache
+			}
+			return cachePaddingValue(c, readerNodeSize(reader)), nil
 		}
 	}
 
+	if lookup != nil && nodePos.Height > subtreeStart.Height+1 && lookup.descend(nodePos, subtreeStart.Height) {
+		left, err := getNode(c, nodePos.leftChild(), lookup)
+		if err != nil {
+			return nil, err
+		}
+		// A right child that lies entirely beyond the tree is calculated as padding.
+		right, err := getNode(c, nodePos.leftChild().sibling(), lookup)
+		if err != nil {
+			return nil, err
+		}
+		cacheMetrics(c).OnHash()
+		return c.GetHashFunc()(nil, left, right), nil
+	}
+
 	var paddingValue []byte
 	width := uint64(1) << (nodePos.Height - subtreeStart.Height)
 	readerWidth, err := reader.Width()
 	if err != nil {
 		return nil, fmt.Errorf("while getting reader width: %w", err)
 	}
+	if validatedWidth, found := validatedLayerWidth(c, subtreeStart.Height); found && readerWidth < validatedWidth {
+		return nil, fmt.Errorf("%w: layer %d has width %d instead of %d", ErrCorruptedCache, subtreeStart.Height,
+			readerWidth, validatedWidth)
+	}
 	if readerWidth < subtreeStart.Index+width {
+		if padsToPowerOfTwo(c) {
+			return nil, ErrPadToPowerOfTwoCache
+		}
 		paddingPos := Position{
 			Index:  readerWidth,
 			Height: subtreeStart.Height,
 		}
-		paddingValue, err = calcNode(c, paddingPos)
+		padding := cachePaddingValue(c, readerNodeSize(reader))
+		paddingValue, err = calcNode(c, paddingPos, lookup)
 		if err == ErrMissingValueAtBaseLayer {
-			paddingValue = PaddingValue.value
+			paddingValue = padding
 		} else if err != nil {
 			return nil, fmt.Errorf("while calculating ephemeral node at Position %s: %w", paddingPos, err)
 		}
+		// A left sibling that lies entirely beyond the base layer isn't part of the tree: its parent is padded on-the-fly
+		// instead.
+		if !paddingPos.isRightSibling() && bytes.Equal(paddingValue, padding) {
+			paddingValue = nil
+		}
 	}
 
 	// Traverse the subtree.
-	currentVal, _, _, err := traverseSubtree(reader, width, c.GetHashFunc(), nil, paddingValue)
+	currentVal, _, _, err := traverseSubtree(context.Background(), reader, subtreeStart, width, c.GetHashFunc(),
+		cacheMetrics(c), cachePaddingValue(c, readerNodeSize(reader)), nil, paddingValue)
 	if err != nil {
 		return nil, fmt.Errorf("while traversing subtree for root: %w", err)
 	}
 	return currentVal, nil
 }
 
+// readerNodeSize returns the size of the nodes read by reader, if it reports it with a NodeSize method, and NodeSize
+// otherwise.
+func readerNodeSize(reader LayerReader) int {
+	if sizer, ok := reader.(interface{ NodeSize() int }); ok {
+		return sizer.NodeSize()
+	}
+	return NodeSize
+}
+
+// unwrapCacheReader returns the CacheReader wrapped by CacheReaderWithMetrics, or c itself, so optional methods can be
+// detected on it.
+func unwrapCacheReader(c CacheReader) CacheReader {
+	if m, ok := c.(*metricsCacheReader); ok {
+		return m.CacheReader
+	}
+	return c
+}
+
+// cachePaddingValue returns the node that the tree that wrote c pads with, if c records it like cache.Reader does, or a
+// zero node of the given size.
+func cachePaddingValue(c CacheReader, nodeSize int) []byte {
+	if recorded, ok := unwrapCacheReader(c).(interface{ PaddingValue() []byte }); ok {
+		if padding := recorded.PaddingValue(); padding != nil {
+			return padding
+		}
+	}
+	return make([]byte, nodeSize)
+}
+
+// padsToPowerOfTwo reports whether c is the cache of a tree built with TreeBuilder.WithPadToPowerOfTwo, if c records it
+// like cache.Reader does.
+func padsToPowerOfTwo(c CacheReader) bool {
+	padder, ok := unwrapCacheReader(c).(interface{ PadToPowerOfTwo() bool })
+	return ok && padder.PadToPowerOfTwo()
+}
+
+// validatedLayerWidth returns the width of the layer at the given height when the cache was validated, if c records it
+// like cache.Reader does.
+func validatedLayerWidth(c CacheReader, height uint) (uint64, bool) {
+	c = unwrapCacheReader(c)
+	if validated, ok := c.(interface {
+		ValidatedWidth(layerHeight uint) (uint64, bool)
+	}); ok {
+		return validated.ValidatedWidth(height)
+	}
+	return 0, false
+}
+
+// checkTruncated is called when pos is beyond the end of the cached layer read by reader. It returns ErrCorruptedCache
+// if the layer is shorter than when the cache was validated, i.e. it was truncated since. Otherwise, the missing
+// nodes would silently be treated as padding. The widths are only compared on this path, so reading cached nodes
+// doesn't cost extra Width calls.
+func checkTruncated(c CacheReader, pos Position, reader LayerReader) error {
+	validatedWidth, found := validatedLayerWidth(c, pos.Height)
+	if !found || pos.Index >= validatedWidth {
+		return nil
+	}
+	width, err := reader.Width()
+	if err != nil {
+		return fmt.Errorf("while getting width of layer %d: %w", pos.Height, err)
+	}
+	if width >= validatedWidth {
+		return nil
+	}
+	return fmt.Errorf("%w: layer %d has width %d instead of %d", ErrCorruptedCache, pos.Height, width, validatedWidth)
+}
+
 // subtreeDefinition returns the definition (firstLeaf and root positions, width) for the minimal subtree whose
 // base layer includes p and where the root is on a cached layer. If no cached layer exists above the base layer, the
 // subtree will reach the root of the original tree.
@@ -1,6 +1,8 @@
 package merkle_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"io"
@@ -9,7 +11,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/spacemeshos/merkle-tree"
 	"github.com/spacemeshos/merkle-tree/cache"
+	"github.com/spacemeshos/merkle-tree/cache/readwriters"
 )
 
 /*
@@ -117,6 +121,97 @@ func BenchmarkGenerateProof(b *testing.B) {
 	*/
 }
 
+// BenchmarkGenerateProofWithMemo generates proofs for overlapping sets of leaves from a cache that only has the base
+// layer and layer 2, and reports the number of nodes read from the cache per proof with and without memoization.
+func BenchmarkGenerateProofWithMemo(b *testing.B) {
+	r := require.New(b)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 2: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 1<<14; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	var layers []*countingReader
+	for height, layer := range cacheReader.Layers() {
+		counting := &countingReader{LayerReadWriter: layer}
+		cacheReader.Layers()[height] = counting
+		layers = append(layers, counting)
+	}
+
+	for name, memo := range map[string]*merkle.NodeMemo{"NoMemo": nil, "LRU": merkle.NewNodeMemoLRU(1024)} {
+		b.Run(name, func(b *testing.B) {
+			for _, layer := range layers {
+				layer.reads = 0
+			}
+			for i := 0; i < b.N; i++ {
+				leavesToProve := setOf(uint64(i%8)*1000, uint64(i%8)*1000+3000)
+				if memo == nil {
+					_, _, _, err = GenerateProof(leavesToProve, cacheReader)
+				} else {
+					_, _, _, err = merkle.GenerateProofWithMemo(leavesToProve, cacheReader, memo)
+				}
+				r.NoError(err)
+			}
+			reads := 0
+			for _, layer := range layers {
+				reads += layer.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func TestNodeMemoLRU(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true}), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 16; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	baseLayer := &countingReader{LayerReadWriter: cacheReader.Layers()[0]}
+	cacheReader.Layers()[0] = baseLayer
+
+	memo := merkle.NewNodeMemoLRU(2)
+	getNode := func(pos position) []byte {
+		node, err := memo.GetNode(cacheReader, pos)
+		r.NoError(err)
+		expected, err := GetNode(cacheReader, pos)
+		r.NoError(err)
+		r.Equal(expected, node)
+		return node
+	}
+	readsFor := func(pos position) int {
+		before := baseLayer.reads
+		node, err := memo.GetNode(cacheReader, pos)
+		r.NoError(err)
+		r.NotNil(node)
+		return baseLayer.reads - before
+	}
+
+	a, b, c := position{Height: 2, Index: 0}, position{Height: 2, Index: 1}, position{Height: 2, Index: 2}
+	getNode(a)
+	getNode(b)
+	r.Zero(readsFor(a))
+	getNode(c) // Evicts b, the least recently used node.
+	r.Zero(readsFor(a))
+	r.Zero(readsFor(c))
+	r.Equal(4, readsFor(b))
+
+	// Appending to the base layer discards the remembered nodes.
+	r.Zero(readsFor(b))
+	_, err = cacheReader.Layers()[0].Append(NewNodeFromUint64(16))
+	r.NoError(err)
+	r.Equal(4, readsFor(b))
+}
+
 func TestGenerateProofWithRoot(t *testing.T) {
 	r := require.New(t)
 
@@ -422,6 +517,336 @@ func TestGenerateProofUnbalanced3(t *testing.T) {
 	r.EqualValues([]uint64{0}, sortedIndices)
 }
 
+func TestGenerateProofWithHints(t *testing.T) {
+	r := require.New(t)
+
+	leavesToProve := setOf(0)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 1: true}),
+		cache.MakeSliceReadWriterFactory())
+
+	tree, _ := NewTreeBuilder().
+		WithCacheWriter(cacheWriter).
+		WithLeavesToProve(leavesToProve).
+		Build()
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		r.NoError(err)
+	}
+
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	expectedProof := tree.Proof()
+	r.Len(expectedProof, 3)
+
+	// The node at <h: 2 i: 1> isn't cached and would otherwise be recalculated from the base layer.
+	hintPos := position{Height: 2, Index: 1}
+	_, _, proof, err := merkle.GenerateProofWithHints(leavesToProve, cacheReader,
+		map[position][]byte{hintPos: expectedProof[2]})
+	r.NoError(err)
+	r.EqualValues(expectedProof, proof)
+
+	// Hints are used as-is, so a bogus hint ends up in the proof.
+	bogus := NewNodeFromUint64(42)
+	_, _, proof, err = merkle.GenerateProofWithHints(leavesToProve, cacheReader,
+		map[position][]byte{hintPos: bogus})
+	r.NoError(err)
+	r.Equal(bogus, proof[2])
+
+	/***************************************************
+	|                       89a0                       |
+	|           ba94                   .633b.          |
+	|     cb59       .0094.       bd50        fa67     |
+	| =0000=.0100. 0200  0300  0400  0500  0600  0700  |
+	***************************************************/
+}
+
+func TestGenerateProofWithHintsBelowProofNode(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 1: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 20; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	_, _, expectedProof, err := merkle.GenerateProof(setOf(0), cacheReader)
+	r.NoError(err)
+
+	// The proof node <h: 4 i: 1> is calculated from nodes 8 and 9 of layer 1. With a hint for the ephemeral node
+	// <h: 2 i: 4>, which covers both of them, they aren't read.
+	hintPos := position{Height: 2, Index: 4}
+	hint, err := GetNode(cacheReader, hintPos)
+	r.NoError(err)
+	layer1 := &countingReader{LayerReadWriter: cacheReader.Layers()[1]}
+	cacheReader.Layers()[1] = layer1
+	_, _, proof, err := merkle.GenerateProofWithHints(setOf(0), cacheReader, map[position][]byte{hintPos: hint})
+	r.NoError(err)
+	r.Equal(expectedProof, proof)
+	withHint := layer1.reads
+
+	layer1.reads = 0
+	_, _, _, err = merkle.GenerateProofWithHints(setOf(0), cacheReader, nil)
+	r.NoError(err)
+	r.Less(withHint, layer1.reads)
+}
+
+func TestGenerateProofWithPositions(t *testing.T) {
+	r := require.New(t)
+
+	leavesToProve := setOf(0, 4, 7)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+
+	tree, _ := NewTreeBuilder().
+		WithCacheWriter(cacheWriter).
+		WithLeavesToProve(leavesToProve).
+		Build()
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		r.NoError(err)
+	}
+
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	sortedIndices, leaves, proofNodes, err := merkle.GenerateProofWithPositions(leavesToProve, cacheReader)
+	r.NoError(err)
+	r.EqualValues([]uint64{0, 4, 7}, sortedIndices)
+	r.EqualValues(nodes{NewNodeFromUint64(0), NewNodeFromUint64(4), NewNodeFromUint64(7)}, leaves)
+
+	expectedPositions := []position{
+		{Height: 0, Index: 1},
+		{Height: 1, Index: 1},
+		{Height: 0, Index: 5},
+		{Height: 0, Index: 6},
+	}
+	expectedProof := tree.Proof()
+	r.Len(proofNodes, len(expectedPositions))
+	for i, n := range proofNodes {
+		r.Equal(expectedPositions[i], n.Pos)
+		r.Equal(expectedProof[i], n.Value)
+	}
+
+	/***************************************************
+	|                       89a0                       |
+	|           ba94                    633b           |
+	|     cb59       .0094.       bd50        fa67     |
+	| =0000=.0100. 0200  0300 =0400=.0500..0600.=0700= |
+	***************************************************/
+}
+
+func TestProofOverhead(t *testing.T) {
+	r := require.New(t)
+	const width = 1 << 20
+
+	total, perLeaf := merkle.ProofOverhead(width, []uint64{12345}, merkle.NodeSize)
+	r.Equal(20*merkle.NodeSize, total)
+	r.Equal(float64(20*merkle.NodeSize), perLeaf)
+
+	// Leaves 0-7 share all ancestors above height 3, so they're proven by the 17 siblings of their common ancestor.
+	clustered := []uint64{0, 1, 2, 3, 4, 5, 6, 7}
+	r.Equal(17, merkle.ExpectedProofLength(width, clustered))
+	total, perLeaf = merkle.ProofOverhead(width, clustered, merkle.NodeSize)
+	r.Equal(17*merkle.NodeSize, total)
+	r.Equal(float64(17*merkle.NodeSize)/8, perLeaf)
+
+	// Leaves 0 and 2 share ancestors above height 1.
+	r.Equal(20, merkle.ExpectedProofLength(width, []uint64{2, 0, 2}))
+
+	total, perLeaf = merkle.ProofOverhead(width, nil, merkle.NodeSize)
+	r.Zero(total)
+	r.Zero(perLeaf)
+}
+
+func TestExpectedProofLength(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 10; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	for _, indices := range [][]uint64{{0}, {9}, {0, 9}, {3, 4, 5}} {
+		_, _, proof, err := merkle.GenerateProof(setOf(indices...), cacheReader)
+		r.NoError(err)
+		r.Equal(len(proof), merkle.ExpectedProofLength(10, indices), "indices: %v", indices)
+	}
+}
+
+func TestFindLeaf(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for _, word := range []string{"alpha", "Bravo", "charlie", "Delta"} {
+		leaf := make([]byte, merkle.NodeSize)
+		copy(leaf, word)
+		r.NoError(tree.AddLeaf(leaf))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	leaf := make([]byte, merkle.NodeSize)
+	copy(leaf, "CHARLIE")
+	_, found, err := merkle.FindLeaf(cacheReader, leaf, nil)
+	r.NoError(err)
+	r.False(found)
+
+	index, found, err := merkle.FindLeaf(cacheReader, leaf, bytes.EqualFold)
+	r.NoError(err)
+	r.True(found)
+	r.Equal(uint64(2), index)
+
+	// The proof is for the leaf as it's stored in the cache.
+	_, provenLeaves, proof, err := merkle.GenerateProof(setOf(index), cacheReader)
+	r.NoError(err)
+	r.True(bytes.EqualFold(leaf, provenLeaves[0]))
+	valid, err := merkle.ValidatePartialTree([]uint64{index}, provenLeaves, proof, tree.Root(), GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestFirstDifference(t *testing.T) {
+	r := require.New(t)
+
+	buildCache := func(width uint64, policy cache.CachingPolicy, differentLeaf uint64) CacheReader {
+		cacheWriter := cache.NewWriter(policy, cache.MakeSliceReadWriterFactory())
+		tree, err := NewCachingTree(cacheWriter)
+		r.NoError(err)
+		for i := uint64(0); i < width; i++ {
+			leaf := NewNodeFromUint64(i)
+			if i == differentLeaf {
+				leaf = NewNodeFromUint64(100 + i)
+			}
+			r.NoError(tree.AddLeaf(leaf))
+		}
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		return cacheReader
+	}
+
+	fullCache := cache.MinHeightPolicy(0)
+	baseOnlyCache := cache.SpecificLayersPolicy(map[uint]bool{0: true})
+	for _, policy := range []cache.CachingPolicy{fullCache, baseOnlyCache} {
+		for _, width := range []uint64{8, 10} {
+			a := buildCache(width, policy, width)
+			b := buildCache(width, policy, 5)
+
+			index, differs, err := merkle.FirstDifference(a, b)
+			r.NoError(err)
+			r.True(differs)
+			r.Equal(uint64(5), index)
+
+			index, differs, err = merkle.FirstDifference(a, a)
+			r.NoError(err)
+			r.False(differs)
+			r.Zero(index)
+		}
+	}
+
+	_, _, err := merkle.FirstDifference(buildCache(8, fullCache, 8), buildCache(9, fullCache, 9))
+	r.EqualError(err, "trees have different widths (8 and 9)")
+}
+
+func TestUpdateProof(t *testing.T) {
+	r := require.New(t)
+
+	buildTree := func(k, width uint64) ([]byte, [][]byte, CacheReader) {
+		cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).WithLeavesToProve(setOf(k)).Build()
+		r.NoError(err)
+		for i := uint64(0); i < width; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		root, proof := tree.RootAndProof()
+		return root, proof, cacheReader
+	}
+
+	tests := []struct {
+		k, m, n     uint64
+		changedPath []position
+	}{
+		{k: 0, m: 8, n: 9, changedPath: []position{{Height: 3, Index: 1}}},
+		{k: 4, m: 6, n: 7, changedPath: []position{{Height: 1, Index: 3}}},
+		{k: 0, m: 1, n: 3, changedPath: []position{{Height: 0, Index: 1}, {Height: 1, Index: 1}}},
+		{k: 1, m: 2, n: 2},
+		{k: 4, m: 5, n: 6, changedPath: []position{{Height: 0, Index: 5}}},
+	}
+	for _, tt := range tests {
+		_, oldProof, _ := buildTree(tt.k, tt.m)
+		newRoot, expectedProof, newCache := buildTree(tt.k, tt.n)
+
+		var newRightPath [][]byte
+		for _, pos := range tt.changedPath {
+			node, err := GetNode(newCache, pos)
+			r.NoError(err)
+			newRightPath = append(newRightPath, node)
+		}
+
+		newProof, err := merkle.UpdateProof(oldProof, tt.k, tt.m, tt.n, newRightPath, GetSha256Parent, nil)
+		r.NoError(err)
+		r.Equal(expectedProof, newProof, "k: %d, m: %d, n: %d", tt.k, tt.m, tt.n)
+
+		valid, err := ValidatePartialTree([]uint64{tt.k}, [][]byte{NewNodeFromUint64(tt.k)}, newProof, newRoot,
+			GetSha256Parent)
+		r.NoError(err)
+		r.True(valid, "Proof should be valid, but isn't")
+	}
+
+	_, oldProof, _ := buildTree(0, 8)
+	_, err := merkle.UpdateProof(oldProof, 0, 8, 9, nil, GetSha256Parent, nil)
+	r.EqualError(err, "missing new node for sibling at Position <h: 3 i: 1>")
+	_, err = merkle.UpdateProof(oldProof, 0, 8, 8, [][]byte{NewNodeFromUint64(0)}, GetSha256Parent, nil)
+	r.EqualError(err, "1 unused nodes in new right path")
+	_, err = merkle.UpdateProof(oldProof[1:], 0, 8, 9, nil, GetSha256Parent, nil)
+	r.EqualError(err, "proof for tree of size 8 must have 3 nodes, got 2")
+	_, err = merkle.UpdateProof(oldProof, 0, 8, 9, nil, nil, nil)
+	r.Error(err)
+}
+
+func TestUpdateProofWithPaddingValue(t *testing.T) {
+	r := require.New(t)
+	sentinel := bytes.Repeat([]byte{0xaa}, NodeSize)
+
+	buildTree := func(width uint64) ([]byte, [][]byte) {
+		tree, err := NewTreeBuilder().WithPaddingValue(sentinel).WithLeavesToProve(setOf(4)).Build()
+		r.NoError(err)
+		for i := uint64(0); i < width; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		return tree.RootAndProof()
+	}
+
+	_, oldProof := buildTree(5)
+	newRoot, expectedProof := buildTree(6)
+	r.Equal(sentinel, expectedProof[1])
+
+	newProof, err := merkle.UpdateProof(oldProof, 4, 5, 6, [][]byte{NewNodeFromUint64(5)}, GetSha256Parent, sentinel)
+	r.NoError(err)
+	r.Equal(expectedProof, newProof)
+
+	valid, err := ValidatePartialTree([]uint64{4}, [][]byte{NewNodeFromUint64(4)}, newProof, newRoot, GetSha256Parent,
+		merkle.ValidationPaddingValue(sentinel))
+	r.NoError(err)
+	r.True(valid, "Proof should be valid, but isn't")
+
+	newProof, err = merkle.UpdateProof(oldProof, 4, 5, 6, [][]byte{NewNodeFromUint64(5)}, GetSha256Parent, nil)
+	r.NoError(err)
+	r.NotEqual(expectedProof, newProof)
+}
+
 type nodes [][]byte
 
 func (n nodes) String() string {
@@ -482,6 +907,116 @@ func (r widthReader) Append(p []byte) (n int, err error) { panic("implement me")
 func (r widthReader) Flush() error                       { return nil }
 func (r widthReader) Close() error                       { return nil }
 
+// countingReader counts the nodes read from the wrapped layer.
+type countingReader struct {
+	cache.LayerReadWriter
+	reads int
+}
+
+func (r *countingReader) ReadNext() ([]byte, error) {
+	r.reads++
+	return r.LayerReadWriter.ReadNext()
+}
+
+func TestGenerateProofConcurrentClones(t *testing.T) {
+	r := require.New(t)
+
+	// Layer 1 isn't cached, so some proof nodes are calculated from the base layer.
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 2: true, 3: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 300; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	const goroutines = 8
+	type result struct {
+		leaves, proof [][]byte
+	}
+	expected := make([]result, goroutines)
+	for i := range expected {
+		_, leaves, proof, err := GenerateProof(setOf(uint64(i), uint64(i*37+5)), cacheReader)
+		r.NoError(err)
+		expected[i] = result{leaves, proof}
+	}
+
+	clone := cacheReader.(*cache.Reader).Clone()
+	results := make([]result, goroutines)
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		reader := clone.Clone()
+		go func(i int) {
+			for j := 0; j < 20; j++ {
+				_, leaves, proof, err := GenerateProof(setOf(uint64(i), uint64(i*37+5)), reader)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results[i] = result{leaves, proof}
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < goroutines; i++ {
+		r.NoError(<-errs)
+	}
+	r.Equal(expected, results)
+
+	_, err = clone.Layers()[0].Append(NewNodeFromUint64(0))
+	r.Error(err)
+}
+
+// cancelingReader cancels a context after the given number of nodes were read from the wrapped layer.
+type cancelingReader struct {
+	cache.LayerReadWriter
+	reads       int
+	cancelAfter int
+	cancel      context.CancelFunc
+}
+
+func (r *cancelingReader) ReadNext() ([]byte, error) {
+	r.reads++
+	if r.reads == r.cancelAfter {
+		r.cancel()
+	}
+	return r.LayerReadWriter.ReadNext()
+}
+
+func TestGenerateProofContext(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 1024; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	_, expectedLeaves, expectedProof, err := GenerateProof(setOf(3, 500), cacheReader)
+	r.NoError(err)
+	_, leaves, proof, err := merkle.GenerateProofContext(context.Background(), setOf(3, 500), cacheReader)
+	r.NoError(err)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+
+	// Cancel while the first subtree is being traversed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	baseLayer := &cancelingReader{LayerReadWriter: cacheReader.Layers()[0], cancelAfter: 2, cancel: cancel}
+	cacheReader.Layers()[0] = baseLayer
+	_, _, _, err = merkle.GenerateProofContext(ctx, setOf(3, 500), cacheReader)
+	r.ErrorIs(err, context.Canceled)
+	r.Equal(2, baseLayer.reads)
+
+	_, _, _, err = merkle.GenerateProofContext(ctx, setOf(3), cacheReader)
+	r.ErrorIs(err, context.Canceled)
+}
+
 func TestGetNode(t *testing.T) {
 	r := require.New(t)
 
@@ -539,7 +1074,7 @@ func TestGetNode4(t *testing.T) {
 	nodePos := position{Height: 2}
 	node, err := GetNode(cacheReader, nodePos)
 
-	r.EqualError(err, "while calculating ephemeral node at Position <h: 1 i: 1>: while seeking to Position <h: 0 i: 10> in cache: some error")
+	r.EqualError(err, "while calculating ephemeral node at Position <h: 1 i: 1>: while seeking to Position <h: 0 i: 2> in cache: some error")
 	r.Nil(node)
 }
 
@@ -558,6 +1093,179 @@ func TestGetNode5(t *testing.T) {
 	r.Nil(node)
 }
 
+func TestGetNodeTruncatedLayer(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 1: true, 3: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	// Truncate layer 1 after the cache was validated, as if its file was cut short.
+	layer := cacheReader.Layers()[1]
+	r.NoError(layer.Seek(0))
+	var truncated []byte
+	for i := 0; i < 3; i++ {
+		node, err := layer.ReadNext()
+		r.NoError(err)
+		truncated = append(truncated, node...)
+	}
+	cacheReader.Layers()[1] = readwriters.NewSliceReadWriter(truncated)
+
+	_, err = GetNode(cacheReader, position{Height: 1, Index: 3})
+	r.ErrorIs(err, merkle.ErrCorruptedCache)
+	r.EqualError(err, "corrupted cache: layer 1 has width 3 instead of 4")
+
+	_, err = GetNode(cacheReader, position{Height: 2, Index: 1})
+	r.ErrorIs(err, merkle.ErrCorruptedCache)
+
+	_, _, _, err = merkle.GenerateProof(setOf(7), cacheReader)
+	r.ErrorIs(err, merkle.ErrCorruptedCache)
+}
+
+// widthCountingLayer counts the calls to Width.
+type widthCountingLayer struct {
+	cache.LayerReadWriter
+	widthCalls int
+}
+
+func (l *widthCountingLayer) Width() (uint64, error) {
+	l.widthCalls++
+	return l.LayerReadWriter.Width()
+}
+
+func TestGetNodeCachedNoWidthCalls(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	layers := cacheReader.Layers()
+	counters := make(map[uint]*widthCountingLayer)
+	for height, layer := range layers {
+		counters[height] = &widthCountingLayer{LayerReadWriter: layer}
+		layers[height] = counters[height]
+	}
+
+	for height := uint(0); height < 3; height++ {
+		for index := uint64(0); index < 8>>height; index++ {
+			_, err := GetNode(cacheReader, position{Height: height, Index: index})
+			r.NoError(err)
+		}
+	}
+	for height, counter := range counters {
+		r.Zero(counter.widthCalls, "layer %d", height)
+	}
+}
+
+func TestGenerateProofWithMemo(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 2: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewCachingTree(cacheWriter)
+	r.NoError(err)
+	for i := uint64(0); i < 16; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	layer2 := &countingReader{LayerReadWriter: cacheReader.Layers()[2]}
+	cacheReader.Layers()[2] = layer2
+
+	// The proofs of leaves 0 and 1 share the siblings <h: 2 i: 1>, which is read from layer 2, and <h: 3 i: 1>,
+	// which is calculated from two nodes of layer 2.
+	memo := merkle.NewNodeMemo()
+	for _, leaf := range []uint64{0, 1} {
+		_, expectedLeaves, expectedProof, err := merkle.GenerateProof(setOf(leaf), cacheReader)
+		r.NoError(err)
+		layer2.reads = 0
+
+		_, leaves, proof, err := merkle.GenerateProofWithMemo(setOf(leaf), cacheReader, memo)
+		r.NoError(err)
+		r.Equal(expectedLeaves, leaves)
+		r.Equal(expectedProof, proof)
+		if leaf == 0 {
+			r.Equal(3, layer2.reads)
+		} else {
+			r.Zero(layer2.reads)
+		}
+	}
+}
+
+func TestNodeMemoNestedPositions(t *testing.T) {
+	r := require.New(t)
+
+	for _, width := range []uint64{33, 50, 64, 70} {
+		cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true}),
+			cache.MakeSliceReadWriterFactory())
+		tree, err := NewCachingTree(cacheWriter)
+		r.NoError(err)
+		for i := uint64(0); i < width; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		baseLayer := &countingReader{LayerReadWriter: cacheReader.Layers()[0]}
+		cacheReader.Layers()[0] = baseLayer
+
+		// Calculating the root remembers the nodes it's calculated from, down to 3 layers above the base layer.
+		memo := merkle.NewNodeMemo()
+		rootHeight := merkle.RootHeightFromWidth(width)
+		root, err := memo.GetNode(cacheReader, position{Height: rootHeight})
+		r.NoError(err)
+		r.Equal(tree.Root(), root)
+		baseLayer.reads = 0
+		nested := make(map[position][]byte)
+		for height := uint(3); height <= rootHeight; height++ {
+			for index := uint64(0); index<<height < width; index++ {
+				pos := position{Height: height, Index: index}
+				nested[pos], err = memo.GetNode(cacheReader, pos)
+				r.NoError(err)
+			}
+		}
+		r.Zero(baseLayer.reads, "width %d: nested nodes were recalculated", width)
+		for pos, node := range nested {
+			expected, err := GetNode(cacheReader, pos)
+			r.NoError(err)
+			r.Equal(expected, node, "width %d, Position %s", width, pos)
+		}
+	}
+}
+
+func TestGetNodeRoot(t *testing.T) {
+	r := require.New(t)
+
+	fullCache := cache.MinHeightPolicy(0)
+	baseOnlyCache := cache.SpecificLayersPolicy(map[uint]bool{0: true})
+	for _, policy := range []cache.CachingPolicy{fullCache, baseOnlyCache} {
+		for _, width := range []uint64{8, 10} {
+			cacheWriter := cache.NewWriter(policy, cache.MakeSliceReadWriterFactory())
+			tree, err := NewCachingTree(cacheWriter)
+			r.NoError(err)
+			for i := uint64(0); i < width; i++ {
+				r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+			}
+			cacheReader, err := cacheWriter.GetReader()
+			r.NoError(err)
+
+			root, err := GetNode(cacheReader, position{Height: merkle.RootHeightFromWidth(width)})
+			r.NoError(err)
+			r.Equal(tree.Root(), root, "width: %d", width)
+		}
+	}
+}
+
 func TestCache_ValidateStructure(t *testing.T) {
 	r := require.New(t)
 	cacheWriter := cache.NewWriter(nil, nil)
@@ -566,3 +1274,257 @@ func TestCache_ValidateStructure(t *testing.T) {
 	r.EqualError(err, "reader for base layer must be included")
 	r.Nil(cacheReader)
 }
+
+type recordingMetrics struct {
+	cacheHits map[uint]int
+	nodeReads []merkle.Position
+	hashes    int
+}
+
+func (m *recordingMetrics) OnCacheHit(height uint) {
+	if m.cacheHits == nil {
+		m.cacheHits = make(map[uint]int)
+	}
+	m.cacheHits[height]++
+}
+
+func (m *recordingMetrics) OnNodeRead(pos merkle.Position) { m.nodeReads = append(m.nodeReads, pos) }
+
+func (m *recordingMetrics) OnHash() { m.hashes++ }
+
+func TestGenerateProofMetrics(t *testing.T) {
+	r := require.New(t)
+
+	leavesToProve := setOf(0, 4, 7)
+	buildMetrics := &recordingMetrics{}
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().
+		WithCacheWriter(cacheWriter).
+		WithMetrics(buildMetrics).
+		Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	r.Equal(7, buildMetrics.hashes)
+
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	expectedIndices, expectedLeaves, expectedProof, err := GenerateProof(leavesToProve, cacheReader)
+	r.NoError(err)
+
+	// With all layers cached, each proven leaf's subtree is the pair of leaves below a cached node, so one hash is
+	// calculated per proven leaf, and the only proof node that's read from the cache is at Position 1-1.
+	metrics := &recordingMetrics{}
+	indices, leaves, proof, err := GenerateProof(leavesToProve, merkle.CacheReaderWithMetrics(cacheReader, metrics))
+	r.NoError(err)
+	r.Equal(expectedIndices, indices)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+	r.Equal(3, metrics.hashes)
+	r.Equal(map[uint]int{1: 1}, metrics.cacheHits)
+	r.Equal([]merkle.Position{
+		{Index: 0}, {Index: 1}, {Index: 1, Height: 1}, {Index: 4}, {Index: 5}, {Index: 6}, {Index: 7},
+	}, metrics.nodeReads)
+
+	// With only the base layer cached, the whole tree is recalculated from the leaves.
+	cacheWriter = cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true}), cache.MakeSliceReadWriterFactory())
+	tree, err = NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err = cacheWriter.GetReader()
+	r.NoError(err)
+	metrics = &recordingMetrics{}
+	_, _, proof, err = GenerateProof(leavesToProve, merkle.CacheReaderWithMetrics(cacheReader, metrics))
+	r.NoError(err)
+	r.Equal(expectedProof, proof)
+	r.Equal(7, metrics.hashes)
+	r.Empty(metrics.cacheHits)
+	r.Len(metrics.nodeReads, 8)
+}
+
+func TestGenerateRangeProof(t *testing.T) {
+	r := require.New(t)
+
+	const width = 11
+	cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true, 2: true}),
+		cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+	for i := uint64(0); i < width; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+
+	for _, tc := range []struct {
+		name        string
+		start, end  uint64
+		proofLength int
+	}{
+		{"inside", 2, 6, 3},           // Siblings at 1-0, 1-3 and 3-1.
+		{"padded boundary", 8, 11, 3}, // Padding at 0-11 and 2-3, and the sibling at 3-0.
+		{"whole tree", 0, width, 2},   // Only padding at 0-11 and 2-3.
+		{"single leaf", 10, width, 4}, // A full single-leaf proof.
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := require.New(t)
+			leaves, proof, err := merkle.GenerateRangeProof(tc.start, tc.end, cacheReader)
+			r.NoError(err)
+			r.Len(proof, tc.proofLength)
+			var indices []uint64
+			for i := tc.start; i < tc.end; i++ {
+				r.Equal(NewNodeFromUint64(i), leaves[i-tc.start])
+				indices = append(indices, i)
+			}
+			r.Equal(merkle.ExpectedProofLength(width, indices), len(proof))
+
+			valid, err := merkle.ValidateRangeProof(tc.start, tc.end, leaves, proof, root, GetSha256Parent)
+			r.NoError(err)
+			r.True(valid)
+
+			leaves[0] = NewNodeFromUint64(100)
+			valid, err = merkle.ValidateRangeProof(tc.start, tc.end, leaves, proof, root, GetSha256Parent)
+			r.NoError(err)
+			r.False(valid)
+		})
+	}
+
+	_, _, err = merkle.GenerateRangeProof(3, 3, cacheReader)
+	r.EqualError(err, "invalid range [3, 3) for tree of width 11")
+	_, _, err = merkle.GenerateRangeProof(3, 12, cacheReader)
+	r.EqualError(err, "invalid range [3, 12) for tree of width 11")
+	_, err = merkle.ValidateRangeProof(3, 5, [][]byte{NewNodeFromUint64(3)}, nil, root, GetSha256Parent)
+	r.EqualError(err, "range [3, 5) has 2 leaves, got 1")
+}
+
+func TestGenerateProofEveryNthLayerPolicy(t *testing.T) {
+	r := require.New(t)
+
+	buildCache := func(policy cache.CachingPolicy) CacheReader {
+		cacheWriter := cache.NewWriter(policy, cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+		r.NoError(err)
+		for i := uint64(0); i < 16; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		return cacheReader
+	}
+	sparseCache := buildCache(cache.EveryNthLayerPolicy(2))
+	fullCache := buildCache(cache.MinHeightPolicy(0))
+	for height := uint(0); height <= 4; height++ {
+		r.Equal(height%2 == 0, sparseCache.GetLayerReader(height) != nil, "layer %d", height)
+	}
+
+	// Proof nodes on layers 1 and 3 aren't cached, so they're calculated from the layer below.
+	leavesToProve := setOf(1, 6, 13)
+	_, expectedLeaves, expectedProof, err := GenerateProof(leavesToProve, fullCache)
+	r.NoError(err)
+	_, leaves, proof, err := GenerateProof(leavesToProve, sparseCache)
+	r.NoError(err)
+	r.Equal(expectedLeaves, leaves)
+	r.Equal(expectedProof, proof)
+}
+
+func TestGenerateProofTopLayersPolicy(t *testing.T) {
+	r := require.New(t)
+
+	cacheWriter := cache.NewWriterWithWidthPolicy(cache.TopLayersPolicy(3), cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+	r.NoError(err)
+	for i := uint64(0); i < 32; i++ {
+		r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	cacheReader, err := cacheWriter.GetReader()
+	r.NoError(err)
+	for height := uint(0); height <= 5; height++ {
+		r.Equal(height == 0 || height >= 3, cacheReader.GetLayerReader(height) != nil, "layer %d", height)
+	}
+	root, err := GetNode(cacheReader, merkle.Position{Height: 5})
+	r.NoError(err)
+	r.Equal(tree.Root(), root)
+
+	leavesToProve := setOf(2, 17, 31)
+	indices, leaves, proof, err := GenerateProof(leavesToProve, cacheReader)
+	r.NoError(err)
+	valid, err := ValidatePartialTree(indices, leaves, proof, tree.Root(), GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestGenerateProofPadToPowerOfTwoCache(t *testing.T) {
+	r := require.New(t)
+
+	build := func(width uint64) CacheReader {
+		cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(map[uint]bool{0: true}),
+			cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).WithPadToPowerOfTwo().Build()
+		r.NoError(err)
+		for i := uint64(0); i < width; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+		return cacheReader
+	}
+
+	// Unbalanced trees would be padded differently than the tree pads itself.
+	unbalanced := build(10)
+	_, _, _, err := GenerateProof(setOf(3), unbalanced)
+	r.ErrorIs(err, merkle.ErrPadToPowerOfTwoCache)
+	_, err = GetNode(unbalanced, merkle.Position{Index: 1, Height: 3})
+	r.ErrorIs(err, merkle.ErrPadToPowerOfTwoCache)
+	_, err = GetNode(unbalanced, merkle.Position{Index: 0, Height: 3})
+	r.NoError(err)
+
+	// Balanced trees need no padding.
+	balanced := build(8)
+	_, leaves, proof, err := GenerateProof(setOf(3), balanced)
+	r.NoError(err)
+	root, err := GetNode(balanced, merkle.Position{Height: 3})
+	r.NoError(err)
+	valid, err := ValidatePartialTree([]uint64{3}, leaves, proof, root, GetSha256Parent)
+	r.NoError(err)
+	r.True(valid)
+}
+
+func TestGenerateProofPaddingValueCache(t *testing.T) {
+	r := require.New(t)
+
+	sentinel := bytes.Repeat([]byte{0xff}, NodeSize)
+	leafIndices := []uint64{3, 9}
+	for _, layers := range []map[uint]bool{{0: true}, {0: true, 1: true}, {0: true, 2: true}} {
+		cacheWriter := cache.NewWriter(cache.SpecificLayersPolicy(layers), cache.MakeSliceReadWriterFactory())
+		tree, err := NewTreeBuilder().
+			WithCacheWriter(cacheWriter).
+			WithPaddingValue(sentinel).
+			WithLeavesToProve(setOf(leafIndices...)).
+			Build()
+		r.NoError(err)
+		for i := uint64(0); i < 10; i++ {
+			r.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		expectedRoot, expectedProof := tree.RootAndProof()
+		cacheReader, err := cacheWriter.GetReader()
+		r.NoError(err)
+
+		_, leaves, proof, err := GenerateProof(setOf(leafIndices...), cacheReader)
+		r.NoError(err)
+		r.Equal(expectedProof, proof)
+		root, err := GetNode(cacheReader, merkle.Position{Height: 4})
+		r.NoError(err)
+		r.Equal(expectedRoot, root)
+		valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent)
+		r.NoError(err)
+		r.True(valid)
+
+		padding, err := GetNode(cacheReader, merkle.Position{Index: 3, Height: 2})
+		r.NoError(err)
+		r.Equal(sentinel, padding)
+	}
+}
@@ -0,0 +1,41 @@
+package merkle
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Sha256HashName is the registered name of GetSha256Parent.
+const Sha256HashName = "sha256"
+
+var hashRegistry = struct {
+	sync.RWMutex
+	funcs map[string]HashFunc
+}{
+	funcs: map[string]HashFunc{
+		Sha256HashName: GetSha256Parent,
+	},
+}
+
+// RegisterHashFunc makes a hash function available by name, so that proofs referring to it by name can be verified.
+// Registering the same name twice returns an error.
+func RegisterHashFunc(name string, hash HashFunc) error {
+	if hash == nil {
+		return fmt.Errorf("cannot register nil hash function %q", name)
+	}
+	hashRegistry.Lock()
+	defer hashRegistry.Unlock()
+	if _, found := hashRegistry.funcs[name]; found {
+		return fmt.Errorf("hash function %q is already registered", name)
+	}
+	hashRegistry.funcs[name] = hash
+	return nil
+}
+
+// HashFuncByName returns the hash function registered under name.
+func HashFuncByName(name string) (HashFunc, bool) {
+	hashRegistry.RLock()
+	defer hashRegistry.RUnlock()
+	hash, found := hashRegistry.funcs[name]
+	return hash, found
+}
@@ -0,0 +1,126 @@
+package merkle
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// SlidingRoot calculates the root of the last window leaves every time a leaf is pushed, as if the leaves in the
+// window were added to a new Tree.
+//
+// For every height up to the window's, it keeps the nodes of all aligned subtrees that end at recently pushed leaves,
+// keyed by the index of their first leaf. Pushing a leaf calculates the one new node per height that ends at it, and
+// the root of the window is then folded from at most log(window) of those nodes with the same parking and padding
+// logic as Tree. This costs O(log(window)) hashes per leaf, compared to O(window) for rebuilding the tree, and keeps
+// up to O(window*log(window)) nodes in memory.
+//
+// SlidingRoot is NOT thread safe.
+type SlidingRoot struct {
+	window uint64
+	hash   HashFunc
+	pushed uint64
+	layers [][][]byte // Ring buffers of subtree roots by height, indexed by first leaf modulo the buffer size.
+	pieces []uint     // Heights of the subtrees that make up a window, from left to right.
+
+	firsts  []uint64  // The first leaves of the subtrees that make up the current window. Reused by fold.
+	scratch [2][]byte // Buffers for the nodes hashed by fold.
+}
+
+// NewSlidingRoot returns a SlidingRoot for windows of the given number of leaves.
+func NewSlidingRoot(window uint64, hash HashFunc) (*SlidingRoot, error) {
+	if window == 0 {
+		return nil, errors.New("window must contain at least one leaf")
+	}
+	if hash == nil {
+		hash = GetSha256Parent
+	}
+	maxHeight := uint(bits.Len64(window) - 1)
+	sr := &SlidingRoot{
+		window: window,
+		hash:   hash,
+		layers: make([][][]byte, maxHeight+1),
+	}
+	// A node at height h is needed for 2^h+1 pushes to calculate its parent. Nodes that are part of the window's
+	// decomposition are needed until the window's last leaf is pushed.
+	sizes := make([]uint64, maxHeight+1)
+	for h := range sizes {
+		sizes[h] = 1<<h + 1
+	}
+	var offset uint64
+	for h := int(maxHeight); h >= 0; h-- {
+		if window&(1<<h) == 0 {
+			continue
+		}
+		sr.pieces = append(sr.pieces, uint(h))
+		offset += 1 << h
+		if lag := window - offset + 1; lag > sizes[h] {
+			sizes[h] = lag
+		}
+	}
+	for h, size := range sizes {
+		sr.layers[h] = make([][]byte, size)
+	}
+	return sr, nil
+}
+
+// Push adds a leaf and returns the root of the last window leaves. ready is false, and root is nil, until at least
+// window leaves were pushed. The root is only valid until the next call to Push, since its buffer is reused. Push
+// reuses the buffers of the nodes it no longer needs, so it doesn't allocate once the buffers are full, unless the hash
+// function does.
+func (sr *SlidingRoot) Push(leaf []byte) (root []byte, ready bool) {
+	p := sr.pushed
+	sr.set(0, p, append(sr.get(0, p)[:0], leaf...))
+	for h := uint(1); h < uint(len(sr.layers)) && p+1 >= 1<<h; h++ {
+		first := p + 1 - 1<<h
+		sr.set(h, first, sr.hash(sr.get(h, first)[:0], sr.get(h-1, first), sr.get(h-1, first+1<<(h-1))))
+	}
+	sr.pushed++
+	if sr.pushed < sr.window {
+		return nil, false
+	}
+	return sr.fold(), true
+}
+
+// fold calculates the root of the window from the subtrees that make it up, like Tree.Root: the smallest subtree is
+// the ephemeral node, which is hashed with the subtree parked at each height above it, or with padding where there's
+// none. The nodes are hashed into two alternating scratch buffers, so no hash reads from the buffer it writes to.
+func (sr *SlidingRoot) fold() []byte {
+	firsts := sr.firsts[:0]
+	first := sr.pushed - sr.window
+	for _, h := range sr.pieces {
+		firsts = append(firsts, first)
+		first += 1 << h
+	}
+	sr.firsts = firsts
+
+	last := len(sr.pieces) - 1
+	ephemeral := sr.get(sr.pieces[last], firsts[last])
+	if last == 0 {
+		sr.scratch[0] = append(sr.scratch[0][:0], ephemeral...)
+		return sr.scratch[0]
+	}
+	piece := last - 1
+	for h, i := sr.pieces[last], 0; h <= sr.pieces[0]; h, i = h+1, i^1 {
+		if sr.pieces[piece] == h {
+			ephemeral = sr.hash(sr.scratch[i][:0], sr.get(h, firsts[piece]), ephemeral)
+			piece--
+		} else {
+			ephemeral = sr.hash(sr.scratch[i][:0], ephemeral, PaddingValue.value)
+		}
+		sr.scratch[i] = ephemeral
+		if piece < 0 {
+			break
+		}
+	}
+	return ephemeral
+}
+
+func (sr *SlidingRoot) get(height uint, first uint64) []byte {
+	layer := sr.layers[height]
+	return layer[first%uint64(len(layer))]
+}
+
+func (sr *SlidingRoot) set(height uint, first uint64, value []byte) {
+	layer := sr.layers[height]
+	layer[first%uint64(len(layer))] = value
+}
@@ -0,0 +1,61 @@
+package merkle_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/spacemeshos/merkle-tree"
+)
+
+func TestSlidingRoot(t *testing.T) {
+	r := require.New(t)
+
+	for _, window := range []uint64{1, 2, 3, 7, 8, 10, 13} {
+		sr, err := merkle.NewSlidingRoot(window, GetSha256Parent)
+		r.NoError(err)
+		for i := uint64(0); i < 40; i++ {
+			root, ready := sr.Push(NewNodeFromUint64(i))
+			if i+1 < window {
+				r.False(ready)
+				r.Nil(root)
+				continue
+			}
+			r.True(ready)
+
+			tree, err := NewTree()
+			r.NoError(err)
+			for j := i + 1 - window; j <= i; j++ {
+				r.NoError(tree.AddLeaf(NewNodeFromUint64(j)))
+			}
+			r.Equal(tree.Root(), root, "window: %d, last leaf: %d", window, i)
+		}
+	}
+
+	_, err := merkle.NewSlidingRoot(0, GetSha256Parent)
+	r.EqualError(err, "window must contain at least one leaf")
+}
+
+func TestSlidingRootPushDoesNotAllocate(t *testing.T) {
+	r := require.New(t)
+
+	// Unlike GetSha256Parent, this hash function doesn't allocate when buf has room for the parent.
+	xorHash := func(buf, lChild, rChild []byte) []byte {
+		buf = append(buf, lChild...)
+		for i := range rChild {
+			buf[i] ^= rChild[i] + 1
+		}
+		return buf
+	}
+	sr, err := merkle.NewSlidingRoot(13, xorHash)
+	r.NoError(err)
+	leaf := NewNodeFromUint64(7)
+	for i := 0; i < 100; i++ {
+		sr.Push(leaf)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		_, ready := sr.Push(leaf)
+		r.True(ready)
+	})
+	r.Zero(allocs)
+}
@@ -0,0 +1,140 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// stateFormatVersion is the version of the format written by Tree.SaveState.
+const stateFormatVersion = 1
+
+// Flags of a layer in a saved state.
+const (
+	stateLayerParked       = 1 << iota // The layer has a parked node.
+	stateLayerOnProvenPath             // The parked node is an ancestor of a leaf to prove.
+)
+
+// SaveState serializes the state of the tree, so a process can checkpoint a tree that's being built and continue
+// building it after a restart with TreeBuilder.WithSavedState. Unlike GetParkedNodes, the state includes the leaf
+// count, the min height, the leaves that remain to be proven and the proof collected so far. The hash function, cache
+// writer and other options aren't included and must be configured again when resuming.
+//
+// The state starts with a version byte, followed by the leaf count and min height (uvarints), the parked nodes (a
+// uvarint count of layers, followed by a flags byte per layer and, for layers with a parked node, its uvarint length
+// and value), the leaves to prove (a uvarint count followed by uvarint indices) and the proof (a uvarint count followed
+// by the uvarint length and value of every node).
+func (t *Tree) SaveState() ([]byte, error) {
+	buf := []byte{stateFormatVersion}
+	buf = binary.AppendUvarint(buf, t.leafCount)
+	buf = binary.AppendUvarint(buf, uint64(t.minHeight))
+	top := t.topLayer()
+	numLayers := top.height + 1
+	if top == t.baseLayer && top.parking.IsEmpty() {
+		numLayers = 0
+	}
+	buf = binary.AppendUvarint(buf, uint64(numLayers))
+	for l := t.baseLayer; l != nil && l.height < numLayers; l = l.next {
+		var flags byte
+		if !l.parking.IsEmpty() {
+			flags |= stateLayerParked
+			if l.parking.OnProvenPath {
+				flags |= stateLayerOnProvenPath
+			}
+		}
+		buf = append(buf, flags)
+		if flags&stateLayerParked != 0 {
+			buf = binary.AppendUvarint(buf, uint64(len(l.parking.value)))
+			buf = append(buf, l.parking.value...)
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.leavesToProve.sortedTrueIndices)))
+	for _, index := range t.leavesToProve.sortedTrueIndices {
+		buf = binary.AppendUvarint(buf, index)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(t.proof)))
+	for _, n := range t.proof {
+		buf = binary.AppendUvarint(buf, uint64(len(n)))
+		buf = append(buf, n...)
+	}
+	return buf, nil
+}
+
+var errTruncatedState = errors.New("truncated tree state")
+
+// restoreState restores a state serialized with SaveState. See TreeBuilder.WithSavedState.
+func (t *Tree) restoreState(state []byte) error {
+	if len(state) == 0 {
+		return errTruncatedState
+	}
+	if state[0] != stateFormatVersion {
+		return fmt.Errorf("unsupported tree state format version %d", state[0])
+	}
+	d := proofDecoder{data: state[1:]}
+	leafCount := d.uvarint()
+	minHeight := d.uvarint()
+	numLayers := d.count(1)
+	parked := make([]node, 0, numLayers)
+	for i := uint64(0); i < numLayers && d.err == nil; i++ {
+		flags := d.bytes(1)
+		if d.err != nil {
+			break
+		}
+		var n node
+		if flags[0]&stateLayerParked != 0 {
+			n.value = d.bytes(d.uvarint())
+			n.OnProvenPath = flags[0]&stateLayerOnProvenPath != 0
+		}
+		parked = append(parked, n)
+	}
+	var leavesToProve []uint64
+	numLeavesToProve := d.count(1)
+	for i := uint64(0); i < numLeavesToProve && d.err == nil; i++ {
+		leavesToProve = append(leavesToProve, d.uvarint())
+	}
+	var proof [][]byte
+	proofLength := d.count(1)
+	for i := uint64(0); i < proofLength && d.err == nil; i++ {
+		proof = append(proof, append([]byte(nil), d.bytes(d.uvarint())...))
+	}
+	if d.err != nil {
+		return errTruncatedState
+	}
+	if len(d.data) > 0 {
+		return fmt.Errorf("%d unexpected trailing bytes after tree state", len(d.data))
+	}
+	if minHeight > 64 {
+		return fmt.Errorf("invalid min height %d", minHeight)
+	}
+	for height, n := range parked {
+		if isParked := height < 64 && leafCount>>height&1 == 1; isParked != !n.IsEmpty() {
+			return fmt.Errorf("parked nodes don't match %d leaves at layer %d", leafCount, height)
+		}
+	}
+	if len(parked) < bits.Len64(leafCount) {
+		return fmt.Errorf("%d parked nodes are too few for %d leaves", len(parked), leafCount)
+	}
+	for i, index := range leavesToProve {
+		if index < leafCount || (i > 0 && index <= leavesToProve[i-1]) {
+			return fmt.Errorf("invalid leaf to prove %d", index)
+		}
+	}
+
+	l := t.baseLayer
+	for height, n := range parked {
+		l.parking.value = append(l.parking.value[:0], n.value...)
+		l.parking.OnProvenPath = n.OnProvenPath
+		if height < len(parked)-1 {
+			if err := l.ensureNextLayerExists(t.cacheWriter); err != nil {
+				return err
+			}
+			l = l.next
+		}
+	}
+	t.leafCount = leafCount
+	t.minHeight = uint(minHeight)
+	t.leavesToProve = &sparseBoolStack{sortedTrueIndices: leavesToProve, currentIndex: leafCount}
+	t.proof = proof
+	return nil
+}
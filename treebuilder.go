@@ -1,10 +1,61 @@
 package merkle
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrCacheHashMismatch is returned when the cache writer already has a hash name that differs from the one of the tree
+// being built, or an unnamed hash function that differs from the tree's. See TreeBuilder.WithHashName.
+var ErrCacheHashMismatch = errors.New("cache writer hash function differs from tree hash function")
+
+// hashNamer is implemented by cache writers that record the name of the hash function of the tree, like cache.Writer.
+// It's optional, so that CacheWriter implementations outside this module keep working.
+type hashNamer interface {
+	HashName() string
+	SetHashName(name string)
+}
+
+// hashGetter is implemented by cache writers that return the hash function set on them, like cache.Writer.
+type hashGetter interface {
+	GetHashFunc() HashFunc
+}
+
+// powerOfTwoPadder is implemented by cache writers that record whether the tree that writes to them pads to a power of
+// two, like cache.Writer, so proof generation can reject caches it doesn't support.
+type powerOfTwoPadder interface {
+	SetPadToPowerOfTwo(padToPowerOfTwo bool)
+}
+
+// paddingRecorder is implemented by cache writers that record the padding value of the tree that writes to them, like
+// cache.Writer, so GenerateProof and GetNode pad the cache the same way.
+type paddingRecorder interface {
+	SetPaddingValue(padding []byte)
+}
+
 type TreeBuilder struct {
-	hash           HashFunc
-	leavesToProves Set
-	cacheWriter    CacheWriter
-	minHeight      uint
+	hash            HashFunc
+	hashName        string
+	leavesToProves  Set
+	cacheWriter     CacheWriter
+	minHeight       uint
+	padToPowerOfTwo bool
+	leafObserver    func(index uint64, leaf []byte)
+	provePredicate  func(index uint64, leaf []byte) bool
+	noPadding       bool
+	resumeParked    [][]byte
+	resumeLeafCount uint64
+	savedState      []byte
+	nodeSize        uint
+	leafHash        LeafHashFunc
+	sortedSiblings  bool
+	metrics         Metrics
+	nodePool        *sync.Pool
+
+	rejectPaddingLeaves bool
+	paddingValue        []byte
 }
 
 func NewTreeBuilder() TreeBuilder {
@@ -13,30 +64,138 @@ func NewTreeBuilder() TreeBuilder {
 
 func (tb TreeBuilder) Build() (*Tree, error) {
 	if tb.hash == nil {
-		tb.hash = GetSha256Parent
+		if tb.hashName == "" {
+			tb.hashName = Sha256HashName
+		}
+		hash, found := HashFuncByName(tb.hashName)
+		if !found {
+			return &Tree{}, fmt.Errorf("hash function %q isn't registered", tb.hashName)
+		}
+		tb.hash = hash
+	}
+	if tb.sortedSiblings {
+		tb.hash = SortedPairHashFunc(tb.hash)
+		if tb.hashName != "" {
+			tb.hashName += "/sorted"
+		}
 	}
+	// Leaf sizes are checked when the node size is set explicitly, or when leaves are cached, since caches store
+	// fixed-size nodes. Without either, custom hash functions may use nodes of any size.
+	_, cacheDisabled := tb.cacheWriter.(disabledCacheWriter)
+	checkLeafSize := tb.nodeSize != 0 || (tb.cacheWriter != nil && !cacheDisabled)
 	if tb.cacheWriter == nil {
 		tb.cacheWriter = disabledCacheWriter{}
 	}
+	if tb.metrics == nil {
+		tb.metrics = NoopMetrics{}
+	}
+	cacheHashName := ""
+	namer, hasNamer := tb.cacheWriter.(hashNamer)
+	if hasNamer {
+		cacheHashName = namer.HashName()
+	}
+	if cacheHashName != "" && cacheHashName != tb.hashName {
+		return &Tree{}, fmt.Errorf("%w: cache uses %q, tree uses %q", ErrCacheHashMismatch, cacheHashName,
+			tb.hashName)
+	}
+	if getter, ok := tb.cacheWriter.(hashGetter); ok && cacheHashName == "" {
+		if cacheHash := getter.GetHashFunc(); cacheHash != nil && !sameHashOutput(cacheHash, tb.hash, tb.nodeSize) {
+			return &Tree{}, fmt.Errorf("%w: cache uses an unnamed hash function", ErrCacheHashMismatch)
+		}
+	}
+	if hasNamer {
+		namer.SetHashName(tb.hashName)
+	}
 	tb.cacheWriter.SetHash(tb.hash)
+	if padder, ok := tb.cacheWriter.(powerOfTwoPadder); ok {
+		padder.SetPadToPowerOfTwo(tb.padToPowerOfTwo)
+	}
 	writer, err := tb.cacheWriter.GetLayerWriter(0)
 	if err != nil {
 		return &Tree{}, err
 	}
-	return &Tree{
-		baseLayer:     newLayer(0, writer),
-		hash:          tb.hash,
-		leavesToProve: NewSparseBoolStack(tb.leavesToProves),
-		cacheWriter:   tb.cacheWriter,
-		minHeight:     tb.minHeight,
-	}, nil
+	padding := PaddingValue
+	if tb.paddingValue != nil {
+		if tb.nodeSize != 0 && uint(len(tb.paddingValue)) != tb.nodeSize {
+			return &Tree{}, fmt.Errorf("padding value has size %d instead of %d", len(tb.paddingValue), tb.nodeSize)
+		}
+		padding = node{value: append([]byte(nil), tb.paddingValue...)}
+	} else if tb.nodeSize != 0 && tb.nodeSize != NodeSize {
+		padding = node{value: make([]byte, tb.nodeSize)}
+	}
+	if recorder, ok := tb.cacheWriter.(paddingRecorder); ok {
+		if tb.paddingValue != nil {
+			recorder.SetPaddingValue(padding.value)
+		} else {
+			recorder.SetPaddingValue(nil)
+		}
+	}
+	t := &Tree{
+		baseLayer:       newLayer(0, writer),
+		hash:            tb.hash,
+		leavesToProve:   NewSparseBoolStack(tb.leavesToProves),
+		cacheWriter:     tb.cacheWriter,
+		minHeight:       tb.minHeight,
+		padToPowerOfTwo: tb.padToPowerOfTwo,
+		leafObserver:    tb.leafObserver,
+		provePredicate:  tb.provePredicate,
+		noPadding:       tb.noPadding,
+		padding:         padding,
+		leafHash:        tb.leafHash,
+		metrics:         tb.metrics,
+		nodePool:        tb.nodePool,
+
+		rejectPaddingLeaves: tb.rejectPaddingLeaves,
+		checkLeafSize:       checkLeafSize,
+	}
+	if tb.resumeLeafCount > 0 {
+		if err := t.resume(tb.resumeParked, tb.resumeLeafCount); err != nil {
+			return &Tree{}, err
+		}
+	}
+	if tb.savedState != nil {
+		if tb.resumeLeafCount > 0 || len(tb.leavesToProves) > 0 {
+			return &Tree{}, errors.New("a saved state can't be combined with WithResumeState or WithLeavesToProve")
+		}
+		if err := t.restoreState(tb.savedState); err != nil {
+			return &Tree{}, fmt.Errorf("while restoring saved state: %w", err)
+		}
+	}
+	return t, nil
 }
 
+// sameHashOutput reports whether a and b hash the same pair of probe nodes of the given size (or NodeSize, if 0) to the
+// same parent. Func values can't be compared, so this is how unnamed hash functions are matched: different hash
+// functions are told apart, while wrappers of the same function aren't rejected.
+func sameHashOutput(a, b HashFunc, nodeSize uint) bool {
+	if nodeSize == 0 {
+		nodeSize = NodeSize
+	}
+	lChild, rChild := make([]byte, nodeSize), make([]byte, nodeSize)
+	for i := range rChild {
+		lChild[i], rChild[i] = byte(i), byte(255-i)
+	}
+	return bytes.Equal(a(nil, lChild, rChild), b(nil, lChild, rChild))
+}
+
+// WithHashFunc sets the hash function of the tree. The function has no name unless WithHashName is also used, so a
+// cache writer that recorded the name of another hash function is rejected with ErrCacheHashMismatch. A cache writer
+// with an unnamed hash function is rejected if that function hashes differently.
 func (tb TreeBuilder) WithHashFunc(hash HashFunc) TreeBuilder {
 	tb.hash = hash
 	return tb
 }
 
+// WithHashName names the hash function of the tree. Func values can't be compared, so names are how a reused cache
+// writer is matched to the hash function it was built with: the name is recorded on cache writers that support it,
+// like cache.Writer, and Build returns ErrCacheHashMismatch if the writer already recorded a different name. Without
+// WithHashFunc, the hash function registered under name with RegisterHashFunc is used. The default hash function is
+// named Sha256HashName.
+func (tb TreeBuilder) WithHashName(name string) TreeBuilder {
+	tb.hashName = name
+	return tb
+}
+
 func (tb TreeBuilder) WithLeavesToProve(leavesToProves map[uint64]bool) TreeBuilder {
 	tb.leavesToProves = leavesToProves
 	return tb
@@ -52,6 +211,121 @@ func (tb TreeBuilder) WithMinHeight(minHeight uint) TreeBuilder {
 	return tb
 }
 
+// WithPadToPowerOfTwo makes an unbalanced tree behave as if it was padded with PaddingValue leaves up to the next power
+// of two (e.g. a 10-leaf tree is hashed like a 16-leaf tree with 6 padding leaves), instead of padding each layer with
+// a single PaddingValue node. Proofs generated by the tree include the padding subtree roots and validate with
+// ValidatePartialTree as usual. GenerateProof and GetNode don't support this mode, since they pad each layer with a
+// single node: the cache writer records the mode, if it supports it like cache.Writer, and they return
+// ErrPadToPowerOfTwoCache instead of padding the cache of such a tree.
+func (tb TreeBuilder) WithPadToPowerOfTwo() TreeBuilder {
+	tb.padToPowerOfTwo = true
+	return tb
+}
+
+// WithLeafObserver sets a function that AddLeaf calls with the index and value of every leaf added to the tree. The
+// value is only valid for the duration of the call.
+func (tb TreeBuilder) WithLeafObserver(observer func(index uint64, leaf []byte)) TreeBuilder {
+	tb.leafObserver = observer
+	return tb
+}
+
+// WithProvePredicate sets a function that AddLeaf calls with the index and value of every leaf to decide whether to
+// prove it, in addition to the leaves set with WithLeavesToProve. This is useful when the leaves to prove depend on
+// their content and aren't known in advance.
+func (tb TreeBuilder) WithProvePredicate(predicate func(index uint64, leaf []byte) bool) TreeBuilder {
+	tb.provePredicate = predicate
+	return tb
+}
+
+// WithNoPadding disables on-the-fly padding, to catch callers that add the wrong number of leaves. The tree must then
+// be balanced (the number of leaves is a power of two) and reach minHeight: otherwise CheckedRoot and
+// CheckedRootAndProof return an *UnbalancedTreeError, and Root, Proof and RootAndProof return nil.
+func (tb TreeBuilder) WithNoPadding() TreeBuilder {
+	tb.noPadding = true
+	return tb
+}
+
+// WithResumeState makes the tree continue from a previous tree with leafCount leaves, whose parked nodes (as returned
+// by GetParkedNodes) are given. Unlike SetParkedNodes, this also restores the leaf count, so the indices of leaves to
+// prove and of observed leaves continue from leafCount. Leaves to prove must therefore not be lower than leafCount.
+// Build fails if the parked nodes don't match leafCount: there must be a parked node at exactly the layers whose bit is
+// set in leafCount.
+func (tb TreeBuilder) WithResumeState(parked [][]byte, leafCount uint64) TreeBuilder {
+	tb.resumeParked = parked
+	tb.resumeLeafCount = leafCount
+	return tb
+}
+
+// WithSavedState makes the tree continue from a state serialized with Tree.SaveState. The leaf count, min height,
+// leaves to prove and proof collected so far are restored, so they can't also be set with WithResumeState or
+// WithLeavesToProve, and the state's min height replaces the one set with WithMinHeight. The tree must otherwise be
+// configured like the tree whose state was saved, e.g. with the same hash function.
+func (tb TreeBuilder) WithSavedState(state []byte) TreeBuilder {
+	tb.savedState = state
+	return tb
+}
+
+// WithNodeSize sets the size of the nodes in the tree, which is NodeSize by default. It determines the size of the
+// padding, so the hash function should return nodes of the same size. When caching, the layers should be created with
+// the same node size, e.g. with cache.MakeSliceReadWriterFactoryWithNodeSize. AddLeaf rejects leaves of a different
+// size, as it does for the default node size when a cache writer is attached.
+func (tb TreeBuilder) WithNodeSize(nodeSize uint) TreeBuilder {
+	tb.nodeSize = nodeSize
+	return tb
+}
+
+// WithLeafHashFunc makes the tree hash every leaf passed to AddLeaf with leafHash before adding it, so leaves and
+// internal nodes can be hashed differently (e.g. GetRFC6962LeafHash and GetRFC6962Parent). The cache and proofs then
+// contain the hashed leaves. Use ValidatePartialTreeWithLeafHash to validate proofs for the original leaves. Leaves
+// added with Tree.AddLeafHashed aren't hashed.
+func (tb TreeBuilder) WithLeafHashFunc(leafHash LeafHashFunc) TreeBuilder {
+	tb.leafHash = leafHash
+	return tb
+}
+
+// WithSortedSiblings makes the tree order every pair of children lexicographically before hashing them, as expected by
+// OpenZeppelin's MerkleProof and similar verifiers, using SortedPairHashFunc. The cache gets the wrapped hash function,
+// so GenerateProof works as usual. Validate proofs of such trees with the SortSiblings option.
+func (tb TreeBuilder) WithSortedSiblings() TreeBuilder {
+	tb.sortedSiblings = true
+	return tb
+}
+
+// WithRejectPaddingLeaves makes AddLeaf return ErrPaddingLeaf for a leaf that equals the padding value (after hashing
+// it with the leaf hash function, if any). Padding can't be told apart from a leaf with the same value, so a proof for
+// such a leaf in an unbalanced tree could be presented as a proof for a padding position beyond the end of the tree,
+// or the other way around. It's opt-in, since existing trees may legitimately contain all-zero leaves. See also the
+// RejectPaddingLeaves validation option.
+func (tb TreeBuilder) WithRejectPaddingLeaves() TreeBuilder {
+	tb.rejectPaddingLeaves = true
+	return tb
+}
+
+// WithPaddingValue sets the node used to pad unbalanced trees, instead of the zero node PaddingValue. The value is
+// copied. Proofs of such trees include the padding nodes and validate with ValidatePartialTree as usual; validation
+// functions that fill in padding themselves, like ValidatePartialTreeToHeight, need the ValidationPaddingValue option.
+// The cache writer records the padding value, if it supports it like cache.Writer, so GenerateProof and GetNode pad the
+// cache with it too.
+func (tb TreeBuilder) WithPaddingValue(padding []byte) TreeBuilder {
+	tb.paddingValue = padding
+	return tb
+}
+
+// WithMetrics sets the Metrics notified of every parent node the tree calculates.
+func (tb TreeBuilder) WithMetrics(metrics Metrics) TreeBuilder {
+	tb.metrics = metrics
+	return tb
+}
+
+// WithNodePool sets a pool of node buffers, held as *[]byte, that the tree uses for the nodes it calculates while
+// padding in RootAndProof (and Root and Proof). Nodes that are neither the root nor part of the proof are returned to
+// the pool, so calculating roots of unbalanced trees allocates less. The buffers are passed to the HashFunc as its buf,
+// so the HashFunc should append to buf rather than allocate to benefit. The pool may be shared by several trees.
+func (tb TreeBuilder) WithNodePool(pool *sync.Pool) TreeBuilder {
+	tb.nodePool = pool
+	return tb
+}
+
 func NewTree() (*Tree, error) {
 	return NewTreeBuilder().Build()
 }
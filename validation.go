@@ -4,39 +4,338 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/bits"
 	"sort"
 )
 
 const MaxUint = ^uint(0)
 
+var errNilHash = errors.New("hash function is required for validation")
+
+// ErrLeafOrder is returned by the CheckLeafOrder debug check when leaves don't seem to be paired with their indices.
+var ErrLeafOrder = errors.New("leaves are not paired with their indices")
+
+// maxLeafOrderCandidates limits the number of leaves for which CheckLeafOrder looks for a pair of swapped leaves, since
+// it tries every pair. Each attempt only rehashes the paths of the two leaves.
+const maxLeafOrderCandidates = 1024
+
+// ValidationOption configures optional checks performed during validation.
+type ValidationOption func(*validationOptions)
+
+type validationOptions struct {
+	rejectPaddingLeaves bool
+	checkLeafOrder      bool
+	sortSiblings        bool
+	padding             []byte
+}
+
+// ValidationPaddingValue validates proofs of trees built with TreeBuilder.WithPaddingValue. The padding is used wherever
+// validation fills in or checks padding itself, e.g. by ValidatePartialTreeToHeight and RejectPaddingLeaves.
+func ValidationPaddingValue(padding []byte) ValidationOption {
+	return func(o *validationOptions) {
+		o.padding = padding
+	}
+}
+
+// RejectPaddingLeaves makes validation fail when a proven leaf equals PaddingValue (or the ValidationPaddingValue).
+// Padding is indistinguishable from a leaf with the same value, so a proof for such a leaf may actually prove a padding
+// position beyond the end of the tree.
+func RejectPaddingLeaves() ValidationOption {
+	return func(o *validationOptions) {
+		o.rejectPaddingLeaves = true
+	}
+}
+
+// CheckLeafOrder is a debug check for callers that assemble leaves and indices separately. It records the position at
+// which every leaf enters the root calculation and verifies that it's the leaf's claimed index and that the path from
+// there to the root was calculated from that leaf. When the root doesn't match, it also looks for two leaves whose swap
+// would make it match, by rehashing their paths, and returns ErrLeafOrder naming them instead of a plain mismatch. The
+// search is a hint that's only attempted for up to 1024 leaves, as it tries every pair.
+func CheckLeafOrder() ValidationOption {
+	return func(o *validationOptions) {
+		o.checkLeafOrder = true
+	}
+}
+
+// SortSiblings validates proofs of trees built with TreeBuilder.WithSortedSiblings, by ordering every pair of children
+// lexicographically before hashing them.
+func SortSiblings() ValidationOption {
+	return func(o *validationOptions) {
+		o.sortSiblings = true
+	}
+}
+
 // ValidatePartialTree uses leafIndices, leaves and proof to calculate the merkle root of the tree and then compares it
 // to expectedRoot.
+//
+// leaves[i] must be the leaf at leafIndices[i]. The indices must be sorted, but there's no way to tell whether the
+// leaves were paired with the right indices: a mispaired leaf simply produces a different root. Use CheckLeafOrder to
+// diagnose such mismatches.
 func ValidatePartialTree(leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
-	hash HashFunc,
+	hash HashFunc, opts ...ValidationOption,
 ) (bool, error) {
-	v, err := newValidator(leafIndices, leaves, proof, hash, false)
+	root, v, err := calcPartialTreeRoot(leafIndices, leaves, proof, hash, opts...)
 	if err != nil {
 		return false, err
 	}
-	root, _, err := v.CalcRoot(MaxUint)
+	if v.leafPositions != nil {
+		err = v.checkLeafOrder(leafIndices, leaves, root, expectedRoot)
+	}
 	return bytes.Equal(root, expectedRoot), err
 }
 
+// ValidatePartialTreeRoot works like ValidatePartialTree, but returns the calculated root instead of comparing it to an
+// expected root, so callers can compare it themselves and report both roots when they differ. With CheckLeafOrder,
+// the positions at which the leaves entered the calculation are checked, but swapped leaves can't be looked for without
+// an expected root.
+func ValidatePartialTreeRoot(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc,
+	opts ...ValidationOption,
+) ([]byte, error) {
+	root, v, err := calcPartialTreeRoot(leafIndices, leaves, proof, hash, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if v.leafPositions != nil {
+		if err := v.checkLeafOrder(leafIndices, leaves, root, root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// calcPartialTreeRoot calculates the root for ValidatePartialTree and ValidatePartialTreeRoot, and returns the
+// validator used, which records the positions of the leaves for CheckLeafOrder.
+func calcPartialTreeRoot(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc,
+	opts ...ValidationOption,
+) ([]byte, *Validator, error) {
+	v, err := newValidator(leafIndices, leaves, proof, hash, false, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, _, err := v.CalcRoot(MaxUint)
+	if err == nil {
+		err = v.checkFullyConsumed()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, v, nil
+}
+
+// checkLeafOrder implements the CheckLeafOrder option, given the root calculated for the leaves in their original
+// order. It requires the positions and nodes recorded by CalcRoot.
+func (v *Validator) checkLeafOrder(leafIndices []uint64, leaves [][]byte, root, expectedRoot []byte) error {
+	if len(v.leafPositions) != len(leafIndices) {
+		return fmt.Errorf("%w: %d of %d leaves entered the calculation", ErrLeafOrder, len(v.leafPositions),
+			len(leafIndices))
+	}
+	rootHeight := v.knownRootHeight()
+	for i, pos := range v.leafPositions {
+		if pos != (Position{Index: leafIndices[i]}) {
+			return fmt.Errorf("%w: leaf %d entered the calculation at Position %s instead of index %d", ErrLeafOrder,
+				i, pos, leafIndices[i])
+		}
+		if rootHeight > 0 && !bytes.Equal(v.knownNodes[pos], leaves[i]) {
+			return fmt.Errorf("%w: the path of leaf %d wasn't calculated from it", ErrLeafOrder, leafIndices[i])
+		}
+	}
+	if bytes.Equal(root, expectedRoot) || len(leaves) > maxLeafOrderCandidates {
+		return nil
+	}
+	for i := range leaves {
+		for j := i + 1; j < len(leaves); j++ {
+			swapped := map[Position][]byte{v.leafPositions[i]: leaves[j], v.leafPositions[j]: leaves[i]}
+			if bytes.Equal(v.rootWithReplacedNodes(swapped, rootHeight), expectedRoot) {
+				return fmt.Errorf("%w: leaves for indices %d and %d appear to be swapped", ErrLeafOrder,
+					leafIndices[i], leafIndices[j])
+			}
+		}
+	}
+	return nil
+}
+
+// rootWithReplacedNodes recalculates the root at rootHeight after replacing the given nodes, using the nodes recorded
+// by CalcRoot for the rest of the tree. Only the paths from the replaced nodes to the root are rehashed.
+func (v *Validator) rootWithReplacedNodes(replaced map[Position][]byte, rootHeight uint) []byte {
+	for height := uint(0); height < rootHeight; height++ {
+		parents := make(map[Position][]byte, len(replaced))
+		for pos, n := range replaced {
+			parent := pos.parent()
+			if _, done := parents[parent]; done {
+				continue
+			}
+			sibling, found := replaced[pos.sibling()]
+			if !found {
+				sibling = v.knownNodes[pos.sibling()]
+			}
+			if pos.isRightSibling() {
+				parents[parent] = v.Hash(nil, sibling, n)
+			} else {
+				parents[parent] = v.Hash(nil, n, sibling)
+			}
+		}
+		replaced = parents
+	}
+	for _, root := range replaced {
+		return root
+	}
+	return nil
+}
+
+// ValidateRangeProof validates a proof generated by GenerateRangeProof for the contiguous range of leaves [start, end)
+// against expectedRoot. leaves[i] must be the leaf at start+i.
+func ValidateRangeProof(start, end uint64, leaves, proof [][]byte, expectedRoot []byte, hash HashFunc,
+	opts ...ValidationOption,
+) (bool, error) {
+	if start >= end {
+		return false, fmt.Errorf("invalid range [%d, %d)", start, end)
+	}
+	if uint64(len(leaves)) != end-start {
+		return false, fmt.Errorf("range [%d, %d) has %d leaves, got %d", start, end, end-start, len(leaves))
+	}
+	leafIndices := make([]uint64, len(leaves))
+	for i := range leafIndices {
+		leafIndices[i] = start + uint64(i)
+	}
+	return ValidatePartialTree(leafIndices, leaves, proof, expectedRoot, hash, opts...)
+}
+
+// VerifyInclusion validates the proof of a single leaf at leafIndex against root. The proof must hold one sibling per
+// layer below the root, so its length must be at least the number of bits in leafIndex, since a shorter proof can't
+// reach a root above that leaf.
+func VerifyInclusion(leafIndex uint64, leaf []byte, proof [][]byte, root []byte, hash HashFunc) (bool, error) {
+	if minLength := bits.Len64(leafIndex); len(proof) < minLength || len(proof) > 64 {
+		return false, fmt.Errorf("proof of length %d can't prove leaf index %d: it must have between %d and 64 nodes",
+			len(proof), leafIndex, minLength)
+	}
+	return ValidatePartialTree([]uint64{leafIndex}, [][]byte{leaf}, proof, root, hash)
+}
+
+// ValidatePartialTreeWithLeafHash works like ValidatePartialTree for trees built with TreeBuilder.WithLeafHashFunc:
+// the leaves are hashed with leafHash before the root is calculated.
+func ValidatePartialTreeWithLeafHash(leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
+	leafHash LeafHashFunc, hash HashFunc, opts ...ValidationOption,
+) (bool, error) {
+	if leafHash == nil {
+		return false, errors.New("leaf hash function is required for validation")
+	}
+	hashedLeaves := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashedLeaves[i] = leafHash(nil, leaf)
+	}
+	return ValidatePartialTree(leafIndices, hashedLeaves, proof, expectedRoot, hash, opts...)
+}
+
+// ValidatePartialTreeWithSize works like ValidatePartialTree, but first rejects any leaf index that's out of range for
+// a tree with size leaves. The size usually comes from a trusted source, such as a signed root header.
+func ValidatePartialTreeWithSize(size uint64, leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
+	hash HashFunc, opts ...ValidationOption,
+) (bool, error) {
+	for _, index := range leafIndices {
+		if index >= size {
+			return false, fmt.Errorf("leaf index %d is out of range for tree of size %d", index, size)
+		}
+	}
+	return ValidatePartialTree(leafIndices, leaves, proof, expectedRoot, hash, opts...)
+}
+
+// ValidatePartialTreeWithWidth is a stricter ValidatePartialTreeWithSize: in addition to rejecting out of range leaf
+// indices, it verifies that the proof has the shape of a tree with exactly width leaves. The root must be calculated at
+// RootHeightFromWidth(width), and every proof node that lies entirely beyond the last leaf must be padding. This
+// prevents a proof for a tree of a different size from being accepted when the hashes happen to chain.
+func ValidatePartialTreeWithWidth(width uint64, leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
+	hash HashFunc, opts ...ValidationOption,
+) (bool, error) {
+	for _, index := range leafIndices {
+		if index >= width {
+			return false, fmt.Errorf("leaf index %d is out of range for tree of width %d", index, width)
+		}
+	}
+	v, err := newValidator(leafIndices, leaves, proof, hash, false, opts...)
+	if err != nil {
+		return false, err
+	}
+	v.knownNodes = make(map[Position][]byte)
+	root, _, err := v.CalcRoot(MaxUint)
+	if err == nil {
+		err = v.checkFullyConsumed()
+	}
+	if err != nil {
+		return false, err
+	}
+	if rootHeight, expectedHeight := v.knownRootHeight(), RootHeightFromWidth(width); rootHeight != expectedHeight {
+		return false, fmt.Errorf("proof reaches height %d, but a tree of width %d has root height %d", rootHeight,
+			width, expectedHeight)
+	}
+	for pos, n := range v.knownNodes {
+		if pos.Index<<pos.Height >= width && !bytes.Equal(n, v.paddingFor(n)) {
+			return false, fmt.Errorf("node at %s is beyond the last leaf of a tree of width %d, but isn't padding", pos,
+				width)
+		}
+	}
+	return bytes.Equal(root, expectedRoot), nil
+}
+
+// ValidatePartialTreeToHeight works like ValidatePartialTree, but calculates the root at exactly rootHeight, as needed
+// for trees built with a minHeight. Trailing padding siblings may be omitted from the proof: when the proof runs out
+// before reaching rootHeight, PaddingValue (or the ValidationPaddingValue) is used as the right sibling. Proof nodes
+// left over after reaching rootHeight are an error.
+func ValidatePartialTreeToHeight(rootHeight uint, leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
+	hash HashFunc, opts ...ValidationOption,
+) (bool, error) {
+	for _, index := range leafIndices {
+		if rootHeight < 64 && index>>rootHeight != 0 {
+			return false, fmt.Errorf("leaf index %d is out of range for tree of height %d", index, rootHeight)
+		}
+	}
+	v, err := newValidator(leafIndices, leaves, proof, hash, false, opts...)
+	if err != nil {
+		return false, err
+	}
+	v.padMissingSiblings = true
+	root, _, err := v.CalcRoot(rootHeight)
+	if err != nil {
+		return false, err
+	}
+	if remaining := len(v.ProofNodes.nodes); remaining > 0 {
+		return false, fmt.Errorf("%d proof nodes left over after reaching root height %d", remaining, rootHeight)
+	}
+	return bytes.Equal(root, expectedRoot), nil
+}
+
 // ValidatePartialTree uses leafIndices, leaves and proof to calculate the merkle root of the tree and then compares it
 // to expectedRoot. Additionally, it reconstructs the parked nodes when each proven leaf was originally added to the
 // tree and returns a list of snapshots. This method is ~15% slower than ValidatePartialTree.
 func ValidatePartialTreeWithParkingSnapshots(leafIndices []uint64, leaves, proof [][]byte, expectedRoot []byte,
-	hash HashFunc,
+	hash HashFunc, opts ...ValidationOption,
 ) (bool, []ParkingSnapshot, error) {
-	v, err := newValidator(leafIndices, leaves, proof, hash, true)
+	v, err := newValidator(leafIndices, leaves, proof, hash, true, opts...)
 	if err != nil {
 		return false, nil, err
 	}
 	root, parkingSnapshots, err := v.CalcRoot(MaxUint)
-	return bytes.Equal(root, expectedRoot), parkingSnapshots, err
+	if err == nil {
+		err = v.checkFullyConsumed()
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return bytes.Equal(root, expectedRoot), parkingSnapshots, nil
 }
 
-func newValidator(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc, storeSnapshots bool) (*Validator, error) {
+func newValidator(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc, storeSnapshots bool,
+	opts ...ValidationOption,
+) (*Validator, error) {
+	if hash == nil {
+		return nil, errNilHash
+	}
+	var options validationOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.sortSiblings {
+		hash = SortedPairHashFunc(hash)
+	}
 	if len(leafIndices) != len(leaves) {
 		return nil, fmt.Errorf("number of leaves (%d) must equal number of indices (%d)", len(leaves),
 			len(leafIndices))
@@ -50,10 +349,32 @@ func newValidator(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc, s
 	if len(SetOf(leafIndices...)) != len(leafIndices) {
 		return nil, errors.New("leafIndices contain duplicates")
 	}
+	if options.rejectPaddingLeaves {
+		padding := options.padding
+		if padding == nil {
+			padding = PaddingValue.value
+		}
+		for i, leaf := range leaves {
+			if bytes.Equal(leaf, padding) {
+				return nil, fmt.Errorf("proven leaf %d equals the padding value", leafIndices[i])
+			}
+		}
+	}
 	proofNodes := &proofIterator{proof}
 	leafIt := &LeafIterator{leafIndices, leaves}
 
-	return &Validator{Leaves: leafIt, ProofNodes: proofNodes, Hash: hash, StoreSnapshots: storeSnapshots}, nil
+	v := &Validator{
+		Leaves:         leafIt,
+		ProofNodes:     proofNodes,
+		Hash:           hash,
+		StoreSnapshots: storeSnapshots,
+		padding:        options.padding,
+	}
+	if options.checkLeafOrder {
+		v.leafPositions = make([]Position, 0, len(leaves))
+		v.knownNodes = make(map[Position][]byte)
+	}
+	return v, nil
 }
 
 type Validator struct {
@@ -61,6 +382,11 @@ type Validator struct {
 	ProofNodes     *proofIterator
 	Hash           HashFunc
 	StoreSnapshots bool
+
+	padMissingSiblings bool                // Use padding for right siblings once the proof nodes run out.
+	padding            []byte              // The padding value, or nil for zero nodes of the proven nodes' size.
+	knownNodes         map[Position][]byte // If set, every node seen during the calculation is recorded here.
+	leafPositions      []Position          // If set, the position at which every leaf enters the calculation is appended.
 }
 
 type ParkingSnapshot [][]byte
@@ -70,6 +396,12 @@ func (v *Validator) CalcRoot(stopAtLayer uint) ([]byte, []ParkingSnapshot, error
 	if err != nil {
 		return nil, nil, err
 	}
+	if v.Hash == nil {
+		return nil, nil, errNilHash
+	}
+	if v.leafPositions != nil {
+		v.leafPositions = append(v.leafPositions, activePos)
+	}
 	var lChild, rChild, sibling []byte
 	var parkingSnapshots, subTreeSnapshots []ParkingSnapshot
 	if v.StoreSnapshots {
@@ -90,9 +422,16 @@ func (v *Validator) CalcRoot(stopAtLayer uint) ([]byte, []ParkingSnapshot, error
 		} else {
 			sibling, err = v.ProofNodes.next()
 			if err == noMoreItems {
-				break
+				if !v.padMissingSiblings || activePos.isRightSibling() {
+					break
+				}
+				sibling = v.paddingFor(activeNode)
 			}
 		}
+		if v.knownNodes != nil {
+			v.knownNodes[activePos] = activeNode
+			v.knownNodes[activePos.sibling()] = sibling
+		}
 		if activePos.isRightSibling() {
 			lChild, rChild = sibling, activeNode
 			addToAll(parkingSnapshots, lChild)
@@ -110,9 +449,142 @@ func (v *Validator) CalcRoot(stopAtLayer uint) ([]byte, []ParkingSnapshot, error
 	return activeNode, parkingSnapshots, nil
 }
 
+// paddingFor returns the padding for a sibling of n: the configured padding value, or a zero node of n's size.
+func (v *Validator) paddingFor(n []byte) []byte {
+	if v.padding != nil {
+		return v.padding
+	}
+	return make([]byte, len(n))
+}
+
+// checkFullyConsumed returns an error if CalcRoot returned before using all proof nodes or all leaves. Unused leaves
+// don't affect the root, so without this check a proof would also validate with extra, unproven leaves.
+func (v *Validator) checkFullyConsumed() error {
+	if remaining := len(v.ProofNodes.nodes); remaining > 0 {
+		return fmt.Errorf("%d proof nodes left over after calculating the root", remaining)
+	}
+	if pos, _, err := v.Leaves.peek(); err == nil {
+		return fmt.Errorf("leaf %d was not used to calculate the root", pos.Index)
+	}
+	return nil
+}
+
+// SplitMultiProof splits a proof for multiple leaves into an individual proof for each of the leaves, keyed by leaf
+// index. Each individual proof can be validated on its own with ValidatePartialTree.
+//
+// The individual proofs can't be derived from the ParkingSnapshots returned by ValidatePartialTreeWithParkingSnapshots
+// instead: a snapshot holds a proven leaf's left siblings only, with nil wherever the sibling is on the right. For
+// leaves 4 and 6 of an 8-leaf tree, the snapshot of leaf 4 is [nil, nil, node(0..3)], while its proof also needs leaf
+// 5 and node(6..7), which are only found in the multiproof (or calculated from it).
+func SplitMultiProof(leafIndices []uint64, leaves, proof [][]byte, hash HashFunc) (map[uint64][][]byte, error) {
+	v, err := newValidator(leafIndices, leaves, proof, hash, false)
+	if err != nil {
+		return nil, err
+	}
+	v.knownNodes = make(map[Position][]byte)
+	if _, _, err := v.CalcRoot(MaxUint); err != nil {
+		return nil, err
+	}
+	rootHeight := v.knownRootHeight()
+	proofs := make(map[uint64][][]byte, len(leafIndices))
+	for _, index := range leafIndices {
+		var leafProof [][]byte
+		for pos := (Position{Index: index}); pos.Height < rootHeight; pos = pos.parent() {
+			leafProof = append(leafProof, v.knownNodes[pos.sibling()])
+		}
+		proofs[index] = leafProof
+	}
+	return proofs, nil
+}
+
+// knownRootHeight returns the height of the root calculated by CalcRoot, based on the recorded knownNodes.
+func (v *Validator) knownRootHeight() uint {
+	var rootHeight uint
+	for pos := range v.knownNodes {
+		if pos.Height >= rootHeight {
+			rootHeight = pos.Height + 1
+		}
+	}
+	return rootHeight
+}
+
 func addToAll(snapshots []ParkingSnapshot, node []byte) []ParkingSnapshot {
 	for i := 0; i < len(snapshots); i++ {
 		snapshots[i] = append(snapshots[i], node)
 	}
 	return snapshots
 }
+
+// SingleProof is a proof for a single leaf: the leaf's siblings from the base layer up to the root.
+type SingleProof struct {
+	Index uint64
+	Leaf  []byte
+	Nodes [][]byte
+}
+
+// pathNodes returns all nodes implied by the proof (the leaf, its ancestors and their siblings) by position, and the
+// height of the root.
+func (p SingleProof) pathNodes(hash HashFunc) (map[Position][]byte, uint) {
+	nodes := make(map[Position][]byte, 2*len(p.Nodes)+1)
+	pos, node := Position{Index: p.Index}, p.Leaf
+	nodes[pos] = node
+	for _, sibling := range p.Nodes {
+		nodes[pos.sibling()] = sibling
+		if pos.isRightSibling() {
+			node = hash(nil, sibling, node)
+		} else {
+			node = hash(nil, node, sibling)
+		}
+		pos = pos.parent()
+		nodes[pos] = node
+	}
+	return nodes, pos.Height
+}
+
+// CrossCheckProofs validates two single-leaf proofs against root and checks that they agree on every node that's
+// implied by both, i.e. their shared ancestors and siblings. Both proofs must also be for a tree of the same height.
+// This catches a peer that mixes proofs from different trees that were crafted to share a root.
+func CrossCheckProofs(root []byte, hash HashFunc, a, b SingleProof) (bool, error) {
+	if hash == nil {
+		return false, errNilHash
+	}
+	aNodes, aHeight := a.pathNodes(hash)
+	bNodes, bHeight := b.pathNodes(hash)
+	if aHeight != bHeight {
+		return false, nil
+	}
+	rootPos := Position{Height: aHeight}
+	if !bytes.Equal(aNodes[rootPos], root) || !bytes.Equal(bNodes[rootPos], root) {
+		return false, nil
+	}
+	for pos, node := range aNodes {
+		if other, found := bNodes[pos]; found && !bytes.Equal(node, other) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// root returns the root implied by the proof, or nil if the leaf index is out of range for the proof's height.
+func (p SingleProof) root(hash HashFunc) []byte {
+	nodes, rootHeight := p.pathNodes(hash)
+	return nodes[Position{Height: rootHeight}]
+}
+
+// VerifyHierarchical verifies membership of a leaf in an inner tree whose root is itself a leaf of an outer tree. The
+// inner proof must yield outerProof.Leaf, and the outer proof must yield outerRoot. innerLeafIndex is the index of the
+// leaf in the inner tree and must match innerProof.Index.
+func VerifyHierarchical(innerProof SingleProof, innerLeafIndex uint64, outerProof SingleProof, outerRoot []byte,
+	hash HashFunc,
+) (bool, error) {
+	if hash == nil {
+		return false, errNilHash
+	}
+	if innerProof.Index != innerLeafIndex {
+		return false, fmt.Errorf("inner proof is for leaf %d, not %d", innerProof.Index, innerLeafIndex)
+	}
+	if !bytes.Equal(innerProof.root(hash), outerProof.Leaf) {
+		return false, nil
+	}
+	return bytes.Equal(outerProof.root(hash), outerRoot), nil
+}
@@ -1,11 +1,13 @@
 package merkle_test
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/spacemeshos/merkle-tree"
 	"github.com/spacemeshos/merkle-tree/cache"
 )
 
@@ -25,6 +27,30 @@ func TestValidatePartialTree(t *testing.T) {
 	req.True(valid, "Proof should be valid, but isn't")
 }
 
+func TestValidatePartialTreeRoot(t *testing.T) {
+	req := require.New(t)
+
+	leafIndices := []uint64{3}
+	leaves := [][]byte{NewNodeFromUint64(3)}
+	proof := [][]byte{
+		NewNodeFromUint64(0),
+		NewNodeFromUint64(0),
+		NewNodeFromUint64(0),
+	}
+	expectedRoot, _ := NewNodeFromHex("2657509b700c67b205c5196ee9a231e0fe567f1dae4a15bb52c0de813d65677a")
+	root, err := merkle.ValidatePartialTreeRoot(leafIndices, leaves, proof, GetSha256Parent)
+	req.NoError(err)
+	req.Equal(expectedRoot, root)
+
+	proof[1] = NewNodeFromUint64(1)
+	root, err = merkle.ValidatePartialTreeRoot(leafIndices, leaves, proof, GetSha256Parent)
+	req.NoError(err)
+	req.NotEqual(expectedRoot, root)
+
+	_, err = merkle.ValidatePartialTreeRoot([]uint64{3, 4}, leaves, proof, GetSha256Parent)
+	req.Error(err)
+}
+
 func TestValidatePartialTreeProofs(t *testing.T) {
 	for n := 1; n <= 64; n++ {
 		for l := 0; l < n; l++ {
@@ -141,6 +167,16 @@ func TestValidatePartialTreeParkingSnapshots(t *testing.T) {
 			"[ bd50456d5ad175ae99a1612a53ca229124b65d3eaabd9ff9c7ab979a385cf6b3 ba94ffe7edabf26ef12736f8eb5ce74d15bedb6af61444ae2906e926b1a95084]]",
 		fmt.Sprintf("%x", parkingSnapshots))
 
+	// The snapshots lack the right siblings, so individual proofs are split from the multiproof instead.
+	proofs, err := merkle.SplitMultiProof(leafIndices, leaves, proof, GetSha256Parent)
+	req.NoError(err)
+	req.Nil(parkingSnapshots[0][0])
+	req.Nil(parkingSnapshots[0][1])
+	req.Equal(parkingSnapshots[0][2], proofs[4][2])
+	valid, err = ValidatePartialTree([]uint64{4}, leaves[:1], proofs[4], root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
 	/***************************************************
 	|                       89a0                       |
 	|          .ba94.                   633b           |
@@ -241,6 +277,76 @@ func TestValidatePartialTreeUnbalanced(t *testing.T) {
 	***************************************************************/
 }
 
+func TestVerifyInclusion(t *testing.T) {
+	req := require.New(t)
+
+	proof := [][]byte{
+		NewNodeFromUint64(0),
+		NewNodeFromUint64(0),
+		NewNodeFromUint64(0),
+	}
+	root, _ := NewNodeFromHex("2657509b700c67b205c5196ee9a231e0fe567f1dae4a15bb52c0de813d65677a")
+	valid, err := merkle.VerifyInclusion(3, NewNodeFromUint64(3), proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	valid, err = merkle.VerifyInclusion(2, NewNodeFromUint64(3), proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid, "Proof should be invalid for the wrong index")
+
+	_, err = merkle.VerifyInclusion(9, NewNodeFromUint64(9), proof, root, GetSha256Parent)
+	req.EqualError(err, "proof of length 3 can't prove leaf index 9: it must have between 4 and 64 nodes")
+}
+
+func TestVerifyInclusionUnbalanced(t *testing.T) {
+	req := require.New(t)
+
+	for _, leafIndex := range []uint64{0, 7, 8, 9} {
+		tree, err := NewProvingTree(setOf(leafIndex))
+		req.NoError(err)
+		for i := uint64(0); i < 10; i++ {
+			req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		root, proof := tree.RootAndProof()
+
+		valid, err := merkle.VerifyInclusion(leafIndex, NewNodeFromUint64(leafIndex), proof, root, GetSha256Parent)
+		req.NoError(err)
+		req.True(valid, "Proof for leaf %d should be valid, but isn't", leafIndex)
+	}
+}
+
+func TestValidatePartialTreeRejectsUnusedInput(t *testing.T) {
+	req := require.New(t)
+
+	tree, err := NewProvingTree(setOf(3))
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, proof := tree.RootAndProof()
+
+	// A bogus trailing node is hashed into the root, so the proof no longer validates.
+	forgedProof := append(append([][]byte(nil), proof...), NewNodeFromUint64(0))
+	valid, err := ValidatePartialTree([]uint64{3}, [][]byte{NewNodeFromUint64(3)}, forgedProof, root, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+	valid, _, err = ValidatePartialTreeWithParkingSnapshots([]uint64{3}, [][]byte{NewNodeFromUint64(3)}, forgedProof,
+		root, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	// A leaf that isn't reached before the proof runs out would otherwise be ignored.
+	leafIndices := []uint64{1, 2}
+	leaves := [][]byte{NewNodeFromUint64(1), NewNodeFromUint64(2)}
+	valid, err = ValidatePartialTree(leafIndices, leaves, nil, NewNodeFromUint64(1), GetSha256Parent)
+	req.EqualError(err, "leaf 2 was not used to calculate the root")
+	req.False(valid)
+	valid, _, err = ValidatePartialTreeWithParkingSnapshots(leafIndices, leaves, nil, NewNodeFromUint64(1),
+		GetSha256Parent)
+	req.EqualError(err, "leaf 2 was not used to calculate the root")
+	req.False(valid)
+}
+
 func BenchmarkValidatePartialTree(b *testing.B) {
 	req := require.New(b)
 
@@ -324,3 +430,453 @@ func TestValidator_calcRoot(t *testing.T) {
 	r.EqualError(err, "no more items")
 	r.Nil(root)
 }
+
+func TestValidatePartialTreeRejectPaddingLeaves(t *testing.T) {
+	req := require.New(t)
+
+	leafIndices := []uint64{0}
+	leaves := [][]byte{NewNodeFromUint64(0)} // All zeros, just like PaddingValue.
+	tree, err := NewProvingTree(setOf(leafIndices...))
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		req.NoError(err)
+	}
+	root, proof := tree.RootAndProof()
+
+	valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	valid, err = ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.RejectPaddingLeaves())
+	req.EqualError(err, "proven leaf 0 equals the padding value")
+	req.False(valid)
+}
+
+func TestValidatePartialTreeCheckLeafOrder(t *testing.T) {
+	req := require.New(t)
+
+	leafIndices := []uint64{1, 4, 6}
+	tree, err := NewProvingTree(setOf(leafIndices...))
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		req.NoError(err)
+	}
+	root, proof := tree.RootAndProof()
+
+	leaves := [][]byte{NewNodeFromUint64(1), NewNodeFromUint64(4), NewNodeFromUint64(6)}
+	valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.CheckLeafOrder())
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	leaves = [][]byte{NewNodeFromUint64(6), NewNodeFromUint64(4), NewNodeFromUint64(1)}
+	valid, err = ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	valid, err = ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.CheckLeafOrder())
+	req.ErrorIs(err, merkle.ErrLeafOrder)
+	req.EqualError(err, "leaves are not paired with their indices: leaves for indices 1 and 6 appear to be swapped")
+	req.False(valid)
+}
+
+func TestValidatePartialTreeCheckLeafOrderManyLeaves(t *testing.T) {
+	req := require.New(t)
+
+	var leafIndices []uint64
+	for i := uint64(0); i < 40; i += 2 {
+		leafIndices = append(leafIndices, i+i/8)
+	}
+	tree, err := NewProvingTree(setOf(leafIndices...))
+	req.NoError(err)
+	for i := uint64(0); i < 50; i++ {
+		req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, proof := tree.RootAndProof()
+
+	leaves := make([][]byte, len(leafIndices))
+	for i, index := range leafIndices {
+		leaves[i] = NewNodeFromUint64(index)
+	}
+	valid, err := ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.CheckLeafOrder())
+	req.NoError(err)
+	req.True(valid)
+	calculatedRoot, err := merkle.ValidatePartialTreeRoot(leafIndices, leaves, proof, GetSha256Parent,
+		merkle.CheckLeafOrder())
+	req.NoError(err)
+	req.Equal(root, calculatedRoot)
+
+	leaves[3], leaves[17] = leaves[17], leaves[3]
+	valid, err = ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.CheckLeafOrder())
+	req.ErrorIs(err, merkle.ErrLeafOrder)
+	req.EqualError(err, fmt.Sprintf("leaves are not paired with their indices: leaves for indices %d and %d appear "+
+		"to be swapped", leafIndices[3], leafIndices[17]))
+	req.False(valid)
+
+	// Other mismatches are reported as such.
+	leaves[3], leaves[17] = leaves[17], NewNodeFromUint64(1000)
+	valid, err = ValidatePartialTree(leafIndices, leaves, proof, root, GetSha256Parent, merkle.CheckLeafOrder())
+	req.NoError(err)
+	req.False(valid)
+}
+
+func TestValidator_calcRootNilHash(t *testing.T) {
+	r := require.New(t)
+	v := validator{
+		Leaves: merkle.NewLeafIterator([]uint64{0}, [][]byte{NewNodeFromUint64(0)}),
+		Hash:   nil,
+	}
+
+	root, _, err := v.CalcRoot(merkle.MaxUint)
+
+	r.EqualError(err, "hash function is required for validation")
+	r.Nil(root)
+
+	valid, err := ValidatePartialTree([]uint64{0}, [][]byte{NewNodeFromUint64(0)}, nil, nil, nil)
+	r.EqualError(err, "hash function is required for validation")
+	r.False(valid)
+}
+
+func TestValidatePartialTreeWithSize(t *testing.T) {
+	req := require.New(t)
+
+	leafIndices := []uint64{7}
+	leaves := [][]byte{NewNodeFromUint64(7)}
+	tree, err := NewProvingTree(setOf(leafIndices...))
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		req.NoError(err)
+	}
+	root, proof := tree.RootAndProof()
+
+	valid, err := merkle.ValidatePartialTreeWithSize(8, leafIndices, leaves, proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	valid, err = merkle.ValidatePartialTreeWithSize(7, leafIndices, leaves, proof, root, GetSha256Parent)
+	req.EqualError(err, "leaf index 7 is out of range for tree of size 7")
+	req.False(valid)
+}
+
+func TestValidatePartialTreeWithWidth(t *testing.T) {
+	req := require.New(t)
+
+	for _, leafIndex := range []uint64{3, 9} {
+		tree, err := NewProvingTree(setOf(leafIndex))
+		req.NoError(err)
+		for i := uint64(0); i < 10; i++ {
+			req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		root, proof := tree.RootAndProof()
+		leafIndices, leaves := []uint64{leafIndex}, [][]byte{NewNodeFromUint64(leafIndex)}
+
+		valid, err := merkle.ValidatePartialTreeWithWidth(10, leafIndices, leaves, proof, root, GetSha256Parent)
+		req.NoError(err)
+		req.True(valid)
+
+		// The proof climbs 4 layers, while a tree of 8 leaves has root height 3.
+		valid, err = merkle.ValidatePartialTreeWithWidth(8, leafIndices, leaves, proof, root, GetSha256Parent)
+		req.Error(err)
+		req.False(valid)
+	}
+
+	// A proof for leaf 4 of an 8-leaf tree, presented as a proof for a 6-leaf tree, has a proof node beyond the last
+	// leaf (the parent of leaves 6 and 7) that isn't padding.
+	tree, err := NewProvingTree(setOf(4))
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root, proof := tree.RootAndProof()
+	valid, err := merkle.ValidatePartialTreeWithWidth(6, []uint64{4}, [][]byte{NewNodeFromUint64(4)}, proof, root,
+		GetSha256Parent)
+	req.EqualError(err, "node at <h: 1 i: 3> is beyond the last leaf of a tree of width 6, but isn't padding")
+	req.False(valid)
+	valid, err = merkle.ValidatePartialTreeWithWidth(4, []uint64{4}, [][]byte{NewNodeFromUint64(4)}, proof, root,
+		GetSha256Parent)
+	req.EqualError(err, "leaf index 4 is out of range for tree of width 4")
+	req.False(valid)
+}
+
+func TestValidatePartialTreeToHeight(t *testing.T) {
+	req := require.New(t)
+
+	leafIndices := []uint64{3}
+	leaves := [][]byte{NewNodeFromUint64(3)}
+	tree, err := NewTreeBuilder().WithLeavesToProve(setOf(leafIndices...)).WithMinHeight(5).Build()
+	req.NoError(err)
+	for i := uint64(0); i < 8; i++ {
+		err := tree.AddLeaf(NewNodeFromUint64(i))
+		req.NoError(err)
+	}
+	root, proof := tree.RootAndProof()
+	req.Len(proof, 5)
+
+	valid, err := merkle.ValidatePartialTreeToHeight(5, leafIndices, leaves, proof, root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	// Without the trailing padding siblings, ValidatePartialTree stops at height 3.
+	shortProof := proof[:3]
+	valid, err = ValidatePartialTree(leafIndices, leaves, shortProof, root, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	valid, err = merkle.ValidatePartialTreeToHeight(5, leafIndices, leaves, shortProof, root, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid, "Proof should be valid, but isn't")
+
+	valid, err = merkle.ValidatePartialTreeToHeight(4, leafIndices, leaves, proof, root, GetSha256Parent)
+	req.EqualError(err, "1 proof nodes left over after reaching root height 4")
+	req.False(valid)
+
+	valid, err = merkle.ValidatePartialTreeToHeight(1, leafIndices, leaves, proof, root, GetSha256Parent)
+	req.EqualError(err, "leaf index 3 is out of range for tree of height 1")
+	req.False(valid)
+}
+
+func TestSplitMultiProof(t *testing.T) {
+	req := require.New(t)
+
+	for _, tt := range []struct {
+		width       uint64
+		leafIndices []uint64
+	}{
+		{width: 8, leafIndices: []uint64{4, 6}},
+		{width: 10, leafIndices: []uint64{0, 4, 7, 9}},
+	} {
+		var leaves [][]byte
+		for _, i := range tt.leafIndices {
+			leaves = append(leaves, NewNodeFromUint64(i))
+		}
+		tree, err := NewProvingTree(setOf(tt.leafIndices...))
+		req.NoError(err)
+		for i := uint64(0); i < tt.width; i++ {
+			req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+		}
+		root, proof := tree.RootAndProof()
+
+		proofs, err := merkle.SplitMultiProof(tt.leafIndices, leaves, proof, GetSha256Parent)
+		req.NoError(err)
+		req.Len(proofs, len(tt.leafIndices))
+
+		for i, index := range tt.leafIndices {
+			singleTree, err := NewProvingTree(setOf(index))
+			req.NoError(err)
+			for i := uint64(0); i < tt.width; i++ {
+				req.NoError(singleTree.AddLeaf(NewNodeFromUint64(i)))
+			}
+			req.Equal(singleTree.Proof(), proofs[index])
+
+			valid, err := ValidatePartialTree([]uint64{index}, leaves[i:i+1], proofs[index], root, GetSha256Parent)
+			req.NoError(err)
+			req.True(valid, "Proof should be valid, but isn't")
+		}
+	}
+}
+
+func TestCrossCheckProofs(t *testing.T) {
+	req := require.New(t)
+
+	singleProof := func(leaves [][]byte, index uint64) (merkle.SingleProof, []byte) {
+		tree, err := NewProvingTree(setOf(index))
+		req.NoError(err)
+		for _, leaf := range leaves {
+			req.NoError(tree.AddLeaf(leaf))
+		}
+		root, proof := tree.RootAndProof()
+		return merkle.SingleProof{Index: index, Leaf: leaves[index], Nodes: proof}, root
+	}
+
+	leaves := make([][]byte, 8)
+	for i := range leaves {
+		leaves[i] = NewNodeFromUint64(uint64(i))
+	}
+	a, root := singleProof(leaves, 2)
+	b, _ := singleProof(leaves, 5)
+	consistent, err := merkle.CrossCheckProofs(root, GetSha256Parent, a, b)
+	req.NoError(err)
+	req.True(consistent)
+
+	// A 4-leaf tree over the parents of the 8 leaves has the same root, so a proof in it validates on its own, but it
+	// implies a tree of a different height.
+	parents := make([][]byte, 4)
+	for i := range parents {
+		parents[i] = GetSha256Parent(nil, leaves[2*i], leaves[2*i+1])
+	}
+	crafted, craftedRoot := singleProof(parents, 1)
+	req.Equal(root, craftedRoot)
+	valid, err := ValidatePartialTree([]uint64{crafted.Index}, [][]byte{crafted.Leaf}, crafted.Nodes, root,
+		GetSha256Parent)
+	req.NoError(err)
+	req.True(valid)
+	consistent, err = merkle.CrossCheckProofs(root, GetSha256Parent, a, crafted)
+	req.NoError(err)
+	req.False(consistent)
+
+	// A proof from a different tree doesn't validate against the root.
+	otherLeaves := append([][]byte{NewNodeFromUint64(100)}, leaves[1:]...)
+	other, _ := singleProof(otherLeaves, 5)
+	consistent, err = merkle.CrossCheckProofs(root, GetSha256Parent, a, other)
+	req.NoError(err)
+	req.False(consistent)
+
+	_, err = merkle.CrossCheckProofs(root, nil, a, b)
+	req.EqualError(err, "hash function is required for validation")
+}
+
+func TestVerifyHierarchical(t *testing.T) {
+	req := require.New(t)
+
+	buildTree := func(leaves [][]byte, index uint64) (merkle.SingleProof, []byte) {
+		tree, err := NewProvingTree(setOf(index))
+		req.NoError(err)
+		for _, leaf := range leaves {
+			req.NoError(tree.AddLeaf(leaf))
+		}
+		root, proof := tree.RootAndProof()
+		return merkle.SingleProof{Index: index, Leaf: leaves[index], Nodes: proof}, root
+	}
+
+	// Four inner trees of four leaves each, whose roots are the leaves of the outer tree.
+	innerRoots := make([][]byte, 4)
+	var innerProof merkle.SingleProof
+	for i := range innerRoots {
+		leaves := make([][]byte, 4)
+		for j := range leaves {
+			leaves[j] = NewNodeFromUint64(uint64(4*i + j))
+		}
+		proof, root := buildTree(leaves, 1)
+		if i == 2 {
+			innerProof = proof
+		}
+		innerRoots[i] = root
+	}
+	outerProof, outerRoot := buildTree(innerRoots, 2)
+
+	valid, err := merkle.VerifyHierarchical(innerProof, 1, outerProof, outerRoot, GetSha256Parent)
+	req.NoError(err)
+	req.True(valid)
+
+	// The leaf is in a different inner tree than the one proven by the outer proof.
+	wrongOuterProof, _ := buildTree(innerRoots, 3)
+	valid, err = merkle.VerifyHierarchical(innerProof, 1, wrongOuterProof, outerRoot, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	tampered := innerProof
+	tampered.Leaf = NewNodeFromUint64(100)
+	valid, err = merkle.VerifyHierarchical(tampered, 1, outerProof, outerRoot, GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	valid, err = merkle.VerifyHierarchical(innerProof, 1, outerProof, NewNodeFromUint64(0), GetSha256Parent)
+	req.NoError(err)
+	req.False(valid)
+
+	_, err = merkle.VerifyHierarchical(innerProof, 0, outerProof, outerRoot, GetSha256Parent)
+	req.EqualError(err, "inner proof is for leaf 1, not 0")
+}
+
+func TestValidateBatch(t *testing.T) {
+	req := require.New(t)
+
+	const width = 16
+	cacheWriter := cache.NewWriter(cache.MinHeightPolicy(0), cache.MakeSliceReadWriterFactory())
+	tree, err := NewTreeBuilder().WithCacheWriter(cacheWriter).Build()
+	req.NoError(err)
+	for i := uint64(0); i < width; i++ {
+		req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root := tree.Root()
+	cacheReader, err := cacheWriter.GetReader()
+	req.NoError(err)
+
+	var reqs []merkle.ValidationRequest
+	var expected []bool
+	for i := uint64(0); i < width; i++ {
+		indices, leaves, proof, err := GenerateProof(setOf(i, (i+5)%width), cacheReader)
+		req.NoError(err)
+		valid := i%3 != 0
+		if !valid {
+			leaves[0] = NewNodeFromUint64(width + i)
+		}
+		reqs = append(reqs, merkle.ValidationRequest{
+			LeafIndices:  indices,
+			Leaves:       leaves,
+			Proof:        proof,
+			ExpectedRoot: root,
+			Hash:         GetSha256Parent,
+		})
+		expected = append(expected, valid)
+	}
+	// A malformed request is reported in its own result.
+	reqs = append(reqs, merkle.ValidationRequest{
+		LeafIndices: []uint64{0, 1}, Leaves: [][]byte{root}, ExpectedRoot: root, Hash: GetSha256Parent,
+	})
+
+	for _, workers := range []int{1, 4, 100} {
+		results, err := merkle.ValidateBatch(reqs, workers)
+		req.NoError(err)
+		req.Len(results, len(reqs))
+		for i, valid := range expected {
+			req.NoError(results[i].Err, "request %d", i)
+			req.Equal(valid, results[i].Valid, "request %d", i)
+		}
+		req.Error(results[width].Err)
+		req.False(results[width].Valid)
+	}
+
+	_, err = merkle.ValidateBatch(reqs, 0)
+	req.EqualError(err, "number of workers must be positive, got 0")
+	results, err := merkle.ValidateBatch(nil, 4)
+	req.NoError(err)
+	req.Empty(results)
+}
+
+func TestValidateBatchStatefulHash(t *testing.T) {
+	req := require.New(t)
+
+	const width = 32
+	tree, err := NewTree()
+	req.NoError(err)
+	for i := uint64(0); i < width; i++ {
+		req.NoError(tree.AddLeaf(NewNodeFromUint64(i)))
+	}
+	root := tree.Root()
+
+	// HashFuncFromStdHash reuses a single hash.Hash, so it's not safe for concurrent use. Half of the requests share
+	// one, while the other half create their own. Run with -race to detect concurrent calls.
+	shared := merkle.HashFuncFromStdHash(sha256.New)
+	var reqs []merkle.ValidationRequest
+	for i := uint64(0); i < width; i++ {
+		proofTree, err := NewProvingTree(setOf(i))
+		req.NoError(err)
+		for j := uint64(0); j < width; j++ {
+			req.NoError(proofTree.AddLeaf(NewNodeFromUint64(j)))
+		}
+		r := merkle.ValidationRequest{
+			LeafIndices:  []uint64{i},
+			Leaves:       [][]byte{NewNodeFromUint64(i)},
+			Proof:        proofTree.Proof(),
+			ExpectedRoot: root,
+		}
+		if i%2 == 0 {
+			r.Hash = shared
+		} else {
+			r.NewHash = func() merkle.HashFunc { return merkle.HashFuncFromStdHash(sha256.New) }
+		}
+		reqs = append(reqs, r)
+	}
+
+	for _, workers := range []int{1, 8} {
+		results, err := merkle.ValidateBatch(reqs, workers)
+		req.NoError(err)
+		for i, result := range results {
+			req.NoError(result.Err, "request %d", i)
+			req.True(result.Valid, "request %d", i)
+		}
+	}
+}